@@ -23,16 +23,15 @@ import (
 	"path/filepath"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
-	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/pmezard/go-difflib/difflib"
 )
 
 var errExit = fmt.Errorf("encountered changes while running diff")
 
-func diffFile(c *config.Config, f *rule.File) error {
-	rel, err := filepath.Rel(c.RepoRoot, f.Path)
+func diffFile(c *config.Config, f *outputFile) error {
+	rel, err := filepath.Rel(c.RepoRoot, f.path)
 	if err != nil {
-		return fmt.Errorf("error getting old path for file %q: %v", f.Path, err)
+		return fmt.Errorf("error getting old path for file %q: %v", f.path, err)
 	}
 	rel = filepath.ToSlash(rel)
 
@@ -47,32 +46,30 @@ func diffFile(c *config.Config, f *rule.File) error {
 		ToDate:   date,
 	}
 
-	newContent := f.Format()
-	if bytes.Equal(newContent, f.Content) {
+	if bytes.Equal(f.newContent, f.content) {
 		// No change.
 		return nil
 	}
 
-	if _, err := os.Stat(f.Path); os.IsNotExist(err) {
+	if _, err := os.Stat(f.path); os.IsNotExist(err) {
 		diff.FromFile = "/dev/null"
 	} else if err != nil {
 		return fmt.Errorf("error reading original file: %v", err)
 	} else if c.ReadBuildFilesDir == "" {
 		diff.FromFile = rel
 	} else {
-		diff.FromFile = f.Path
+		diff.FromFile = f.path
 	}
 
-	if len(f.Content) != 0 {
-		diff.A = difflib.SplitLines(string(f.Content))
+	if len(f.content) != 0 {
+		diff.A = difflib.SplitLines(string(f.content))
 	}
 
-	diff.B = difflib.SplitLines(string(newContent))
-	outPath := findOutputPath(c, f)
+	diff.B = difflib.SplitLines(string(f.newContent))
 	if c.WriteBuildFilesDir == "" {
 		diff.ToFile = rel
 	} else {
-		diff.ToFile = outPath
+		diff.ToFile = f.outPath
 	}
 
 	uc := getUpdateConfig(c)
@@ -81,7 +78,7 @@ func diffFile(c *config.Config, f *rule.File) error {
 		out = &uc.patchBuffer
 	}
 	if err := difflib.WriteUnifiedDiff(out, diff); err != nil {
-		return fmt.Errorf("error diffing %s: %v", f.Path, err)
+		return fmt.Errorf("error diffing %s: %v", f.path, err)
 	}
 	if ds, _ := difflib.GetUnifiedDiffString(diff); ds != "" {
 		return errExit
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -30,6 +31,7 @@ import (
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
+	"github.com/bazelbuild/bazel-gazelle/internal/module"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
@@ -54,14 +56,123 @@ type updateConfig struct {
 	patchBuffer    bytes.Buffer
 	print0         bool
 	profile        profiler
+
+	// twoPass is set by -two_pass. Rules are generated in a first walk to
+	// build the index, then generated again in a second walk, and each
+	// package is resolved and emitted before the next is generated. Results
+	// of the first walk aren't saved to disk instead, since the imports
+	// languages return with generated rules are opaque values that can't be
+	// serialized.
+	twoPass bool
+
+	// writes holds files staged by fixFile. They're written together at the
+	// end of the run.
+	writes fileTransaction
+
+	// outputs maps paths of files emitted in idempotency mode to their
+	// formatted content, and inputs maps the same paths to the content
+	// Gazelle read. They're nil in other modes. Paths in diffs are relative
+	// to repoRoot.
+	outputs, inputs map[string][]byte
+	repoRoot        string
+
+	// legacyRepoNames maps names of repositories in WORKSPACE to their
+	// apparent names in MODULE.bazel, where they differ. It's only set in
+	// fix mode, which migrates references to the new names.
+	legacyRepoNames map[string]string
+}
+
+// emitFunc emits a file Gazelle updated or generated, according to -mode.
+type emitFunc func(c *config.Config, f *outputFile) error
+
+// outputFile is a file passed to an emitFunc: a build file or WORKSPACE file
+// Gazelle updated, or another file a language generated.
+type outputFile struct {
+	// path is the absolute path the file was read from, or would be read
+	// from if it doesn't exist yet.
+	path string
+
+	// outPath is the absolute path the file should be written to. It's the
+	// same as path unless -experimental_read_build_files_dir or
+	// -experimental_write_build_files_dir is set.
+	outPath string
+
+	// content is the file's current content, and newContent is the content
+	// Gazelle produced.
+	content, newContent []byte
+}
+
+// ruleOutputFile returns an outputFile for a build file or WORKSPACE file.
+func ruleOutputFile(c *config.Config, f *rule.File) *outputFile {
+	return &outputFile{
+		path:       f.Path,
+		outPath:    findOutputPath(c, f),
+		content:    f.Content,
+		newContent: f.Format(),
+	}
+}
+
+// languageOutputFile returns an outputFile for a file generated by a
+// language, other than the build file of the package being updated. Files
+// with paths outside the repository are rejected.
+func languageOutputFile(c *config.Config, of language.OutputFile) (*outputFile, error) {
+	if of.File != nil {
+		if !isInRepo(c, of.File.Path) {
+			return nil, fmt.Errorf("output file %q: path must be in the repository", of.File.Path)
+		}
+		return ruleOutputFile(c, of.File), nil
+	}
+	rel := path.Clean(of.Path)
+	if of.Path == "" || path.IsAbs(rel) || !isRepoRel(rel) {
+		return nil, fmt.Errorf("output file %q: path must be relative to the repository root", of.Path)
+	}
+	readDir, writeDir := c.RepoRoot, c.RepoRoot
+	if c.ReadBuildFilesDir != "" {
+		readDir = c.ReadBuildFilesDir
+	}
+	if c.WriteBuildFilesDir != "" {
+		writeDir = c.WriteBuildFilesDir
+	}
+	f := &outputFile{
+		path:       filepath.Join(readDir, filepath.FromSlash(rel)),
+		outPath:    filepath.Join(writeDir, filepath.FromSlash(rel)),
+		newContent: of.Content,
+	}
+	if data, ok := c.BuildFileOverlay[f.path]; ok {
+		f.content = data
+	} else if data, err := os.ReadFile(f.path); err == nil {
+		f.content = data
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return f, nil
 }
 
-type emitFunc func(c *config.Config, f *rule.File) error
+// isInRepo returns whether the absolute path p is in the repository, or in
+// the directory build files are read from.
+func isInRepo(c *config.Config, p string) bool {
+	for _, dir := range []string{c.RepoRoot, c.ReadBuildFilesDir} {
+		if dir == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil && isRepoRel(filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRepoRel returns whether rel, a clean slash-separated relative path,
+// doesn't refer to a parent directory.
+func isRepoRel(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
 
 var modeFromName = map[string]emitFunc{
-	"print": printFile,
-	"fix":   fixFile,
-	"diff":  diffFile,
+	"print":       printFile,
+	"fix":         fixFile,
+	"diff":        diffFile,
+	"idempotency": captureFile,
 }
 
 const updateName = "_update"
@@ -85,10 +196,11 @@ func (ucr *updateConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *conf
 
 	c.ShouldFix = cmd == "fix"
 
-	fs.StringVar(&ucr.mode, "mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
+	fs.StringVar(&ucr.mode, "mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff\n\tidempotency: runs twice in memory and reports files that change in the second run")
 	fs.BoolVar(&ucr.recursive, "r", true, "when true, gazelle will update subdirectories recursively")
-	fs.StringVar(&uc.patchPath, "patch", "", "when set with -mode=diff, gazelle will write to a file instead of stdout")
+	fs.StringVar(&uc.patchPath, "patch", "", "when set with -mode=diff or -mode=idempotency, gazelle will write to a file instead of stdout")
 	fs.BoolVar(&uc.print0, "print0", false, "when set with -mode=fix, gazelle will print the names of rewritten files separated with \\0 (NULL)")
+	fs.BoolVar(&uc.twoPass, "two_pass", false, "when true, gazelle generates rules in two passes: one to build the index, and one to resolve and emit each package, so generated rules aren't held in memory until all packages are resolved")
 	fs.StringVar(&ucr.cpuProfile, "cpuprofile", "", "write cpu profile to `file`")
 	fs.StringVar(&ucr.memProfile, "memprofile", "", "write memory profile to `file`")
 	fs.Var(&gzflag.MultiFlag{Values: &ucr.knownImports}, "known_import", "import path for which external resolution is skipped (can specify multiple times)")
@@ -103,8 +215,16 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	if !ok {
 		return fmt.Errorf("unrecognized emit mode: %q", ucr.mode)
 	}
-	if uc.patchPath != "" && ucr.mode != "diff" {
-		return fmt.Errorf("-patch set but -mode is %s, not diff", ucr.mode)
+	if uc.patchPath != "" && ucr.mode != "diff" && ucr.mode != "idempotency" {
+		return fmt.Errorf("-patch set but -mode is %s, not diff or idempotency", ucr.mode)
+	}
+	if ucr.mode == "idempotency" {
+		if c.ReadBuildFilesDir != "" || c.WriteBuildFilesDir != "" {
+			return fmt.Errorf("-mode=idempotency can't be used with -experimental_read_build_files_dir or -experimental_write_build_files_dir")
+		}
+		uc.outputs = make(map[string][]byte)
+		uc.inputs = make(map[string][]byte)
+		uc.repoRoot = c.RepoRoot
 	}
 	if uc.patchPath != "" && !filepath.IsAbs(uc.patchPath) {
 		uc.patchPath = filepath.Join(c.WorkDir, uc.patchPath)
@@ -152,7 +272,7 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	if ucr.repoConfigPath == "" {
 		ucr.repoConfigPath = wspace.FindWORKSPACEFile(c.RepoRoot)
 	}
-	repoConfigFile, err := rule.LoadWorkspaceFile(ucr.repoConfigPath, "")
+	repoConfigFile, err := loadWorkspaceFile(c, ucr.repoConfigPath)
 	if err != nil && !os.IsNotExist(err) && !isDirErr(err) {
 		return err
 	} else if err == nil {
@@ -168,11 +288,22 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 		})
 	}
 
+	// In fix mode, find repositories that are named differently in WORKSPACE
+	// and MODULE.bazel, so references to them can be migrated.
+	if c.ShouldFix {
+		uc.legacyRepoNames, err = module.ExtractLegacyToApparentNameMapping(c.RepoRoot, c.Repos)
+		if err != nil {
+			return fmt.Errorf("failed to parse MODULE.bazel: %v", err)
+		}
+	}
+
 	for _, r := range c.Repos {
 		if r.Kind() == "go_repository" {
 			var name string
 			if apparentName := c.ModuleToApparentName(r.AttrString("module_name")); apparentName != "" {
 				name = apparentName
+			} else if apparentName, ok := uc.legacyRepoNames[r.Name()]; ok {
+				name = apparentName
 			} else {
 				name = r.Name()
 			}
@@ -190,7 +321,7 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	if ucr.repoConfigPath == workspacePath {
 		workspace = repoConfigFile
 	} else {
-		workspace, err = rule.LoadWorkspaceFile(workspacePath, "")
+		workspace, err = loadWorkspaceFile(c, workspacePath)
 		if err != nil && !os.IsNotExist(err) && !isDirErr(err) {
 			return err
 		}
@@ -216,6 +347,15 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	return nil
 }
 
+// loadWorkspaceFile loads the WORKSPACE file at path, reading it from
+// c.BuildFileOverlay if it's there.
+func loadWorkspaceFile(c *config.Config, path string) (*rule.File, error) {
+	if data, ok := c.BuildFileOverlay[path]; ok {
+		return rule.LoadWorkspaceData(path, "", data)
+	}
+	return rule.LoadWorkspaceFile(path, "")
+}
+
 func (ucr *updateConfigurer) KnownDirectives() []string { return nil }
 
 func (ucr *updateConfigurer) Configure(c *config.Config, rel string, f *rule.File) {}
@@ -245,6 +385,19 @@ type visitRecord struct {
 	// mappedKinds are mapped kinds used during this visit.
 	mappedKinds    []config.MappedKind
 	mappedKindInfo map[string]rule.KindInfo
+
+	// outputFiles are other files generated by languages in this directory.
+	outputFiles []language.OutputFile
+}
+
+// pendingVisit stores rules generated in a visited directory that haven't
+// been merged into its build file yet.
+type pendingVisit struct {
+	pkg *language.GeneratedPackage
+
+	// oldNames are the names of rules in the build file before it was fixed,
+	// used to find renamed rules.
+	oldNames map[*rule.Rule]string
 }
 
 var genericLoads = []rule.LoadInfo{
@@ -254,7 +407,24 @@ var genericLoads = []rule.LoadInfo{
 	},
 }
 
-func runFixUpdate(wd string, cmd command, args []string) (err error) {
+func runFixUpdate(ctx context.Context, wd string, cmd command, args []string) error {
+	first, err := fixUpdate(ctx, wd, cmd, args, nil)
+	if err != nil || first.outputs == nil {
+		return err
+	}
+
+	// In idempotency mode, run again over the files produced by the first
+	// run, and report files that change.
+	second, err := fixUpdate(ctx, wd, cmd, args, first.outputs)
+	if err != nil {
+		return err
+	}
+	return checkIdempotency(first, second)
+}
+
+// fixUpdate runs the fix or update command. Build files in overlay are read
+// instead of the files on disk (see config.Config.BuildFileOverlay).
+func fixUpdate(ctx context.Context, wd string, cmd command, args []string, overlay map[string][]byte) (_ *updateConfig, err error) {
 	cexts := make([]config.Configurer, 0, len(languages)+4)
 	cexts = append(cexts,
 		&config.CommonConfigurer{},
@@ -266,9 +436,9 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 		cexts = append(cexts, lang)
 	}
 
-	c, err := newFixUpdateConfiguration(wd, cmd, args, cexts)
+	c, err := newFixUpdateConfiguration(wd, cmd, args, cexts, overlay)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mrslv := newMetaResolver()
@@ -290,10 +460,11 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 	ruleIndex := resolve.NewRuleIndex(mrslv.Resolver, exts...)
 
 	if err := fixRepoFiles(c, loads); err != nil {
-		return err
+		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// Stop work when Gazelle is interrupted or -timeout expires.
+	ctx, cancel := withTimeout(ctx, c.Timeout)
 	defer cancel()
 	for _, lang := range languages {
 		if life, ok := lang.(language.LifecycleManager); ok {
@@ -309,35 +480,42 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 			log.Printf("stopping profiler: %v", err)
 		}
 	}()
+	// Remove staged files if Gazelle stops before writing them.
+	defer uc.writes.discard()
 
 	var errorsFromWalk []error
-	walk.Walk(c, cexts, uc.dirs, uc.walkMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
-		// If this file is ignored or if Gazelle was not asked to update this
-		// directory, just index the build file and move on.
-		if !update {
-			if c.IndexLibraries && f != nil {
-				for _, repl := range c.KindMap {
-					mrslv.MappedKind(rel, repl)
-				}
-				for _, r := range f.Rules {
-					ruleIndex.AddRule(c, r, f)
-				}
-			}
-			return
-		}
+	var referenceFiles []*referenceFile
+	renames := make(map[label.Label][]label.Label)
 
+	// generate fixes the build file in an updated directory and generates
+	// rules. Generated rules are merged into the file later by mergeRules.
+	generate := func(dir, rel string, c *config.Config, f *rule.File, subdirs, regularFiles, genFiles []string) *pendingVisit {
 		// Fix any problems in the file.
+		oldNames := ruleNames(f)
 		if f != nil {
 			for _, l := range filterLanguages(c, languages) {
-				l.Fix(c, f)
+				if cf, ok := l.(language.ContextFixer); ok {
+					cf.FixContext(ctx, c, f)
+				} else {
+					l.Fix(c, f)
+				}
 			}
+			migrateRepoNames(f, kinds, uc.legacyRepoNames)
+
+			// Replace macro calls with the rules they declare, so generated
+			// rules can be merged into them. They're collapsed back into
+			// macro calls after dependencies are resolved.
+			f.ExpandMacros(c.Macros)
 		}
 
 		// Generate rules.
 		var empty, gen []*rule.Rule
 		var imports []interface{}
+		var outputFiles []language.OutputFile
+		var mappedKinds []config.MappedKind
+		var replaced map[string][]string
 		for _, l := range filterLanguages(c, languages) {
-			res := l.GenerateRules(language.GenerateArgs{
+			genArgs := language.GenerateArgs{
 				Config:       c,
 				Dir:          dir,
 				Rel:          rel,
@@ -347,16 +525,63 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 				GenFiles:     genFiles,
 				OtherEmpty:   empty,
 				OtherGen:     gen,
-			})
+			}
+			var res language.GenerateResult
+			if cg, ok := l.(language.ContextGenerator); ok {
+				res = cg.GenerateRulesContext(ctx, genArgs)
+			} else {
+				res = l.GenerateRules(genArgs)
+			}
 			if len(res.Gen) != len(res.Imports) {
 				log.Panicf("%s: language %s generated %d rules but returned %d imports", rel, l.Name(), len(res.Gen), len(res.Imports))
 			}
 			empty = append(empty, res.Empty...)
 			gen = append(gen, res.Gen...)
 			imports = append(imports, res.Imports...)
+			outputFiles = append(outputFiles, res.OutputFiles...)
+			mappedKinds = append(mappedKinds, res.MappedKinds...)
+			for name, repls := range res.Replaced {
+				if replaced == nil {
+					replaced = make(map[string][]string)
+				}
+				replaced[name] = repls
+			}
+		}
+		return &pendingVisit{
+			pkg: &language.GeneratedPackage{
+				Config:      c,
+				Dir:         dir,
+				Rel:         rel,
+				File:        f,
+				Gen:         gen,
+				Empty:       empty,
+				Imports:     imports,
+				OutputFiles: outputFiles,
+				MappedKinds: mappedKinds,
+				Replaced:    replaced,
+			},
+			oldNames: oldNames,
+		}
+	}
+
+	// mergeRules merges generated rules into the build file of an updated
+	// directory before dependencies are resolved. Rules renamed in the file,
+	// and rules deleted and replaced by rules with other names, are added to
+	// renames. It returns nil if there's no build file, no rules
+	// were generated, and no other files were generated.
+	mergeRules := func(pv *pendingVisit) *visitRecord {
+		p := pv.pkg
+		c, rel, f := p.Config, p.Rel, p.File
+		gen, empty, imports, outputFiles := p.Gen, p.Empty, p.Imports, p.OutputFiles
+		if len(gen) != len(imports) {
+			log.Panicf("%s: %d rules were generated but %d imports were returned", rel, len(gen), len(imports))
 		}
 		if f == nil && len(gen) == 0 {
-			return
+			if len(outputFiles) == 0 {
+				return nil
+			}
+			// There's no build file to create, but other files were generated.
+			return &visitRecord{pkgRel: rel, c: c, outputFiles: outputFiles}
 		}
 
 		// Apply and record relevant kind mappings.
@@ -364,6 +589,26 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 			mappedKinds    []config.MappedKind
 			mappedKindInfo = make(map[string]rule.KindInfo)
 		)
+		// Kinds configured by languages are merged and resolved like the kinds
+		// they're mapped from. They may be mapped again with map_kind.
+		langMappedKinds := make(map[string]config.MappedKind)
+		for _, mk := range p.MappedKinds {
+			langMappedKinds[mk.KindName] = mk
+			mappedKindInfo[mk.KindName] = kinds[mk.FromKind]
+			mappedKinds = append(mappedKinds, mk)
+			mrslv.MappedKind(rel, mk)
+		}
+		recordReplacement := func(ruleKind string, repl config.MappedKind) {
+			info := kinds[ruleKind]
+			if mk, ok := langMappedKinds[ruleKind]; ok {
+				info = kinds[mk.FromKind]
+				repl.FromKind = mk.FromKind
+			}
+			mappedKindInfo[repl.KindName] = info
+			mappedKinds = append(mappedKinds, repl)
+			mrslv.MappedKind(rel, repl)
+		}
+
 		// We apply map_kind to all rules, including pre-existing ones.
 		var allRules []*rule.Rule
 		allRules = append(allRules, gen...)
@@ -377,9 +622,7 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 				return nil, err
 			}
 			if repl != nil {
-				mappedKindInfo[repl.KindName] = kinds[ruleKind]
-				mappedKinds = append(mappedKinds, *repl)
-				mrslv.MappedKind(rel, *repl)
+				recordReplacement(ruleKind, *repl)
 				return &repl.KindName, nil
 			}
 			return nil, nil
@@ -415,24 +658,27 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 		}
 		for _, r := range empty {
 			if repl, ok := c.KindMap[r.Kind()]; ok {
-				mappedKindInfo[repl.KindName] = kinds[r.Kind()]
-				mappedKinds = append(mappedKinds, repl)
-				mrslv.MappedKind(rel, repl)
+				recordReplacement(r.Kind(), repl)
 				r.SetKind(repl.KindName)
 			}
 		}
 
 		// Insert or merge rules into the build file.
 		if f == nil {
-			f = rule.EmptyFile(filepath.Join(dir, c.DefaultBuildFileName()), rel)
+			f = rule.EmptyFile(filepath.Join(p.Dir, c.DefaultBuildFileName()), rel)
+			setAttrFormats(c, f, gen)
 			for _, r := range gen {
 				r.Insert(f)
 			}
 		} else {
-			merger.MergeFile(f, empty, gen, merger.PreResolve,
-				unionKindInfoMaps(kinds, mappedKindInfo))
+			setAttrFormats(c, f, f.Rules)
+			setAttrFormats(c, f, gen)
+			merger.MergeFileWithRepoMapping(f, empty, gen, merger.PreResolve,
+				unionKindInfoMaps(kinds, mappedKindInfo), c.RepoMapping)
 		}
-		visits = append(visits, visitRecord{
+		renamedRules(renames, f, pv.oldNames)
+		replacedRules(renames, f, p.Replaced)
+		return &visitRecord{
 			pkgRel:         rel,
 			c:              c,
 			rules:          gen,
@@ -441,24 +687,146 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 			file:           f,
 			mappedKinds:    mappedKinds,
 			mappedKindInfo: mappedKindInfo,
-		})
+			outputFiles:    outputFiles,
+		}
+	}
+
+	// indexVisit adds library rules in an updated directory to the dependency
+	// resolution table.
+	indexVisit := func(v *visitRecord) {
+		if v.c.IndexLibraries && v.file != nil {
+			for _, r := range v.file.Rules {
+				ruleIndex.AddRuleContext(ctx, v.c, r, v.file)
+			}
+		}
+	}
+
+	var postWalkGenerators []language.PostWalkGenerator
+	for _, lang := range filterLanguages(c, languages) {
+		if pwg, ok := lang.(language.PostWalkGenerator); ok {
+			postWalkGenerators = append(postWalkGenerators, pwg)
+		}
+	}
+	// needsPostWalk reports whether merging must wait until rules are
+	// generated after the walk. Once it returns true, it keeps returning true.
+	postWalk := false
+	needsPostWalk := func() bool {
+		for _, pwg := range postWalkGenerators {
+			if postWalk {
+				break
+			}
+			cpwg, ok := pwg.(language.ConditionalPostWalkGenerator)
+			postWalk = !ok || cpwg.NeedsPostWalk()
+		}
+		return postWalk
+	}
+	errTwoPass := errors.New("-two_pass can't be used with languages that generate rules after the walk")
+	if uc.twoPass && needsPostWalk() {
+		return nil, errTwoPass
+	}
+	reportedTwoPass := false
+	var pending []*pendingVisit
 
-		// Add library rules to the dependency resolution table.
-		if c.IndexLibraries {
-			for _, r := range f.Rules {
-				ruleIndex.AddRule(c, r, f)
+	walk.WalkContext(ctx, c, cexts, uc.dirs, uc.walkMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+		// Skip the remaining directories if Gazelle is stopping early.
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Remember files that may refer to rules renamed in this run or to
+		// repositories with legacy names.
+		for _, name := range regularFiles {
+			if strings.HasSuffix(name, ".bzl") {
+				referenceFiles = append(referenceFiles, &referenceFile{c: c, path: filepath.Join(dir, name), pkg: rel})
+			}
+		}
+		if !update && f != nil {
+			referenceFiles = append(referenceFiles, &referenceFile{c: c, path: f.Path, pkg: rel, isBuildFile: true})
+		}
+
+		// If this file is ignored or if Gazelle was not asked to update this
+		// directory, just index the build file and move on.
+		if !update {
+			if c.IndexLibraries && f != nil {
+				for _, repl := range c.KindMap {
+					mrslv.MappedKind(rel, repl)
+				}
+				for _, r := range f.Rules {
+					if info, ok := c.Macros[r.Kind()]; ok && r.Name() != "" {
+						for _, mr := range rule.ExpandMacro(r, info) {
+							ruleIndex.AddRuleContext(ctx, c, mr, f)
+						}
+						continue
+					}
+					ruleIndex.AddRuleContext(ctx, c, r, f)
+				}
+			}
+			return
+		}
+
+		pv := generate(dir, rel, c, f, subdirs, regularFiles, genFiles)
+
+		// If languages generate rules after the walk, merging waits until
+		// they're done.
+		if needsPostWalk() {
+			if uc.twoPass {
+				if !reportedTwoPass {
+					errorsFromWalk = append(errorsFromWalk, errTwoPass)
+					reportedTwoPass = true
+				}
+				return
+			}
+			pending = append(pending, pv)
+			return
+		}
+
+		// In two-pass mode, packages are generated again once the index is
+		// complete, so the results aren't kept.
+		if v := mergeRules(pv); v != nil {
+			indexVisit(v)
+			if !uc.twoPass {
+				visits = append(visits, *v)
 			}
 		}
 	})
 
-	for _, lang := range languages {
-		if finishable, ok := lang.(language.FinishableLanguage); ok {
-			finishable.DoneGeneratingRules()
+	// Let languages add, modify, or remove rules with knowledge of every
+	// updated package, then merge and index the results.
+	if len(pending) > 0 && ctx.Err() == nil {
+		args := language.PostWalkArgs{Config: c, Packages: make([]*language.GeneratedPackage, len(pending))}
+		for i, pv := range pending {
+			args.Packages[i] = pv.pkg
+		}
+		for _, pwg := range postWalkGenerators {
+			if cpwg, ok := pwg.(language.ContextPostWalkGenerator); ok {
+				cpwg.GenerateRulesAfterWalkContext(ctx, args)
+			} else {
+				pwg.GenerateRulesAfterWalk(args)
+			}
 		}
+		for _, pv := range pending {
+			if v := mergeRules(pv); v != nil {
+				indexVisit(v)
+				visits = append(visits, *v)
+			}
+		}
+		pending = nil
+	}
+
+	// In two-pass mode, rules are generated again below, unless there were
+	// errors.
+	if !uc.twoPass || len(errorsFromWalk) > 0 {
+		doneGeneratingRules()
+	}
+	if err := contextError(ctx, c); err != nil {
+		if uc.twoPass && len(errorsFromWalk) == 0 {
+			doneGeneratingRules()
+		}
+		return nil, err
 	}
 
 	if len(errorsFromWalk) == 1 {
-		return errorsFromWalk[0]
+		return nil, errorsFromWalk[0]
 	}
 
 	if len(errorsFromWalk) > 1 {
@@ -467,14 +835,14 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 			additionalErrors = append(additionalErrors, error.Error())
 		}
 
-		return fmt.Errorf("encountered multiple errors: %w, %v", errorsFromWalk[0], strings.Join(additionalErrors, ", "))
+		return nil, fmt.Errorf("encountered multiple errors: %w, %v", errorsFromWalk[0], strings.Join(additionalErrors, ", "))
 	}
 
 	// Finish building the index for dependency resolution.
 	ruleIndex.Finish()
 
 	// Resolve dependencies.
-	rc, cleanupRc := repo.NewRemoteCache(uc.repos)
+	rc, cleanupRc := repo.NewRemoteCacheContext(ctx, uc.repos)
 	defer func() {
 		if cerr := cleanupRc(); err == nil && cerr != nil {
 			err = cerr
@@ -483,15 +851,66 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 	if err := maybePopulateRemoteCacheFromGoMod(c, rc); err != nil {
 		log.Print(err)
 	}
-	for _, v := range visits {
-		for i, r := range v.rules {
-			from := label.New(c.RepoName, v.pkgRel, r.Name())
-			if rslv := mrslv.Resolver(r, v.pkgRel); rslv != nil {
-				rslv.Resolve(v.c, ruleIndex, rc, r, v.imports[i], from)
+
+	// Resolve dependencies in each updated package, merge them, and emit the
+	// build file. Records are released as soon as their files are emitted,
+	// so that memory isn't held for every package at once.
+	var exit error
+	emitFile := func(c *config.Config, f *outputFile) {
+		if err := uc.emit(c, f); err != nil {
+			if err == errExit {
+				exit = err
+			} else {
+				log.Print(err)
+			}
+		}
+	}
+	finish := func(v *visitRecord) {
+		if v.file != nil {
+			for i, r := range v.rules {
+				from := label.New(c.RepoName, v.pkgRel, r.Name())
+				if rslv := mrslv.Resolver(r, v.pkgRel); rslv != nil {
+					resolve.ResolveRule(ctx, rslv, v.c, ruleIndex, rc, r, v.imports[i], from)
+				}
+			}
+			merger.MergeFileWithRepoMapping(v.file, v.empty, v.rules, merger.PostResolve,
+				unionKindInfoMaps(kinds, v.mappedKindInfo), v.c.RepoMapping)
+			v.file.CollapseMacros()
+
+			// Rewrite references to rules that were renamed in this run.
+			rewriteRenamedLabels(v.file, v.c.RepoName, renames, true)
+
+			merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, loads))
+			emitFile(v.c, ruleOutputFile(v.c, v.file))
+		}
+		for _, of := range v.outputFiles {
+			f, err := languageOutputFile(v.c, of)
+			if err != nil {
+				log.Printf("%s: %v", v.pkgRel, err)
+				continue
 			}
+			emitFile(v.c, f)
 		}
-		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolve,
-			unionKindInfoMaps(kinds, v.mappedKindInfo))
+	}
+	if uc.twoPass {
+		walk.WalkContext(ctx, c, cexts, uc.dirs, updateOnly(uc.walkMode), func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+			if !update || ctx.Err() != nil {
+				return
+			}
+			if v := mergeRules(generate(dir, rel, c, f, subdirs, regularFiles, genFiles)); v != nil {
+				finish(v)
+			}
+		})
+		doneGeneratingRules()
+	} else {
+		for i := range visits {
+			if ctx.Err() != nil {
+				break
+			}
+			finish(&visits[i])
+			visits[i] = visitRecord{}
+		}
+		visits = nil
 	}
 	for _, lang := range languages {
 		if life, ok := lang.(language.LifecycleManager); ok {
@@ -499,25 +918,55 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 		}
 	}
 
-	// Emit merged files.
-	var exit error
-	for _, v := range visits {
-		merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, loads))
-		if err := uc.emit(v.c, v.file); err != nil {
-			if err == errExit {
-				exit = err
-			} else {
+	// Don't write anything if Gazelle was stopped before every package was
+	// resolved.
+	if err := contextError(ctx, c); err != nil {
+		return nil, err
+	}
+
+	// Rewrite references to renamed rules and migrate legacy repository names
+	// in build files that weren't updated and in .bzl files.
+	if len(renames) > 0 || len(uc.legacyRepoNames) > 0 {
+		for _, rf := range referenceFiles {
+			f, changed, err := rf.rewrite(renames, kinds, uc.legacyRepoNames)
+			if err != nil {
 				log.Print(err)
+				continue
+			}
+			if changed {
+				emitFile(rf.c, ruleOutputFile(rf.c, f))
 			}
 		}
 	}
 	if uc.patchPath != "" {
 		if err := os.WriteFile(uc.patchPath, uc.patchBuffer.Bytes(), 0o666); err != nil {
-			return err
+			return nil, err
+		}
+	}
+
+	// Write the files staged in fix mode. If any write fails, the files are
+	// restored, and nothing is changed.
+	written := uc.writes.staged()
+	if err := uc.writes.commit(); err != nil {
+		return nil, fmt.Errorf("writing files: %v", err)
+	}
+	if uc.print0 {
+		for _, path := range written {
+			fmt.Printf("%s\x00", path)
 		}
 	}
 
-	return exit
+	return uc, exit
+}
+
+// doneGeneratingRules notifies languages that no more rules will be
+// generated.
+func doneGeneratingRules() {
+	for _, lang := range languages {
+		if finishable, ok := lang.(language.FinishableLanguage); ok {
+			finishable.DoneGeneratingRules()
+		}
+	}
 }
 
 // lookupMapKindReplacement finds a mapped replacement for rule kind `kind`, resolving transitively.
@@ -551,9 +1000,10 @@ func lookupMapKindReplacement(kindMap map[string]config.MappedKind, kind string)
 	return mapped, nil
 }
 
-func newFixUpdateConfiguration(wd string, cmd command, args []string, cexts []config.Configurer) (*config.Config, error) {
+func newFixUpdateConfiguration(wd string, cmd command, args []string, cexts []config.Configurer, overlay map[string][]byte) (*config.Config, error) {
 	c := config.New()
 	c.WorkDir = wd
+	c.BuildFileOverlay = overlay
 
 	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
 	// Flag will call this on any parse error. Don't print usage unless
@@ -598,6 +1048,8 @@ output mode determines what Gazelle does with updated BUILD files.
   fix (default) - write updated BUILD files back to disk.
   print - print updated BUILD files to stdout.
   diff - diff updated BUILD files against existing files in unified format.
+  idempotency - update BUILD files in memory, run again over the updated
+      files, and diff files that change in the second run. Nothing is written.
 
 Gazelle accepts a list of paths to Go package directories to process (defaults
 to the working directory if none are given). It recursively traverses
@@ -636,7 +1088,7 @@ func fixRepoFiles(c *config.Config, loads []rule.LoadInfo) error {
 				return err
 			}
 		}
-		if err := uc.emit(c, f); err != nil {
+		if err := uc.emit(c, ruleOutputFile(c, f)); err != nil {
 			return err
 		}
 	}
@@ -685,6 +1137,19 @@ func isDescendingDir(dir, root string) bool {
 	return !strings.HasPrefix(rel, "..")
 }
 
+// updateOnly returns a walk mode that visits only the directories mode
+// updates.
+func updateOnly(mode walk.Mode) walk.Mode {
+	switch mode {
+	case walk.VisitAllUpdateSubdirsMode:
+		return walk.UpdateSubdirsMode
+	case walk.VisitAllUpdateDirsMode:
+		return walk.UpdateDirsMode
+	default:
+		return mode
+	}
+}
+
 func findOutputPath(c *config.Config, f *rule.File) string {
 	if c.ReadBuildFilesDir == "" && c.WriteBuildFilesDir == "" {
 		return f.Path
@@ -800,3 +1265,14 @@ func isDirErr(err error) bool {
 	}
 	return false
 }
+
+// setAttrFormats sets the attribute order and sorted attributes configured
+// with # gazelle:attr_order and # gazelle:attr_sort for the kinds of rules
+// in f. The formats take effect when rules are created or modified.
+func setAttrFormats(c *config.Config, f *rule.File, rules []*rule.Rule) {
+	for _, r := range rules {
+		if format, ok := c.AttrFormat(r.Kind()); ok {
+			f.SetAttrFormat(r.Kind(), format)
+		}
+	}
+}
@@ -17,29 +17,15 @@ package main
 
 import (
 	"bytes"
-	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
-	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-func fixFile(c *config.Config, f *rule.File) error {
-	newContent := f.Format()
-	if bytes.Equal(f.Content, newContent) {
+// fixFile stages the new content of f to be written when the run finishes
+// (see fileTransaction). Files that haven't changed aren't staged.
+func fixFile(c *config.Config, f *outputFile) error {
+	if bytes.Equal(f.content, f.newContent) {
 		return nil
 	}
-	outPath := findOutputPath(c, f)
-	if err := os.MkdirAll(filepath.Dir(outPath), 0o777); err != nil {
-		return err
-	}
-	if err := os.WriteFile(outPath, newContent, 0o666); err != nil {
-		return err
-	}
-	f.Content = newContent
-	if getUpdateConfig(c).print0 {
-		fmt.Printf("%s\x00", outPath)
-	}
-	return nil
+	return getUpdateConfig(c).writes.stage(f.outPath, f.newContent)
 }
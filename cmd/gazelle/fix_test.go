@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -116,7 +117,7 @@ func TestCreateFile(t *testing.T) {
 	}
 
 	// Check that Gazelle creates a new file named "BUILD.bazel".
-	if err = run(dir, defaultArgs(dir)); err != nil {
+	if err = run(context.Background(), dir, defaultArgs(dir)); err != nil {
 		t.Fatalf("run failed: %v", err)
 	}
 
@@ -146,7 +147,7 @@ func TestUpdateFile(t *testing.T) {
 	}
 
 	// Check that Gazelle updates the BUILD file in place.
-	if err = run(dir, defaultArgs(dir)); err != nil {
+	if err = run(context.Background(), dir, defaultArgs(dir)); err != nil {
 		t.Fatalf("run failed: %v", err)
 	}
 
@@ -200,7 +201,7 @@ go_binary(
 	modTime := st.ModTime()
 
 	// Ensure that Gazelle does not write to the BUILD file.
-	if err = run(dir, defaultArgs(dir)); err != nil {
+	if err = run(context.Background(), dir, defaultArgs(dir)); err != nil {
 		t.Fatalf("run failed: %v", err)
 	}
 
@@ -358,7 +359,7 @@ go_library(
 				}
 				tc.args[i] = replacer.Replace(tc.args[i])
 			}
-			if err := run(dir, tc.args); err != nil {
+			if err := run(context.Background(), dir, tc.args); err != nil {
 				t.Error(err)
 			}
 			testtools.CheckFiles(t, dir, tc.want)
@@ -396,7 +397,7 @@ go_library(
 	defer cleanup()
 
 	// Check that Gazelle does not update the BUILD file, due to lang filter.
-	if err := run(dir, []string{
+	if err := run(context.Background(), dir, []string{
 		"-repo_root", dir,
 		"-go_prefix", "example.com/repo",
 		"-lang=proto",
@@ -642,7 +643,7 @@ go_library(
 			dir, cleanup := testtools.CreateFiles(t, tc.before)
 			defer cleanup()
 
-			if err := run(dir, []string{
+			if err := run(context.Background(), dir, []string{
 				"-repo_root", dir,
 				"-go_prefix", "example.com/repo",
 				dir,
@@ -18,10 +18,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language"
@@ -69,7 +72,15 @@ func main() {
 		}
 	}
 
-	if err := run(wd, os.Args[1:]); err != nil && err != flag.ErrHelp {
+	// Stop work on the first interrupt, killing any commands Gazelle started.
+	// Later interrupts stop the process immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := run(ctx, wd, os.Args[1:]); err != nil && err != flag.ErrHelp {
 		if err == errExit {
 			os.Exit(1)
 		} else {
@@ -78,7 +89,7 @@ func main() {
 	}
 }
 
-func run(wd string, args []string) error {
+func run(ctx context.Context, wd string, args []string) error {
 	cmd := updateCmd
 	if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
 		cmd = helpCmd
@@ -92,11 +103,11 @@ func run(wd string, args []string) error {
 
 	switch cmd {
 	case fixCmd, updateCmd:
-		return runFixUpdate(wd, cmd, args)
+		return runFixUpdate(ctx, wd, cmd, args)
 	case helpCmd:
 		return help()
 	case updateReposCmd:
-		return updateRepos(wd, args)
+		return updateRepos(ctx, wd, args)
 	default:
 		log.Panicf("unknown command: %v", cmd)
 	}
@@ -136,6 +147,28 @@ without notice.
 	return flag.ErrHelp
 }
 
+// withTimeout returns a context that's canceled when ctx is done or, if
+// timeout is positive, when timeout elapses.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// contextError returns an error explaining why Gazelle stopped early, or nil
+// if ctx isn't done.
+func contextError(ctx context.Context, c *config.Config) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return fmt.Errorf("timed out after %v", c.Timeout)
+	default:
+		return fmt.Errorf("interrupted")
+	}
+}
+
 // filterLanguages returns the subset of input languages that pass the config's
 // filter, if any. Gazelle should not generate rules for languages not returned.
 func filterLanguages(c *config.Config, langs []language.Language) []language.Language {
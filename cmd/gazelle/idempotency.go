@@ -0,0 +1,102 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// captureFile records the content of f in idempotency mode instead of
+// writing it. The recorded files are read instead of the files on disk in
+// the second run.
+func captureFile(c *config.Config, f *outputFile) error {
+	uc := getUpdateConfig(c)
+	uc.outputs[f.path] = f.newContent
+	uc.inputs[f.path] = f.content
+	return nil
+}
+
+// checkIdempotency compares files emitted by a second run over the output of
+// a first run with the output of the first run. Files emitted only by the
+// second run are compared with their content on disk. A unified diff is
+// printed for each file that changed (or written to the -patch file), and
+// errExit is returned if any did.
+func checkIdempotency(first, second *updateConfig) error {
+	paths := make([]string, 0, len(second.outputs))
+	for path := range second.outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// See diffFile for why this isn't the epoch.
+	date := "1970-01-01 00:00:00.000000001 +0000"
+
+	var out io.Writer = os.Stdout
+	if second.patchPath != "" {
+		out = &second.patchBuffer
+	}
+	var changed []string
+	for _, path := range paths {
+		want, ok := first.outputs[path]
+		if !ok {
+			want = second.inputs[path]
+		}
+		got := second.outputs[path]
+		if bytes.Equal(want, got) {
+			continue
+		}
+		changed = append(changed, path)
+
+		name := path
+		if rel, err := filepath.Rel(second.repoRoot, path); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+		diff := difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(want)),
+			B:        difflib.SplitLines(string(got)),
+			FromFile: name + " (first run)",
+			ToFile:   name + " (second run)",
+			FromDate: date,
+			ToDate:   date,
+			Context:  3,
+		}
+		if err := difflib.WriteUnifiedDiff(out, diff); err != nil {
+			return fmt.Errorf("error diffing %s: %v", path, err)
+		}
+	}
+	if second.patchPath != "" {
+		if err := os.WriteFile(second.patchPath, second.patchBuffer.Bytes(), 0o666); err != nil {
+			return err
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+	for _, path := range changed {
+		log.Printf("%s: changed when Gazelle ran again over its own output", path)
+	}
+	return errExit
+}
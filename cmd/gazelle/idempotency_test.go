@@ -0,0 +1,79 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestCheckIdempotency(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, nil)
+	defer cleanup()
+	same := filepath.Join(dir, "same", "BUILD.bazel")
+	changed := filepath.Join(dir, "changed", "BUILD.bazel")
+	secondOnly := filepath.Join(dir, "second_only", "BUILD.bazel")
+
+	first := &updateConfig{
+		outputs: map[string][]byte{
+			same:    []byte("a\n"),
+			changed: []byte("a\nb\n"),
+		},
+	}
+	second := &updateConfig{
+		outputs: map[string][]byte{
+			same:       []byte("a\n"),
+			changed:    []byte("b\na\n"),
+			secondOnly: []byte("x\n"),
+		},
+		inputs: map[string][]byte{
+			same:       []byte("a\n"),
+			changed:    []byte("a\nb\n"),
+			secondOnly: []byte("y\n"),
+		},
+		repoRoot:  dir,
+		patchPath: filepath.Join(dir, "p"),
+	}
+	if err := checkIdempotency(first, second); err != errExit {
+		t.Fatalf("got error %v; want %v", err, errExit)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "p",
+		Content: `
+--- changed/BUILD.bazel (first run)	1970-01-01 00:00:00.000000001 +0000
++++ changed/BUILD.bazel (second run)	1970-01-01 00:00:00.000000001 +0000
+@@ -1,3 +1,3 @@
++b
+ a
+-b
+ 
+--- second_only/BUILD.bazel (first run)	1970-01-01 00:00:00.000000001 +0000
++++ second_only/BUILD.bazel (second run)	1970-01-01 00:00:00.000000001 +0000
+@@ -1,2 +1,2 @@
+-y
++x
+ 
+`,
+	}})
+
+	second.outputs = map[string][]byte{same: []byte("a\n")}
+	if err := checkIdempotency(first, second); err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+}
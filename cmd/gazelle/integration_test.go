@@ -21,15 +21,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bazelbuild/bazel-gazelle/testtools"
 	"github.com/google/go-cmp/cmp"
 )
@@ -45,7 +56,7 @@ func skipIfWorkspaceVisible(t *testing.T, dir string) {
 }
 
 func runGazelle(wd string, args []string) error {
-	return run(wd, args)
+	return run(context.Background(), wd, args)
 }
 
 // TestHelp checks that help commands do not panic due to nil flag values.
@@ -97,6 +108,50 @@ func TestNoGoPrefixArgOrRule(t *testing.T) {
 	}
 }
 
+// TestCgoUnmappedLibraries checks that libraries linked by cgo code are only
+// reported as unmapped once a cgo mapping directive is in effect.
+func TestCgoUnmappedLibraries(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/m\n"},
+		{
+			Path: "foo/foo.go",
+			Content: `package foo
+
+/*
+#cgo LDFLAGS: -lm -lweird
+*/
+import "C"
+`,
+		},
+	})
+	defer cleanup()
+
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	if err := runGazelle(dir, []string{"-strict"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "not mapped") {
+		t.Errorf("got unmapped library reported without mapping directives:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := os.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte("# gazelle:prefix example.com/m\n# gazelle:go_cgo_library m\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := `cgo library "weird" is not mapped`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q\n--begin--\n%s--end--\n", want, buf.String())
+	}
+	if notWant := `cgo library "m" is not mapped`; strings.Contains(buf.String(), notWant) {
+		t.Errorf("log contains %q\n--begin--\n%s--end--\n", notWant, buf.String())
+	}
+}
+
 // TestSelectLabelsSorted checks that string lists in srcs and deps are sorted
 // using buildifier order, even if they are inside select expressions.
 // This applies to both new and existing lists and should preserve comments.
@@ -3454,6 +3509,284 @@ go_library(
 	})
 }
 
+func TestProtoSCCModeReplacesRules(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+		},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:proto scc
+# gazelle:go_generate_proto false
+`,
+		},
+		{
+			Path: "a/a.proto",
+			Content: `syntax = "proto3";
+
+import "a/b.proto";
+`,
+		},
+		{
+			Path: "a/b.proto",
+			Content: `syntax = "proto3";
+
+import "a/a.proto";
+import "a/c.proto";
+`,
+		},
+		{
+			Path:    "a/c.proto",
+			Content: `syntax = "proto3";`,
+		},
+		{
+			Path: "a/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "all_proto",
+    srcs = [
+        "a.proto",
+        "b.proto",
+        "c.proto",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "d/d.proto",
+			Content: `syntax = "proto3";
+
+import "a/c.proto";
+`,
+		},
+		{
+			Path: "d/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "d_proto",
+    srcs = ["d.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//a:all_proto"],
+)
+`,
+		},
+		{
+			Path: "e/BUILD.bazel",
+			Content: `cc_proto_library(
+    name = "e_cc_proto",
+    deps = ["//a:all_proto"],
+)
+
+alias(
+    name = "all",
+    actual = "//a:all_proto",
+)
+`,
+		},
+	}
+
+	wantA := testtools.FileSpec{
+		Path: "a/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "a_proto",
+    srcs = [
+        "a.proto",
+        "b.proto",
+    ],
+    visibility = ["//visibility:public"],
+    deps = [":c_proto"],
+)
+
+proto_library(
+    name = "c_proto",
+    srcs = ["c.proto"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}
+
+	// Hand-written rules that depend on the replaced rule depend on all the
+	// rules replacing it. A reference that isn't in a list is left alone.
+	wantE := testtools.FileSpec{
+		Path: "e/BUILD.bazel",
+		Content: `cc_proto_library(
+    name = "e_cc_proto",
+    deps = [
+        "//a:a_proto",
+        "//a:c_proto",
+    ],
+)
+
+alias(
+    name = "all",
+    actual = "//a:all_proto",
+)
+`,
+	}
+
+	t.Run("all", func(t *testing.T) {
+		dir, cleanup := testtools.CreateFiles(t, files)
+		defer cleanup()
+
+		if err := runGazelle(dir, []string{"update"}); err != nil {
+			t.Fatal(err)
+		}
+
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{wantA, wantE, {
+			Path: "d/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "d_proto",
+    srcs = ["d.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//a:c_proto"],
+)
+`,
+		}})
+	})
+
+	t.Run("only a", func(t *testing.T) {
+		dir, cleanup := testtools.CreateFiles(t, files)
+		defer cleanup()
+
+		if err := runGazelle(dir, []string{"update", "a"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Dependents outside the updated directory aren't resolved again, so
+		// they depend on all the replacements.
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{wantA, wantE, {
+			Path: "d/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "d_proto",
+    srcs = ["d.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//a:a_proto",
+        "//a:c_proto",
+    ],
+)
+`,
+		}})
+	})
+}
+
+func TestRenameRewritesReferences(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+		},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:prefix example.com/m
+# gazelle:go_naming_convention import
+`,
+		},
+		{
+			Path:    "lib/lib.go",
+			Content: "package lib",
+		},
+		{
+			Path: "lib/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/m/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "app/BUILD.bazel",
+			Content: `alias(
+    name = "lib",
+    actual = "//lib:go_default_library",
+)
+
+alias(
+    name = "short",
+    actual = "@//lib:go_default_library",
+)
+
+filegroup(
+    name = "kept",
+    srcs = ["//lib:go_default_library"],  # keep
+)
+`,
+		},
+		{
+			Path: "tools/defs.bzl",
+			Content: `DEPS = [
+    "//lib:go_default_library",
+    "@other//lib:go_default_library",
+]
+
+LOCAL = [":go_default_library"]
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"update", "lib"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "lib/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "lib",
+    srcs = ["lib.go"],
+    importpath = "example.com/m/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "app/BUILD.bazel",
+			Content: `alias(
+    name = "lib",
+    actual = "//lib:lib",
+)
+
+alias(
+    name = "short",
+    actual = "@//lib:lib",
+)
+
+filegroup(
+    name = "kept",
+    srcs = ["//lib:go_default_library"],  # keep
+)
+`,
+		},
+		{
+			Path: "tools/defs.bzl",
+			Content: `DEPS = [
+    "//lib:lib",
+    "@other//lib:go_default_library",
+]
+
+LOCAL = [":go_default_library"]
+`,
+		},
+	})
+}
+
 func TestGoMainLibraryRemoved(t *testing.T) {
 	files := []testtools.FileSpec{
 		{
@@ -4294,68 +4627,182 @@ go_library(
 	})
 }
 
-func TestMigrateSelectFromWorkspaceToBzlmod(t *testing.T) {
+func TestGoModTools(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{Path: "WORKSPACE"},
 		{
-			Path:    "MODULE.bazel",
-			Content: `bazel_dep(name = "rules_go", version = "0.39.1", repo_name = "my_rules_go")`,
-		},
-		{
-			Path: "BUILD",
-			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+			Path: "go.mod",
+			Content: `
+module example.com/use
 
-go_library(
-    name = "foo",
-    srcs = [
-        "bar.go",
-        "foo.go",
-        "foo_android.go",
-        "foo_android_build_tag.go",
-    ],
-    importpath = "example.com/foo",
-    visibility = ["//visibility:public"],
-    deps = select({
-        "@io_bazel_rules_go//go/platform:android": [
-            "//outer",
-            "//outer/inner",
-            "//outer_android_build_tag",
-            "//outer_android_suffix",
-            "@com_github_jr_hacker_tools//:go_default_library",
-        ],
-        "@io_bazel_rules_go//go/platform:linux": [
-            "//outer",
-            "//outer/inner",
-            "@com_github_jr_hacker_tools//:go_default_library",
-        ],
-        "//conditions:default": [],
-    }),
+go 1.24
+
+require golang.org/x/tools v0.1.0
+
+tool (
+	example.com/use/cmd/gen
+	golang.org/x/tools/cmd/stringer
 )
 `,
 		},
 		{
-			Path: "foo.go",
+			Path: "cmd/gen/main.go",
 			Content: `
-// +build linux
-
-package foo
+package main
 
-import (
-    _ "example.com/foo/outer"
-    _ "example.com/foo/outer/inner"
-    _ "github.com/jr_hacker/tools"
-)
+func main() {}
 `,
 		},
 		{
-			Path: "foo_android_build_tag.go",
+			// The directive is set below the module root, so the tools
+			// package reads the go.mod file in a parent directory.
+			Path: "tools/BUILD.bazel",
 			Content: `
-// +build android
+# gazelle:go_tools_package tools
 
-package foo
+# gazelle:go_tool example.com/removed/cmd/removed
+alias(
+    name = "removed",
+    actual = "@com_example_removed//cmd/removed",
+)
 
-import (
-    _ "example.com/foo/outer_android_build_tag"
+alias(
+    name = "handwritten",
+    actual = "@com_example_handwritten//cmd/handwritten",
+)
+
+alias(
+    name = "stringer",
+    actual = "@org_golang_x_tools//cmd/stringer:old",
+)
+
+alias(
+    name = "local",
+    actual = ":stringer",
+)
+
+alias(
+    name = "lint",
+    actual = "@com_example_lint//cmd/golint",
+)
+`,
+		},
+	})
+	defer cleanup()
+
+	args := []string{
+		"-go_prefix=example.com/use",
+		"-external=static",
+		"-go_naming_convention_external=import",
+	}
+	want := []testtools.FileSpec{
+		{
+			Path: "tools/BUILD.bazel",
+			Content: `
+# gazelle:go_tools_package tools
+
+alias(
+    name = "handwritten",
+    actual = "@com_example_handwritten//cmd/handwritten",
+)
+
+# gazelle:go_tool golang.org/x/tools/cmd/stringer
+alias(
+    name = "stringer",
+    actual = "@org_golang_x_tools//cmd/stringer",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "local",
+    actual = ":stringer",
+)
+
+alias(
+    name = "lint",
+    actual = "@com_example_lint//cmd/golint",
+)
+
+# gazelle:go_tool example.com/use/cmd/gen
+alias(
+    name = "gen",
+    actual = "//cmd/gen",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	}
+	// Markers are only added once, so a second run doesn't change anything.
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, args); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, want)
+	}
+}
+
+func TestMigrateSelectFromWorkspaceToBzlmod(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "MODULE.bazel",
+			Content: `bazel_dep(name = "rules_go", version = "0.39.1", repo_name = "my_rules_go")`,
+		},
+		{
+			Path: "BUILD",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "foo",
+    srcs = [
+        "bar.go",
+        "foo.go",
+        "foo_android.go",
+        "foo_android_build_tag.go",
+    ],
+    importpath = "example.com/foo",
+    visibility = ["//visibility:public"],
+    deps = select({
+        "@io_bazel_rules_go//go/platform:android": [
+            "//outer",
+            "//outer/inner",
+            "//outer_android_build_tag",
+            "//outer_android_suffix",
+            "@com_github_jr_hacker_tools//:go_default_library",
+        ],
+        "@io_bazel_rules_go//go/platform:linux": [
+            "//outer",
+            "//outer/inner",
+            "@com_github_jr_hacker_tools//:go_default_library",
+        ],
+        "//conditions:default": [],
+    }),
+)
+`,
+		},
+		{
+			Path: "foo.go",
+			Content: `
+// +build linux
+
+package foo
+
+import (
+    _ "example.com/foo/outer"
+    _ "example.com/foo/outer/inner"
+    _ "github.com/jr_hacker/tools"
+)
+`,
+		},
+		{
+			Path: "foo_android_build_tag.go",
+			Content: `
+// +build android
+
+package foo
+
+import (
+    _ "example.com/foo/outer_android_build_tag"
 )
 `,
 		},
@@ -4513,3 +4960,1243 @@ require (
 		t.Fatalf("got %s ; want %s; diff %s", string(got), want, cmp.Diff(string(got), want))
 	}
 }
+
+func TestFixMigratesWorkspaceRepoNamesToBzlmod(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `
+go_repository(
+    name = "custom_bar",
+    importpath = "github.com/foo/bar",
+)
+`,
+		},
+		{
+			Path: "MODULE.bazel",
+			Content: `
+bazel_dep(name = "rules_go", version = "0.46.0")
+bazel_dep(name = "gazelle", version = "0.35.0")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+use_repo(
+    go_deps,
+    "com_github_foo_bar",
+    baz = "com_github_foo_baz",
+)
+`,
+		},
+		{
+			Path: "BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@rules_go//go:def.bzl", "go_test")
+
+go_library(
+    name = "foo",
+    srcs = ["foo.go"],
+    importpath = "example.com/foo",
+    visibility = ["//visibility:public"],
+    deps = ["@custom_bar//:go_default_library"],
+)
+
+go_test(
+    name = "foo_test",
+    srcs = ["foo_test.go"],
+    embed = [":foo"],
+)
+
+filegroup(
+    name = "data",
+    srcs = [
+        "@com_github_foo_baz//:data",
+        "@io_bazel_rules_go//go/tools:data",  # keep
+    ],
+)
+
+genrule(
+    name = "gen",
+    srcs = ["@com_github_foo_baz//:data"],
+    outs = ["gen.txt"],
+    cmd = "echo @com_github_foo_baz//:data > $@",
+)
+`,
+		},
+		{
+			Path: "foo.go",
+			Content: `package foo
+
+import _ "github.com/foo/bar"
+`,
+		},
+		{Path: "foo_test.go", Content: "package foo\n"},
+		{
+			Path: "other/BUILD.bazel",
+			Content: `filegroup(
+    name = "other",
+    srcs = ["@com_github_foo_baz//:data"],
+)
+`,
+		},
+		{
+			Path: "tools/defs.bzl",
+			Content: `load("@com_github_foo_baz//:defs.bzl", "helper")
+
+def data(name):
+    native.filegroup(
+        name = name,
+        srcs = ["@com_github_foo_baz//:data"],
+    )
+
+DATA = ["@com_github_foo_baz//:data"]
+`,
+		},
+	})
+	defer cleanup()
+
+	// Only the root directory is updated, but build files in other
+	// directories and .bzl files are migrated, too.
+	if err := runGazelle(dir, []string{"fix", "-go_prefix", "example.com/foo", "-r=false", "."}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "other/BUILD.bazel",
+		Content: `filegroup(
+    name = "other",
+    srcs = ["@baz//:data"],
+)
+`,
+	}, {
+		Path: "tools/defs.bzl",
+		Content: `load("@baz//:defs.bzl", "helper")
+
+def data(name):
+    native.filegroup(
+        name = name,
+        srcs = ["@baz//:data"],
+    )
+
+DATA = ["@com_github_foo_baz//:data"]
+`,
+	}, {
+		Path: "BUILD.bazel",
+		Content: `load("@rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "foo",
+    srcs = ["foo.go"],
+    importpath = "example.com/foo",
+    visibility = ["//visibility:public"],
+    deps = ["@com_github_foo_bar//:go_default_library"],
+)
+
+go_test(
+    name = "foo_test",
+    srcs = ["foo_test.go"],
+    embed = [":foo"],
+)
+
+filegroup(
+    name = "data",
+    srcs = [
+        "@baz//:data",
+        "@io_bazel_rules_go//go/tools:data",  # keep
+    ],
+)
+
+genrule(
+    name = "gen",
+    srcs = ["@baz//:data"],
+    outs = ["gen.txt"],
+    cmd = "echo @com_github_foo_baz//:data > $@",
+)
+`,
+	}})
+}
+
+func TestMacroDirective(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:prefix example.com/repo
+# gazelle:macro go_package go_library(srcs,deps,importpath) go_test(name={name}_test,srcs=test_srcs,deps=test_deps)
+`,
+		},
+		{
+			Path: "foo/BUILD.bazel",
+			Content: `load("//:go.bzl", "go_package")
+
+go_package(
+    name = "foo",
+    srcs = ["old.go"],
+    importpath = "example.com/repo/foo",
+    test_srcs = ["old_test.go"],
+)
+`,
+		},
+		{
+			Path: "foo/foo.go",
+			Content: `package foo
+
+import _ "example.com/repo/bar"
+`,
+		},
+		{Path: "foo/foo_test.go", Content: "package foo\n"},
+		{
+			Path: "bar/BUILD.bazel",
+			Content: `load("//:go.bzl", "go_package")
+
+go_package(
+    name = "bar",
+    srcs = ["bar.go"],
+    importpath = "example.com/repo/bar",
+    test_srcs = ["bar_test.go"],
+)
+`,
+		},
+		{Path: "bar/bar.go", Content: "package bar\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "foo/BUILD.bazel",
+		Content: `load("//:go.bzl", "go_package")
+
+go_package(
+    name = "foo",
+    srcs = ["foo.go"],
+    importpath = "example.com/repo/foo",
+    test_srcs = ["foo_test.go"],
+    deps = ["//bar"],
+)
+`,
+	}, {
+		Path:    "bar/BUILD.bazel",
+		Content: files[5].Content,
+	}})
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "bar/BUILD.bazel",
+		Content: `load("//:go.bzl", "go_package")
+
+go_package(
+    name = "bar",
+    srcs = ["bar.go"],
+    importpath = "example.com/repo/bar",
+)
+`,
+	}})
+}
+
+func TestIdempotencyMode(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/repo\n",
+		},
+		{
+			Path: "foo/foo.go",
+			Content: `package foo
+
+import _ "example.com/repo/bar"
+`,
+		},
+		{Path: "foo/foo_test.go", Content: "package foo\n"},
+		{
+			Path: "bar/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "bar",
+    srcs = ["bar.go"],
+    importpath = "example.com/repo/bar",
+)
+`,
+		},
+		{Path: "bar/bar.go", Content: "package bar\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	for _, cmd := range []string{"update", "fix"} {
+		if err := runGazelle(dir, []string{cmd, "-mode=idempotency", "-patch=p"}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+
+	// Nothing is written, and no diffs are reported.
+	testtools.CheckFiles(t, dir, append(files,
+		testtools.FileSpec{Path: "foo/BUILD.bazel", NotExist: true},
+		testtools.FileSpec{Path: "p", Content: ""}))
+}
+
+func TestTwoPass(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:prefix example.com/m
+# gazelle:go_naming_convention import
+`,
+		},
+		{Path: "lib/lib.go", Content: "package lib\n"},
+		{
+			Path: "lib/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/m/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "app/app.go",
+			Content: `package app
+
+import _ "example.com/m/lib"
+`,
+		},
+		{
+			Path: "other/BUILD.bazel",
+			Content: `alias(
+    name = "lib",
+    actual = "//lib:go_default_library",
+)
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"update", "-two_pass", "app", "lib"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "lib/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "lib",
+    srcs = ["lib.go"],
+    importpath = "example.com/m/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "app/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "app",
+    srcs = ["app.go"],
+    importpath = "example.com/m/app",
+    visibility = ["//visibility:public"],
+    deps = ["//lib"],
+)
+`,
+		},
+		{
+			Path: "other/BUILD.bazel",
+			Content: `alias(
+    name = "lib",
+    actual = "//lib:lib",
+)
+`,
+		},
+	})
+}
+
+// releaseLang is a language that generates a rule in each directory and
+// checks, while resolving each rule, that the records of packages emitted
+// earlier have been released.
+type releaseLang struct {
+	language.BaseLang
+
+	mu       sync.Mutex
+	released map[string]bool
+
+	// resolved lists packages in the order they were resolved.
+	resolved []string
+
+	// errs lists packages resolved before the previous package's imports
+	// were released.
+	errs []string
+}
+
+// releaseImports is the import data returned for each generated rule. A
+// finalizer records when it's collected.
+type releaseImports struct {
+	rel string
+}
+
+func (*releaseLang) Name() string { return "release" }
+
+func (*releaseLang) Kinds() map[string]rule.KindInfo {
+	return map[string]rule.KindInfo{"release_rule": {}}
+}
+
+func (l *releaseLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	if args.Rel == "" {
+		return language.GenerateResult{}
+	}
+	imp := &releaseImports{rel: args.Rel}
+	runtime.SetFinalizer(imp, func(imp *releaseImports) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.released[imp.rel] = true
+	})
+	return language.GenerateResult{
+		Gen:     []*rule.Rule{rule.NewRule("release_rule", "x")},
+		Imports: []interface{}{imp},
+	}
+}
+
+func (l *releaseLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	rel := imports.(*releaseImports).rel
+	if len(l.resolved) > 0 {
+		prev := l.resolved[len(l.resolved)-1]
+		if !l.waitReleased(prev) {
+			l.errs = append(l.errs, rel)
+		}
+	}
+	l.resolved = append(l.resolved, rel)
+}
+
+// waitReleased collects garbage until the imports generated for rel are
+// finalized, or until it gives up.
+func (l *releaseLang) waitReleased(rel string) bool {
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		l.mu.Lock()
+		released := l.released[rel]
+		l.mu.Unlock()
+		if released {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestRecordsReleasedAfterEmit(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "a/"},
+		{Path: "b/"},
+		{Path: "c/"},
+	}
+	for _, twoPass := range []bool{false, true} {
+		t.Run(fmt.Sprintf("two_pass=%v", twoPass), func(t *testing.T) {
+			lang := &releaseLang{released: make(map[string]bool)}
+			languages = append(languages, lang)
+			defer func() { languages = languages[:len(languages)-1] }()
+
+			dir, cleanup := testtools.CreateFiles(t, files)
+			defer cleanup()
+
+			args := []string{"-go_prefix=example.com/m", "-lang=release"}
+			if twoPass {
+				args = append(args, "-two_pass")
+			}
+			if err := runGazelle(dir, args); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]string{"a", "b", "c"}, lang.resolved); diff != "" {
+				t.Errorf("resolved packages (-want,+got):\n%s", diff)
+			}
+			if len(lang.errs) > 0 {
+				t.Errorf("packages resolved before the previous package was released: %v", lang.errs)
+			}
+		})
+	}
+}
+
+// outputFilesLang is a language that generates files other than build files
+// in the repository root directory.
+type outputFilesLang struct {
+	language.BaseLang
+}
+
+func (*outputFilesLang) Name() string { return "output_files" }
+
+func (*outputFilesLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	if args.Rel != "" {
+		return language.GenerateResult{}
+	}
+	f := rule.EmptyFile(filepath.Join(args.Config.RepoRoot, "gen", "BUILD.bazel"), "gen")
+	r := rule.NewRule("filegroup", "all")
+	r.SetAttr("srcs", []string{"constants.bzl"})
+	r.Insert(f)
+	return language.GenerateResult{
+		OutputFiles: []language.OutputFile{
+			{Path: "gen/constants.bzl", Content: []byte("VERSION = \"1.0\"\n")},
+			{File: f},
+		},
+	}
+}
+
+func TestLanguageOutputFiles(t *testing.T) {
+	languages = append(languages, &outputFilesLang{})
+	defer func() { languages = languages[:len(languages)-1] }()
+
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "gen/constants.bzl", Content: "VERSION = \"0.1\"\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix=example.com/m", "-mode=diff", "-patch=p"}); err != errExit {
+		t.Fatalf("got error %v; want %v", err, errExit)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "gen/constants.bzl", Content: files[1].Content},
+		{Path: "gen/BUILD.bazel", NotExist: true},
+		{
+			Path: "p",
+			Content: `
+--- gen/constants.bzl	1970-01-01 00:00:00.000000001 +0000
++++ gen/constants.bzl	1970-01-01 00:00:00.000000001 +0000
+@@ -1,2 +1,2 @@
+-VERSION = "0.1"
++VERSION = "1.0"
+ 
+--- /dev/null	1970-01-01 00:00:00.000000001 +0000
++++ gen/BUILD.bazel	1970-01-01 00:00:00.000000001 +0000
+@@ -0,0 +1,5 @@
++filegroup(
++    name = "all",
++    srcs = ["constants.bzl"],
++)
++
+`,
+		},
+	})
+
+	if err := runGazelle(dir, []string{"-go_prefix=example.com/m"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "gen/constants.bzl", Content: `VERSION = "1.0"`},
+		{
+			Path: "gen/BUILD.bazel",
+			Content: `filegroup(
+    name = "all",
+    srcs = ["constants.bzl"],
+)
+`,
+		},
+	})
+}
+
+// conflictingOutputLang is a language that generates, in the repository
+// root directory, a build file for a package Gazelle also updates, and files
+// outside the repository.
+type conflictingOutputLang struct {
+	language.BaseLang
+}
+
+func (*conflictingOutputLang) Name() string { return "conflicting_output" }
+
+func (*conflictingOutputLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	if args.Rel != "" {
+		return language.GenerateResult{}
+	}
+	lib := rule.EmptyFile(filepath.Join(args.Config.RepoRoot, "lib", "BUILD.bazel"), "lib")
+	rule.NewRule("filegroup", "conflict").Insert(lib)
+	outside := rule.EmptyFile(filepath.Join(args.Config.RepoRoot, "..", "outside", "BUILD.bazel"), "")
+	rule.NewRule("filegroup", "outside").Insert(outside)
+	return language.GenerateResult{
+		OutputFiles: []language.OutputFile{
+			{File: lib},
+			{File: outside},
+			{Path: "../outside.bzl", Content: []byte("X = 1\n")},
+		},
+	}
+}
+
+func TestLanguageOutputFileConflicts(t *testing.T) {
+	languages = append(languages, &conflictingOutputLang{})
+	defer func() { languages = languages[:len(languages)-1] }()
+
+	files := []testtools.FileSpec{
+		{Path: "repo/WORKSPACE"},
+		{Path: "repo/lib/lib.go", Content: "package lib\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(filepath.Join(dir, "repo"), []string{"-go_prefix=example.com/m"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "repo/lib/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "lib",
+    srcs = ["lib.go"],
+    importpath = "example.com/m/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{Path: "outside/BUILD.bazel", NotExist: true},
+		{Path: "outside.bzl", NotExist: true},
+	})
+}
+
+// postWalkLang is a language that generates a test_suite in the repository
+// root directory listing every go_test rule in the repository.
+type postWalkLang struct {
+	language.BaseLang
+}
+
+func (*postWalkLang) Name() string { return "post_walk" }
+
+func (*postWalkLang) Kinds() map[string]rule.KindInfo {
+	return map[string]rule.KindInfo{
+		"test_suite": {
+			NonEmptyAttrs:  map[string]bool{"tests": true},
+			MergeableAttrs: map[string]bool{"tests": true},
+		},
+	}
+}
+
+func (*postWalkLang) GenerateRulesAfterWalk(args language.PostWalkArgs) {
+	var root *language.GeneratedPackage
+	var tests []string
+	for _, p := range args.Packages {
+		if p.Rel == "" {
+			root = p
+		}
+		for _, r := range p.Gen {
+			if r.Kind() == "go_test" {
+				tests = append(tests, label.New("", p.Rel, r.Name()).String())
+			}
+		}
+	}
+	if root == nil || len(tests) == 0 {
+		return
+	}
+	sort.Strings(tests)
+	r := rule.NewRule("test_suite", "all_tests")
+	r.SetAttr("tests", tests)
+	root.Gen = append(root.Gen, r)
+	root.Imports = append(root.Imports, nil)
+}
+
+func TestPostWalkGenerator(t *testing.T) {
+	languages = append(languages, &postWalkLang{})
+	defer func() { languages = languages[:len(languages)-1] }()
+
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "a/a_test.go", Content: "package a"},
+		{Path: "b/c/c_test.go", Content: "package c"},
+		{
+			Path: "BUILD.bazel",
+			Content: `test_suite(
+    name = "all_tests",
+    tests = ["//old:old_test"],
+)
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix=example.com/m"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "BUILD.bazel",
+			Content: `test_suite(
+    name = "all_tests",
+    tests = [
+        "//a:a_test",
+        "//b/c:c_test",
+    ],
+)
+`,
+		},
+	})
+
+	if err := runGazelle(dir, []string{"-two_pass"}); err == nil {
+		t.Fatal("got success with -two_pass; want error")
+	}
+}
+
+type contextTestKey struct{}
+
+// contextLang is a language that records a value from the context passed to
+// its context-aware methods. If block is set, GenerateRulesContext waits
+// until the context is done and generates nothing.
+type contextLang struct {
+	language.BaseLang
+	block      bool
+	fixed      interface{}
+	postWalked interface{}
+	indexed    interface{}
+}
+
+func (*contextLang) Name() string { return "context" }
+
+func (*contextLang) Kinds() map[string]rule.KindInfo {
+	return map[string]rule.KindInfo{
+		"context_rule": {
+			MergeableAttrs: map[string]bool{"generated": true},
+			ResolveAttrs:   map[string]bool{"resolved": true},
+		},
+	}
+}
+
+func (l *contextLang) GenerateRulesContext(ctx context.Context, args language.GenerateArgs) language.GenerateResult {
+	if args.Rel != "" {
+		return language.GenerateResult{}
+	}
+	if l.block {
+		<-ctx.Done()
+		return language.GenerateResult{}
+	}
+	r := rule.NewRule("context_rule", "x")
+	r.SetAttr("generated", ctx.Value(contextTestKey{}))
+	return language.GenerateResult{Gen: []*rule.Rule{r}, Imports: []interface{}{nil}}
+}
+
+func (l *contextLang) FixContext(ctx context.Context, c *config.Config, f *rule.File) {
+	l.fixed = ctx.Value(contextTestKey{})
+}
+
+func (l *contextLang) GenerateRulesAfterWalk(args language.PostWalkArgs) {}
+
+func (l *contextLang) GenerateRulesAfterWalkContext(ctx context.Context, args language.PostWalkArgs) {
+	l.postWalked = ctx.Value(contextTestKey{})
+}
+
+func (l *contextLang) ImportsContext(ctx context.Context, c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	l.indexed = ctx.Value(contextTestKey{})
+	return []resolve.ImportSpec{}
+}
+
+func (l *contextLang) ResolveContext(ctx context.Context, c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	r.SetAttr("resolved", ctx.Value(contextTestKey{}))
+}
+
+func TestContextLanguage(t *testing.T) {
+	lang := &contextLang{}
+	languages = append(languages, lang)
+	defer func() { languages = languages[:len(languages)-1] }()
+
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}, {Path: "BUILD.bazel"}})
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), contextTestKey{}, "value")
+	if err := run(ctx, dir, []string{"-go_prefix=example.com/m"}); err != nil {
+		t.Fatal(err)
+	}
+	if lang.fixed != "value" {
+		t.Errorf("FixContext got context value %v; want %q", lang.fixed, "value")
+	}
+	if lang.postWalked != "value" {
+		t.Errorf("GenerateRulesAfterWalkContext got context value %v; want %q", lang.postWalked, "value")
+	}
+	if lang.indexed != "value" {
+		t.Errorf("ImportsContext got context value %v; want %q", lang.indexed, "value")
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "BUILD.bazel",
+		Content: `context_rule(
+    name = "x",
+    generated = "value",
+    resolved = "value",
+)
+`,
+	}})
+}
+
+func TestTimeout(t *testing.T) {
+	languages = append(languages, &contextLang{block: true})
+	defer func() { languages = languages[:len(languages)-1] }()
+
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	wantErr := "timed out after 10ms"
+	if err := runGazelle(dir, []string{"-go_prefix=example.com/m", "-timeout=10ms"}); err == nil || err.Error() != wantErr {
+		t.Fatalf("got error %v; want %q", err, wantErr)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{Path: "BUILD.bazel", NotExist: true}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	wantErr = "interrupted"
+	if err := run(ctx, dir, []string{"-go_prefix=example.com/m"}); err == nil || err.Error() != wantErr {
+		t.Fatalf("got error %v; want %q", err, wantErr)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{Path: "BUILD.bazel", NotExist: true}})
+}
+
+func TestGoTestDataInference(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:prefix example.com/m
+# gazelle:go_test_data_inference true
+`,
+		},
+		{
+			Path: "data/data_test.go",
+			Content: `package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+)
+
+func TestData(t *testing.T) {
+	os.ReadFile("golden.txt")
+	os.ReadFile(filepath.Join("fixtures", t.Name()))
+	os.Open(filepath.Join("..", "shared", "schema.json"))
+	p, _ := runfiles.Rlocation("_main/shared/golden/x.txt")
+	os.ReadFile(p)
+	os.ReadFile("../missing/x.json")
+	os.ReadFile("../dup/x.json")
+	_ = filepath.Join("..", "unread", "x.txt")
+	os.ReadFile("../disabled/x.txt")
+}
+`,
+		},
+		{Path: "data/golden.txt"},
+		{Path: "data/fixtures/a.txt"},
+		{
+			Path: "shared/BUILD.bazel",
+			Content: `
+filegroup(
+    name = "schema",
+    srcs = ["schema.json"],
+    visibility = ["//visibility:public"],
+)
+
+filegroup(
+    name = "golden",
+    srcs = glob(["golden/**"]),
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{Path: "shared/schema.json"},
+		{
+			Path: "dup/BUILD.bazel",
+			Content: `
+filegroup(
+    name = "a",
+    srcs = ["x.json"],
+)
+
+filegroup(
+    name = "b",
+    srcs = ["x.json"],
+)
+`,
+		},
+		{Path: "dup/x.json"},
+		{
+			Path: "unread/BUILD.bazel",
+			Content: `
+filegroup(
+    name = "unread",
+    srcs = ["x.txt"],
+)
+`,
+		},
+		{Path: "unread/x.txt"},
+		{
+			Path: "disabled/BUILD.bazel",
+			Content: `
+# gazelle:go_test_data_inference false
+
+filegroup(
+    name = "disabled",
+    srcs = ["x.txt"],
+)
+`,
+		},
+		{Path: "disabled/x.txt"},
+		{Path: "shared/golden/x.txt"},
+		{
+			Path: "existing/existing_test.go",
+			Content: `package existing
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExisting(t *testing.T) {
+	os.ReadFile("golden.txt")
+}
+`,
+		},
+		{Path: "existing/golden.txt"},
+		{
+			Path: "existing/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "existing_test",
+    srcs = ["existing_test.go"],
+)
+`,
+		},
+		{
+			Path: "handwritten/handwritten_test.go",
+			Content: `package handwritten
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHandwritten(t *testing.T) {
+	os.ReadFile("golden.txt")
+}
+`,
+		},
+		{Path: "handwritten/golden.txt"},
+		{
+			Path: "handwritten/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "handwritten_test",
+    srcs = ["handwritten_test.go"],
+    data = ["other.txt"],
+)
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := `reads test data "dup/x.json" which is provided by multiple rules: //dup:a and //dup:b`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q\n--begin--\n%s--end--\n", want, buf.String())
+	}
+	// Filegroups aren't indexed where inference is disabled.
+	if want := `could not resolve test data "disabled/x.txt"`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q\n--begin--\n%s--end--\n", want, buf.String())
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "data/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "data_test",
+    srcs = ["data_test.go"],
+    data = glob(["fixtures/**"]) + [
+        ":golden.txt",
+        "//shared:golden",
+        "//shared:schema",
+    ],
+    deps = ["@io_bazel_rules_go//go/runfiles:go_default_library"],
+)
+`,
+		},
+		{
+			Path: "existing/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "existing_test",
+    srcs = ["existing_test.go"],
+    data = [":golden.txt"],
+)
+`,
+		},
+		{
+			Path:    "handwritten/BUILD.bazel",
+			Content: files[len(files)-1].Content,
+		},
+	})
+}
+
+func TestGoEmbedSubpackages(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:prefix example.com/m
+# gazelle:go_embed_subpackages true
+`,
+		},
+		{
+			Path:    "web/BUILD.bazel",
+			Content: "# gazelle:go_embed_filegroup_name files\n",
+		},
+		{
+			Path: "web/web.go",
+			Content: `package web
+
+import "embed"
+
+//go:embed static
+var static embed.FS
+`,
+		},
+		{
+			Path: "web/static/BUILD.bazel",
+			Content: `
+filegroup(
+    name = "embedsrcs",
+    srcs = ["hand_written.txt"],
+)
+`,
+		},
+		{Path: "web/static/index.html"},
+		{Path: "web/static/hand_written.txt"},
+		{
+			Path: "app/app.go",
+			Content: `package app
+
+import "embed"
+
+//go:embed assets/*.txt
+var assets embed.FS
+`,
+		},
+		{
+			Path: "app/assets/BUILD.bazel",
+			Content: `
+sh_library(name = "embedsrcs")
+`,
+		},
+		{Path: "app/assets/a.txt"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "web/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_embed_filegroup_name files
+
+go_library(
+    name = "web",
+    srcs = ["web.go"],
+    embedsrcs = ["//web/static:files"],
+    importpath = "example.com/m/web",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "web/static/BUILD.bazel",
+			Content: `
+filegroup(
+    name = "embedsrcs",
+    srcs = ["hand_written.txt"],
+)
+
+filegroup(
+    name = "files",
+    srcs = [
+        "hand_written.txt",
+        "index.html",
+    ],
+    visibility = ["//web:__pkg__"],
+)
+`,
+		},
+		{
+			// The generated filegroup would conflict with the sh_library.
+			Path:    "app/assets/BUILD.bazel",
+			Content: files[len(files)-2].Content,
+		},
+	})
+
+	// Files embedded by parents that aren't updated are unknown, so the
+	// filegroup is kept.
+	if err := os.Remove(filepath.Join(dir, "web", "static", "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"web/static"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"-r=false", "web/static"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "web/static/BUILD.bazel",
+		Content: `
+filegroup(
+    name = "embedsrcs",
+    srcs = ["hand_written.txt"],
+)
+
+filegroup(
+    name = "files",
+    srcs = [
+        "hand_written.txt",
+        "index.html",
+    ],
+    visibility = ["//web:__pkg__"],
+)
+`,
+	}})
+
+	// Subpackage filegroups are generated after the walk.
+	if err := runGazelle(dir, []string{"-two_pass"}); err == nil {
+		t.Error("got success with -two_pass; want error")
+	}
+}
+
+func TestProtoPlugins(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:go_generate_proto false
+# gazelle:proto_plugin cc_proto_library load=@rules_cc//cc:defs.bzl
+# gazelle:proto_plugin py_proto_library load=@rules_python//python:proto.bzl name={name}_py_pb2 attr=proto import_deps=true
+`,
+		},
+		{
+			Path: "a/a.proto",
+			Content: `syntax = "proto3";
+
+package a;
+
+import "b/b.proto";
+import "google/protobuf/any.proto";
+`,
+		},
+		{
+			Path: "a/BUILD.bazel",
+			Content: `
+load("@rules_cc//cc:defs.bzl", "cc_proto_library")
+
+cc_proto_library(
+    name = "a_cc_proto",
+    tags = ["manual"],
+    deps = [":old_proto"],
+)
+`,
+		},
+		{
+			Path: "b/b.proto",
+			Content: `syntax = "proto3";
+
+package b;
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "a/BUILD.bazel",
+			Content: `
+load("@rules_cc//cc:defs.bzl", "cc_proto_library")
+load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@rules_python//python:proto.bzl", "py_proto_library")
+
+cc_proto_library(
+    name = "a_cc_proto",
+    tags = ["manual"],
+    visibility = ["//visibility:public"],
+    deps = [":a_proto"],
+)
+
+proto_library(
+    name = "a_proto",
+    srcs = ["a.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:b_proto",
+        "@com_google_protobuf//:any_proto",
+    ],
+)
+
+py_proto_library(
+    name = "a_py_pb2",
+    proto = ":a_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//b:b_py_pb2"],
+)
+`,
+		},
+		{
+			Path: "b/BUILD.bazel",
+			Content: `
+load("@rules_cc//cc:defs.bzl", "cc_proto_library")
+load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@rules_python//python:proto.bzl", "py_proto_library")
+
+proto_library(
+    name = "b_proto",
+    srcs = ["b.proto"],
+    visibility = ["//visibility:public"],
+)
+
+cc_proto_library(
+    name = "b_cc_proto",
+    visibility = ["//visibility:public"],
+    deps = [":b_proto"],
+)
+
+py_proto_library(
+    name = "b_py_pb2",
+    proto = ":b_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
@@ -20,12 +20,10 @@ import (
 	"os"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
-	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-func printFile(c *config.Config, f *rule.File) error {
-	fmt.Printf(">>> %s\n", f.Path)
-	content := f.Format()
-	_, err := os.Stdout.Write(content)
+func printFile(c *config.Config, f *outputFile) error {
+	fmt.Printf(">>> %s\n", f.path)
+	_, err := os.Stdout.Write(f.newContent)
 	return err
 }
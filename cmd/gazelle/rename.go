@@ -0,0 +1,264 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// referenceFile is a file outside the directories Gazelle updates that may
+// contain references to renamed rules or to repositories with legacy names:
+// a build file in a directory that was visited but not updated, or a .bzl
+// file in any visited directory. Files are loaded again when they're
+// rewritten, so they aren't held in memory while Gazelle runs.
+type referenceFile struct {
+	c *config.Config
+
+	// path and pkg locate the file.
+	path, pkg string
+
+	// isBuildFile is set for build files. Other files are .bzl files.
+	isBuildFile bool
+}
+
+// rewrite loads the file, rewrites references to renamed rules, and migrates
+// references to repositories named in repoNames (see migrateRepoNames). It
+// returns the loaded file and whether it was changed.
+func (rf *referenceFile) rewrite(renames map[label.Label][]label.Label, kinds map[string]rule.KindInfo, repoNames map[string]string) (*rule.File, bool, error) {
+	f, err := rf.load()
+	if err != nil {
+		return nil, false, err
+	}
+	n := rewriteRenamedLabels(f, rf.c.RepoName, renames, rf.isBuildFile)
+	n += migrateRepoNames(f, kinds, repoNames)
+	return f, n > 0, nil
+}
+
+// load reads and parses the file, reading it from c.BuildFileOverlay if it's
+// there.
+func (rf *referenceFile) load() (*rule.File, error) {
+	data, ok := rf.c.BuildFileOverlay[rf.path]
+	if rf.isBuildFile {
+		if ok {
+			return rule.LoadData(rf.path, rf.pkg, data)
+		}
+		return rule.LoadFile(rf.path, rf.pkg)
+	}
+	if !ok {
+		var err error
+		data, err = os.ReadFile(rf.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ast, err := bzl.ParseBzl(rf.path, data)
+	if err != nil {
+		return nil, err
+	}
+	f := rule.ScanAST(rf.pkg, ast)
+	f.Content = data
+	return f, nil
+}
+
+// ruleNames records the names of the rules in a build file before Gazelle
+// fixes and merges it, so rules renamed during the run can be found later
+// with renamedRules.
+func ruleNames(f *rule.File) map[*rule.Rule]string {
+	if f == nil {
+		return nil
+	}
+	names := make(map[*rule.Rule]string, len(f.Rules))
+	for _, r := range f.Rules {
+		names[r] = r.Name()
+	}
+	return names
+}
+
+// renamedRules adds the rules in f that were renamed since names was
+// recorded to renames, mapping old labels to new labels. A rename is ignored
+// if another rule in f now has the old name, since references to the old
+// label may be meant for that rule.
+func renamedRules(renames map[label.Label][]label.Label, f *rule.File, names map[*rule.Rule]string) {
+	if len(names) == 0 {
+		return
+	}
+	current := make(map[string]bool, len(f.Rules))
+	for _, r := range f.Rules {
+		current[r.Name()] = true
+	}
+	for _, r := range f.Rules {
+		oldName, ok := names[r]
+		if !ok || oldName == "" || oldName == r.Name() || current[oldName] {
+			continue
+		}
+		renames[label.New("", f.Pkg, oldName)] = []label.Label{label.New("", f.Pkg, r.Name())}
+	}
+}
+
+// replacedRules adds rules that languages replaced with rules of other names
+// to renames. replaced maps names of the old rules to names of their
+// replacements, as in language.GenerateResult. A replacement is ignored if a
+// rule with the old name is still in f, for example because it's kept.
+func replacedRules(renames map[label.Label][]label.Label, f *rule.File, replaced map[string][]string) {
+	if len(replaced) == 0 {
+		return
+	}
+	current := make(map[string]bool, len(f.Rules))
+	for _, r := range f.Rules {
+		current[r.Name()] = true
+	}
+	for oldName, newNames := range replaced {
+		if current[oldName] || len(newNames) == 0 {
+			continue
+		}
+		labels := make([]label.Label, len(newNames))
+		for i, name := range newNames {
+			labels[i] = label.New("", f.Pkg, name)
+		}
+		renames[label.New("", f.Pkg, oldName)] = labels
+	}
+}
+
+// rewriteRenamedLabels replaces string literals in f that refer to renamed
+// rules with labels of the new rules. repoName is the name of the main
+// repository; labels with that repository name or none are rewritten.
+// Relative labels like ":name" are only rewritten if allowRelative is true,
+// since in .bzl files they refer to the package calling a macro, which
+// isn't known. Expressions marked with "# keep" comments, including whole
+// rules and attributes, and load statements are not rewritten. A rule
+// replaced by several rules is replaced by all of them in lists; other
+// references to it are reported and left alone. The number of rewritten
+// strings is returned, and each rewrite is logged.
+func rewriteRenamedLabels(f *rule.File, repoName string, renames map[label.Label][]label.Label, allowRelative bool) int {
+	if len(renames) == 0 {
+		return 0
+	}
+	f.Sync()
+	n := 0
+	type listSplit struct {
+		list      *bzl.ListExpr
+		s         *bzl.StringExpr
+		newValues []string
+	}
+	var splits []listSplit
+	bzl.WalkInterruptable(f.File, func(x bzl.Expr, stk []bzl.Expr) error {
+		if _, ok := x.(*bzl.LoadStmt); ok || rule.ShouldKeep(x) {
+			return &bzl.StopTraversalError{}
+		}
+		s, ok := x.(*bzl.StringExpr)
+		if !ok {
+			return nil
+		}
+		newValues, ok := renameLabelString(s.Value, repoName, f.Pkg, renames, allowRelative)
+		if !ok {
+			return nil
+		}
+		if len(newValues) == 1 {
+			log.Printf("%s:%d: replaced reference to renamed rule %q with %q", f.Path, s.Start.Line, s.Value, newValues[0])
+			s.Value = newValues[0]
+			n++
+			return nil
+		}
+		list, ok := stk[len(stk)-1].(*bzl.ListExpr)
+		if !ok {
+			log.Printf("%s:%d: reference to %q was not replaced: the rule was replaced by %s, and the reference is not in a list", f.Path, s.Start.Line, s.Value, strings.Join(newValues, ", "))
+			return nil
+		}
+		log.Printf("%s:%d: replaced reference to replaced rule %q with %s", f.Path, s.Start.Line, s.Value, strings.Join(newValues, ", "))
+		splits = append(splits, listSplit{list: list, s: s, newValues: newValues})
+		n++
+		return nil
+	})
+
+	// Replace strings with several strings after the walk, since lists can't
+	// be modified while they're walked. Strings already in a list aren't
+	// added again.
+	for _, split := range splits {
+		have := make(map[string]bool)
+		for _, e := range split.list.List {
+			if s, ok := e.(*bzl.StringExpr); ok {
+				have[s.Value] = true
+			}
+		}
+		var list []bzl.Expr
+		for _, e := range split.list.List {
+			if e != split.s {
+				list = append(list, e)
+				continue
+			}
+			for i, v := range split.newValues {
+				if have[v] {
+					continue
+				}
+				have[v] = true
+				ns := &bzl.StringExpr{Value: v}
+				if i == 0 {
+					ns.Comments = split.s.Comments
+				}
+				list = append(list, ns)
+			}
+		}
+		split.list.List = list
+	}
+	return n
+}
+
+// renameLabelString returns new forms of the label string s if it refers to
+// a renamed rule, one for each rule replacing it. The form of s is
+// preserved: a repository name, an explicit target name, or a relative label
+// in s is kept in the results.
+func renameLabelString(s, repoName, pkg string, renames map[label.Label][]label.Label, allowRelative bool) ([]string, bool) {
+	isRelative := strings.HasPrefix(s, ":")
+	if !isRelative && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "@") {
+		return nil, false
+	}
+	if isRelative && !allowRelative {
+		return nil, false
+	}
+	l, err := label.Parse(s)
+	if err != nil {
+		return nil, false
+	}
+	if l.Repo != "" && l.Repo != "@" && l.Repo != repoName {
+		return nil, false
+	}
+	l = l.Abs("", pkg)
+	tos, ok := renames[label.New("", l.Pkg, l.Name)]
+	if !ok {
+		return nil, false
+	}
+
+	values := make([]string, len(tos))
+	for i, to := range tos {
+		switch {
+		case isRelative:
+			values[i] = ":" + to.Name
+		case to.Name == path.Base(to.Pkg) && !strings.Contains(s, ":"):
+			values[i] = s[:strings.Index(s, "//")] + "//" + to.Pkg
+		default:
+			values[i] = s[:strings.Index(s, "//")] + "//" + to.Pkg + ":" + to.Name
+		}
+	}
+	return values, true
+}
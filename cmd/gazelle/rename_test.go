@@ -0,0 +1,55 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestReferenceFileReadsOverlay(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path:    "tools/defs.bzl",
+		Content: "DEPS = [\"//old:old\"]\n",
+	}})
+	defer cleanup()
+
+	path := filepath.Join(dir, "tools", "defs.bzl")
+	c := config.New()
+	c.BuildFileOverlay = map[string][]byte{
+		path: []byte("DEPS = [\"//lib:go_default_library\"]\n"),
+	}
+	rf := &referenceFile{c: c, path: path, pkg: "tools"}
+	renames := map[label.Label][]label.Label{
+		label.New("", "lib", "go_default_library"): {label.New("", "lib", "lib")},
+	}
+	f, changed, err := rf.rewrite(renames, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("got unchanged file; want references in the overlay rewritten")
+	}
+	want := "DEPS = [\"//lib:lib\"]\n"
+	if got := string(bzl.FormatWithoutRewriting(f.File)); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+	bt "github.com/bazelbuild/buildtools/tables"
+)
+
+// migrateRepoNames rewrites load statements and labels in f that refer to
+// repositories by their WORKSPACE names to use the apparent names of the
+// same repositories in MODULE.bazel. names maps WORKSPACE names to apparent
+// names (see module.ExtractLegacyToApparentNameMapping). f may be a build
+// file or a .bzl file. Only strings in label-typed attributes of rules are
+// rewritten: attributes buildtools treats as labels, and the resolve
+// attributes of kinds. In .bzl files, rules called in macros are included. Other strings, like
+// genrule commands, may look like labels but aren't. Expressions marked with
+// "# keep" comments, including whole rules and attributes, are not
+// rewritten. Loads that become duplicates are merged later by
+// merger.FixLoads. The number of rewritten strings is returned, and each
+// rewrite is logged.
+func migrateRepoNames(f *rule.File, kinds map[string]rule.KindInfo, names map[string]string) int {
+	if len(names) == 0 {
+		return 0
+	}
+	n := 0
+	for _, l := range f.Loads {
+		if l.ShouldKeep() {
+			continue
+		}
+		if newName, ok := migrateRepoNameInLabel(l.Name(), names); ok {
+			log.Printf("%s: replaced load of %q with %q", f.Path, l.Name(), newName)
+			l.SetName(newName)
+			n++
+		}
+	}
+
+	f.Sync()
+	bzl.WalkInterruptable(f.File, func(x bzl.Expr, stk []bzl.Expr) error {
+		if _, ok := x.(*bzl.LoadStmt); ok || rule.ShouldKeep(x) {
+			return &bzl.StopTraversalError{}
+		}
+		s, ok := x.(*bzl.StringExpr)
+		if !ok || !isLabelAttrValue(kinds, stk) {
+			return nil
+		}
+		newValue, ok := migrateRepoNameInLabel(s.Value, names)
+		if !ok {
+			return nil
+		}
+		log.Printf("%s:%d: replaced label %q with %q", f.Path, s.Start.Line, s.Value, newValue)
+		s.Value = newValue
+		n++
+		return nil
+	})
+	return n
+}
+
+// isLabelAttrValue returns whether an expression with the given stack of
+// enclosing expressions is in the value of a label-typed attribute of a rule
+// called at the top level of a build file, or in a .bzl file, in a statement
+// at any level. Rules called as native.kind are included.
+func isLabelAttrValue(kinds map[string]rule.KindInfo, stk []bzl.Expr) bool {
+	for len(stk) > 0 {
+		switch stk[0].(type) {
+		case *bzl.File, *bzl.DefStmt, *bzl.IfStmt, *bzl.ForStmt:
+			stk = stk[1:]
+			continue
+		}
+		break
+	}
+	if len(stk) < 2 {
+		return false
+	}
+	call, ok := stk[0].(*bzl.CallExpr)
+	if !ok {
+		return false
+	}
+	var kind string
+	switch x := call.X.(type) {
+	case *bzl.Ident:
+		kind = x.Name
+	case *bzl.DotExpr:
+		if pkg, ok := x.X.(*bzl.Ident); !ok || pkg.Name != "native" {
+			return false
+		}
+		kind = x.Name
+	default:
+		return false
+	}
+	attr, ok := stk[1].(*bzl.AssignExpr)
+	if !ok {
+		return false
+	}
+	key, ok := attr.LHS.(*bzl.Ident)
+	if !ok {
+		return false
+	}
+	if bt.IsLabelArg[key.Name] && !bt.LabelDenylist[kind+"."+key.Name] {
+		return true
+	}
+	return kinds[kind].ResolveAttrs[key.Name]
+}
+
+// migrateRepoNameInLabel returns the label string s with its repository name
+// replaced, if s is a label in a repository named in names. Labels with
+// canonical repository names (starting with "@@") are not changed. A label
+// that only names a repository, like "@foo", is expanded to refer to the
+// same target in the renamed repository.
+func migrateRepoNameInLabel(s string, names map[string]string) (string, bool) {
+	if !strings.HasPrefix(s, "@") || strings.HasPrefix(s, "@@") {
+		return "", false
+	}
+	repo, rest := s[len("@"):], ""
+	if i := strings.Index(repo, "//"); i >= 0 {
+		repo, rest = repo[:i], repo[i:]
+	} else if strings.ContainsAny(repo, ":/") {
+		return "", false
+	} else {
+		rest = "//:" + repo
+	}
+	newRepo, ok := names[repo]
+	if !ok {
+		return "", false
+	}
+	return "@" + newRepo + rest, true
+}
@@ -0,0 +1,271 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// fileTransaction stages files to be written, so that they can be written
+// together after Gazelle has finished updating them. If Gazelle fails
+// before then, nothing is written. If writing any file fails, files that
+// were already written are restored, so the tree is either fully updated
+// or left as it was.
+//
+// Staged content is kept in a temporary directory rather than in memory, so
+// memory doesn't grow with the number of files changed in a run. The
+// directory is removed by commit or discard.
+type fileTransaction struct {
+	writes []*stagedWrite
+	paths  map[string]*stagedWrite
+
+	// dir is the temporary directory holding staged content. It's created
+	// when the first file is staged.
+	dir string
+}
+
+// stagedWrite is a file staged in a fileTransaction.
+type stagedWrite struct {
+	path string
+
+	// content is the file in the transaction's directory with the new
+	// content.
+	content string
+
+	// tmp is a temporary file with the new content, which is renamed to path.
+	tmp string
+
+	// backup is a copy of the file at path before it was replaced, if it
+	// existed.
+	backup string
+
+	// renamed is set when tmp has been renamed to path.
+	renamed bool
+}
+
+// stage records that content should be written to path when the transaction
+// is committed. content is copied to the transaction's temporary directory
+// and may be discarded by the caller. An error is returned if path was
+// already staged, for example, because a language generated a file Gazelle
+// also updated. The content staged first is kept.
+func (t *fileTransaction) stage(path string, content []byte) error {
+	if _, ok := t.paths[path]; ok {
+		return fmt.Errorf("%s: file was already updated in this run; not writing it again", path)
+	}
+	if t.dir == "" {
+		dir, err := os.MkdirTemp("", "gazelle-staged-")
+		if err != nil {
+			return err
+		}
+		t.dir = dir
+		t.paths = make(map[string]*stagedWrite)
+	}
+	w := &stagedWrite{path: path, content: filepath.Join(t.dir, strconv.Itoa(len(t.writes)))}
+	if err := os.WriteFile(w.content, content, 0o666); err != nil {
+		return err
+	}
+	t.writes = append(t.writes, w)
+	t.paths[path] = w
+	return nil
+}
+
+// discard removes staged content without writing it. It does nothing if the
+// transaction was already committed or discarded.
+func (t *fileTransaction) discard() {
+	if t.dir != "" {
+		if err := os.RemoveAll(t.dir); err != nil {
+			log.Printf("removing staged files: %v", err)
+		}
+	}
+	t.writes = nil
+	t.paths = nil
+	t.dir = ""
+}
+
+// staged returns the paths of staged files, in the order they were staged.
+func (t *fileTransaction) staged() []string {
+	paths := make([]string, len(t.writes))
+	for i, w := range t.writes {
+		paths[i] = w.path
+	}
+	return paths
+}
+
+// commit writes the staged files. Each file's new content is first written
+// to a temporary file in the same directory, and each existing file is
+// copied to a backup. Then the temporary files are renamed into place.
+// If any step fails, the files already replaced are restored from their
+// backups, new files and directories are removed, and the error is
+// returned. Backups are removed once all files are written.
+func (t *fileTransaction) commit() (err error) {
+	var createdDirs []string
+	defer func() {
+		if err != nil {
+			if rerr := t.rollback(createdDirs); rerr != nil {
+				err = fmt.Errorf("%v; additionally, restoring files failed: %v", err, rerr)
+			}
+		}
+		for _, w := range t.writes {
+			if w.backup != "" && err == nil {
+				if rerr := os.Remove(w.backup); rerr != nil {
+					log.Printf("removing backup: %v", rerr)
+				}
+			}
+		}
+		t.discard()
+	}()
+
+	for _, w := range t.writes {
+		dir := filepath.Dir(w.path)
+		dirs, err := mkdirAll(dir)
+		createdDirs = append(createdDirs, dirs...)
+		if err != nil {
+			return err
+		}
+
+		// New files are created with the same permissions as os.WriteFile
+		// would use. Replaced files keep their permissions.
+		info, err := os.Stat(w.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		exists := err == nil
+		perm := os.FileMode(0o666)
+		if exists {
+			perm = info.Mode().Perm()
+			if w.backup, err = copyTemp(dir, filepath.Base(w.path), ".bak", w.path, perm); err != nil {
+				return err
+			}
+		}
+		if w.tmp, err = copyTemp(dir, filepath.Base(w.path), ".tmp", w.content, perm); err != nil {
+			return err
+		}
+		if exists {
+			if err := os.Chmod(w.tmp, perm); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, w := range t.writes {
+		if err := os.Rename(w.tmp, w.path); err != nil {
+			return err
+		}
+		w.tmp = ""
+		w.renamed = true
+	}
+	return nil
+}
+
+// rollback restores files replaced by a failed commit and removes
+// temporary files and the directories in createdDirs.
+func (t *fileTransaction) rollback(createdDirs []string) error {
+	var errs []error
+	for i := len(t.writes) - 1; i >= 0; i-- {
+		w := t.writes[i]
+		if w.tmp != "" {
+			if err := os.Remove(w.tmp); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if w.renamed {
+			if w.backup != "" {
+				if err := os.Rename(w.backup, w.path); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				w.backup = ""
+			} else if err := os.Remove(w.path); err != nil {
+				errs = append(errs, err)
+			}
+		} else if w.backup != "" {
+			if err := os.Remove(w.backup); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			w.backup = ""
+		}
+	}
+	for i := len(createdDirs) - 1; i >= 0; i-- {
+		if err := os.Remove(createdDirs[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mkdirAll creates dir and any missing parents, like os.MkdirAll. It returns
+// the directories it created, parents first.
+func mkdirAll(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0o777); errors.Is(err, os.ErrExist) {
+			continue
+		} else if err != nil {
+			return created, err
+		}
+		created = append(created, missing[i])
+	}
+	return created, nil
+}
+
+// copyTemp copies the file at src to a new file in dir with a name based on
+// base and suffix and returns the new file's path. Unlike os.CreateTemp, the
+// file is created with the given permissions, subject to the umask.
+func copyTemp(dir, base, suffix, src string, perm os.FileMode) (string, error) {
+	r, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	for try := 0; ; try++ {
+		path := filepath.Join(dir, "."+base+"."+strconv.Itoa(rand.Int())+suffix)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, os.ErrExist) && try < 100 {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+}
@@ -0,0 +1,139 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileTransaction(t *testing.T) {
+	t.Run("commit", func(t *testing.T) {
+		dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "old"},
+		})
+		defer cleanup()
+		if runtime.GOOS != "windows" {
+			if err := os.Chmod(filepath.Join(dir, "a/BUILD.bazel"), 0o640); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var tx fileTransaction
+		tx.stage(filepath.Join(dir, "a/BUILD.bazel"), []byte("first"))
+		tx.stage(filepath.Join(dir, "b/c/BUILD.bazel"), []byte("new"))
+		if err := tx.stage(filepath.Join(dir, "a/BUILD.bazel"), []byte("replaced")); err == nil {
+			t.Error("staging a file twice: got nil error")
+		}
+		stagingDir := tx.dir
+		if err := tx.commit(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+			t.Errorf("staging directory %s was not removed", stagingDir)
+		}
+
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "first"},
+			{Path: "b/c/BUILD.bazel", Content: "new"},
+		})
+		if runtime.GOOS != "windows" {
+			if info, err := os.Stat(filepath.Join(dir, "a/BUILD.bazel")); err != nil {
+				t.Fatal(err)
+			} else if perm := info.Mode().Perm(); perm != 0o640 {
+				t.Errorf("got permissions %o; want %o", perm, 0o640)
+			}
+		}
+		want := []string{"a", "a/BUILD.bazel", "b", "b/c", "b/c/BUILD.bazel"}
+		if diff := cmp.Diff(want, listFiles(t, dir)); diff != "" {
+			t.Errorf("files (-want,+got):\n%s", diff)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "old"},
+			{Path: "d/BUILD.bazel/x", Content: "x"},
+		})
+		defer cleanup()
+
+		var tx fileTransaction
+		tx.stage(filepath.Join(dir, "a/BUILD.bazel"), []byte("new"))
+		tx.stage(filepath.Join(dir, "b/c/BUILD.bazel"), []byte("new"))
+		// Replacing a non-empty directory with a file fails.
+		tx.stage(filepath.Join(dir, "d/BUILD.bazel"), []byte("new"))
+		if err := tx.commit(); err == nil {
+			t.Fatal("got success; want error")
+		}
+
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "old"},
+			{Path: "d/BUILD.bazel/x", Content: "x"},
+		})
+		want := []string{"a", "a/BUILD.bazel", "d", "d/BUILD.bazel", "d/BUILD.bazel/x"}
+		if diff := cmp.Diff(want, listFiles(t, dir)); diff != "" {
+			t.Errorf("files (-want,+got):\n%s", diff)
+		}
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "old"},
+		})
+		defer cleanup()
+
+		var tx fileTransaction
+		tx.stage(filepath.Join(dir, "a/BUILD.bazel"), []byte("new"))
+		stagingDir := tx.dir
+		tx.discard()
+		if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+			t.Errorf("staging directory %s was not removed", stagingDir)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "old"},
+		})
+		if err := tx.commit(); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: "old"},
+		})
+	})
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel, _ := filepath.Rel(dir, path); rel != "." {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
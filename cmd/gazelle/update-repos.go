@@ -17,6 +17,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -125,7 +126,7 @@ func (*updateReposConfigurer) KnownDirectives() []string { return nil }
 
 func (*updateReposConfigurer) Configure(c *config.Config, rel string, f *rule.File) {}
 
-func updateRepos(wd string, args []string) (err error) {
+func updateRepos(ctx context.Context, wd string, args []string) (err error) {
 	// Build configuration with all languages.
 	cexts := make([]config.Configurer, 0, len(languages)+2)
 	cexts = append(cexts, &config.CommonConfigurer{}, &updateReposConfigurer{})
@@ -140,6 +141,10 @@ func updateRepos(wd string, args []string) (err error) {
 	}
 	uc := getUpdateReposConfig(c)
 
+	// Stop work when Gazelle is interrupted or -timeout expires.
+	ctx, cancel := withTimeout(ctx, c.Timeout)
+	defer cancel()
+
 	kinds := make(map[string]rule.KindInfo)
 	loads := []rule.LoadInfo{}
 	for _, lang := range languages {
@@ -171,7 +176,7 @@ func updateRepos(wd string, args []string) (err error) {
 			})
 		}
 	}
-	rc, cleanup := repo.NewRemoteCache(knownRepos)
+	rc, cleanup := repo.NewRemoteCacheContext(ctx, knownRepos)
 	defer func() {
 		if cerr := cleanup(); err == nil && cerr != nil {
 			err = cerr
@@ -186,9 +191,12 @@ func updateRepos(wd string, args []string) (err error) {
 	// Generate rules from command language arguments or by importing a file.
 	var gen, empty []*rule.Rule
 	if uc.repoFilePath == "" {
-		gen, err = updateRepoImports(c, rc)
+		gen, err = updateRepoImports(ctx, c, rc)
 	} else {
-		gen, empty, err = importRepos(c, rc)
+		gen, empty, err = importRepos(ctx, c, rc)
+	}
+	if cerr := contextError(ctx, c); cerr != nil {
+		return cerr
 	}
 	if err != nil {
 		return err
@@ -317,7 +325,10 @@ func updateRepos(wd string, args []string) (err error) {
 		}
 	}
 
-	// Write updated files to disk.
+	// Write updated files to disk. Files are written together, so if any
+	// write fails, none of the files are changed.
+	var writes fileTransaction
+	defer writes.discard()
 	for _, f := range sortedFiles {
 		if uf := updatedFiles[f.Path]; uf != nil {
 			if f.DefName != "" {
@@ -325,13 +336,17 @@ func updateRepos(wd string, args []string) (err error) {
 			}
 			newContent := f.Format()
 			if !bytes.Equal(f.Content, newContent) {
-				if err := uf.Save(uf.Path); err != nil {
+				uf.Content = uf.Format()
+				if err := writes.stage(uf.Path, uf.Content); err != nil {
 					return err
 				}
 			}
 			delete(updatedFiles, f.Path)
 		}
 	}
+	if err := writes.commit(); err != nil {
+		return fmt.Errorf("writing files: %v", err)
+	}
 
 	return nil
 }
@@ -382,7 +397,7 @@ FLAGS:
 	fs.PrintDefaults()
 }
 
-func updateRepoImports(c *config.Config, rc *repo.RemoteCache) (gen []*rule.Rule, err error) {
+func updateRepoImports(ctx context.Context, c *config.Config, rc *repo.RemoteCache) (gen []*rule.Rule, err error) {
 	// TODO(jayconrod): let the user pick the language with a command line flag.
 	// For now, only use the first language that implements the interface.
 	uc := getUpdateReposConfig(c)
@@ -400,11 +415,12 @@ func updateRepoImports(c *config.Config, rc *repo.RemoteCache) (gen []*rule.Rule
 		Config:  c,
 		Imports: uc.importPaths,
 		Cache:   rc,
+		Context: ctx,
 	})
 	return res.Gen, res.Error
 }
 
-func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rule, err error) {
+func importRepos(ctx context.Context, c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rule, err error) {
 	uc := getUpdateReposConfig(c)
 	importSupported := false
 	var importer language.RepoImporter
@@ -425,10 +441,11 @@ func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rul
 		}
 	}
 	res := importer.ImportRepos(language.ImportReposArgs{
-		Config: c,
-		Path:   uc.repoFilePath,
-		Prune:  uc.pruneRules,
-		Cache:  rc,
+		Config:  c,
+		Path:    uc.repoFilePath,
+		Prune:   uc.pruneRules,
+		Cache:   rc,
+		Context: ctx,
 	})
 	return res.Gen, res.Empty, res.Error
 }
@@ -33,9 +33,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bazelbuild/bazel-gazelle/internal/module"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
@@ -69,6 +71,12 @@ type Config struct {
 	// build files should be written to instead of RepoRoot.
 	WriteBuildFilesDir string
 
+	// BuildFileOverlay maps absolute paths of build files to content Gazelle
+	// should read instead of the files on disk. Paths may name files that
+	// don't exist. It lets Gazelle run over its own output without writing
+	// it, as -mode=idempotency does. WORKSPACE and .bzl files are included.
+	BuildFileOverlay map[string][]byte
+
 	// ValidBuildFileNames is a list of base names that are considered valid
 	// build files. Some repositories may have files named "BUILD" that are not
 	// used by Bazel and should be ignored. Must contain at least one string.
@@ -82,6 +90,18 @@ type Config struct {
 	// set, Gazelle will exit with non-zero value after logging such errors.
 	Strict bool
 
+	// Timeout is the maximum time Gazelle may run before it stops and reports
+	// an error, set with -timeout. Zero means there's no limit.
+	Timeout time.Duration
+
+	// Excludes lists patterns for files and directories that Gazelle should
+	// skip, relative to the repository root, in addition to those set with
+	// -exclude and # gazelle:exclude. Extensions may add patterns in Configure;
+	// they apply to the directory being configured and its subdirectories.
+	// The slice may be shared with parent directories, so it must be copied
+	// before it's modified.
+	Excludes []string
+
 	// IndexLibraries determines whether Gazelle should build an index of
 	// libraries in the workspace for dependency resolution
 	IndexLibraries bool
@@ -91,6 +111,18 @@ type Config struct {
 	// # gazelle:map_kind.
 	KindMap map[string]MappedKind
 
+	// AttrFormats maps from a kind name to the order of attributes and the
+	// attributes whose values are sorted in rules of that kind. It's set with
+	// # gazelle:attr_order and # gazelle:attr_sort, and applied to rules that
+	// Gazelle creates or modifies.
+	AttrFormats map[string]rule.AttrFormat
+
+	// Macros maps from names of macros called in build files to descriptions
+	// of the rules they declare. It's set with # gazelle:macro. Gazelle
+	// matches, merges, and indexes the declared rules as if they were called
+	// directly.
+	Macros map[string]rule.MacroInfo
+
 	// Repos is a list of repository rules declared in the main WORKSPACE file
 	// or in macros called by the main WORKSPACE file. This may affect rule
 	// generation and dependency resolution.
@@ -113,6 +145,12 @@ type Config struct {
 	// to the apparent name (repo_name) specified in the MODULE.bazel file. It
 	// returns the empty string if the module is not found.
 	ModuleToApparentName func(string) string
+
+	// RepoMapping converts labels between canonical and apparent repository
+	// names, according to the MODULE.bazel file. It's used to recognize
+	// labels that refer to the same target, like "@@rules_go+//go:def.bzl"
+	// and "@io_bazel_rules_go//go:def.bzl". It may be nil.
+	RepoMapping *label.RepoMapping
 }
 
 // MappedKind describes a replacement to use for a built-in kind.
@@ -140,6 +178,14 @@ func (c *Config) Clone() *Config {
 	for k, v := range c.KindMap {
 		cc.KindMap[k] = v
 	}
+	cc.AttrFormats = make(map[string]rule.AttrFormat, len(c.AttrFormats))
+	for k, v := range c.AttrFormats {
+		cc.AttrFormats[k] = v
+	}
+	cc.Macros = make(map[string]rule.MacroInfo, len(c.Macros))
+	for k, v := range c.Macros {
+		cc.Macros[k] = v
+	}
 	return &cc
 }
 
@@ -161,6 +207,23 @@ func (c *Config) DefaultBuildFileName() string {
 	return c.ValidBuildFileNames[0]
 }
 
+// AttrFormat returns the attribute format for rules of the given kind. If
+// no format is set for kind, but kind replaces another kind through
+// # gazelle:map_kind, the format for the other kind is returned.
+func (c *Config) AttrFormat(kind string) (rule.AttrFormat, bool) {
+	if format, ok := c.AttrFormats[kind]; ok {
+		return format, true
+	}
+	for _, mk := range c.KindMap {
+		if mk.KindName == kind {
+			if format, ok := c.AttrFormats[mk.FromKind]; ok {
+				return format, true
+			}
+		}
+	}
+	return rule.AttrFormat{}, false
+}
+
 // Configurer is the interface for language or library-specific configuration
 // extensions. Most (ideally all) modifications to Config should happen
 // via this interface.
@@ -202,6 +265,7 @@ type CommonConfigurer struct {
 	indexLibraries, strict                                          bool
 	langCsv                                                         string
 	bzlmod                                                          bool
+	timeout                                                         time.Duration
 }
 
 func (cc *CommonConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Config) {
@@ -213,6 +277,7 @@ func (cc *CommonConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Confi
 	fs.StringVar(&cc.writeBuildFilesDir, "experimental_write_build_files_dir", "", "path to a directory where build files should be written to (instead of -repo_root)")
 	fs.StringVar(&cc.langCsv, "lang", "", "if non-empty, process only these languages (e.g. \"go,proto\")")
 	fs.BoolVar(&cc.bzlmod, "bzlmod", false, "for internal usage only")
+	fs.DurationVar(&cc.timeout, "timeout", 0, "if positive, gazelle stops and reports an error if it hasn't finished within this duration (e.g., \"5m\")")
 }
 
 func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
@@ -252,6 +317,10 @@ func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 	}
 	c.IndexLibraries = cc.indexLibraries
 	c.Strict = cc.strict
+	if cc.timeout < 0 {
+		return fmt.Errorf("-timeout must not be negative")
+	}
+	c.Timeout = cc.timeout
 	if len(cc.langCsv) > 0 {
 		c.Langs = strings.Split(cc.langCsv, ",")
 	}
@@ -260,11 +329,17 @@ func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse MODULE.bazel: %v", err)
 	}
+	c.RepoMapping, err = module.ExtractRepoMapping(c.RepoRoot)
+	if err != nil {
+		return fmt.Errorf("failed to parse MODULE.bazel: %v", err)
+	}
 	return nil
 }
 
 func (cc *CommonConfigurer) KnownDirectives() []string {
-	return []string{"build_file_name", "map_kind", "lang"}
+	// "manage" applies to individual rules. It's interpreted by the rule
+	// package while merging, not here.
+	return []string{"build_file_name", "map_kind", "lang", "manage", "attr_order", "attr_sort", "macro"}
 }
 
 func (cc *CommonConfigurer) Configure(c *Config, rel string, f *rule.File) {
@@ -297,6 +372,48 @@ func (cc *CommonConfigurer) Configure(c *Config, rel string, f *rule.File) {
 			} else {
 				c.Langs = nil
 			}
+
+		case "attr_order", "attr_sort":
+			vals := strings.Fields(d.Value)
+			if len(vals) != 1 && len(vals) != 2 {
+				log.Printf("expected a kind and a comma-separated list of attributes (gazelle:%s kind attrs), got %v", d.Key, vals)
+				continue
+			}
+			attrs := []string{}
+			if len(vals) == 2 {
+				attrs = strings.Split(vals[1], ",")
+			}
+			if c.AttrFormats == nil {
+				c.AttrFormats = make(map[string]rule.AttrFormat)
+			}
+			format := c.AttrFormats[vals[0]]
+			if d.Key == "attr_order" {
+				format.Order = attrs
+			} else {
+				format.Sorted = attrs
+			}
+			c.AttrFormats[vals[0]] = format
+
+		case "macro":
+			vals := strings.Fields(d.Value)
+			if len(vals) == 0 {
+				log.Printf("expected a macro name and the rules it declares (gazelle:macro name kind(attr=arg,...) ...), got %v", vals)
+				continue
+			}
+			kind := vals[0]
+			if len(vals) == 1 {
+				delete(c.Macros, kind)
+				continue
+			}
+			info, err := rule.ParseMacroInfo(strings.Join(vals[1:], " "))
+			if err != nil {
+				log.Printf("gazelle:macro %s: %v", kind, err)
+				continue
+			}
+			if c.Macros == nil {
+				c.Macros = make(map[string]rule.MacroInfo)
+			}
+			c.Macros[kind] = info
 		}
 	}
 }
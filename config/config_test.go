@@ -86,3 +86,63 @@ func TestCommonConfigurerDirectives(t *testing.T) {
 		t.Errorf("for Langs, got %#v, want %#v", c.Langs, wantLangs)
 	}
 }
+
+func TestAttrFormatDirectives(t *testing.T) {
+	c := New()
+	cc := &CommonConfigurer{}
+	buildData := []byte(`# gazelle:attr_order go_library name,srcs,*,deps
+# gazelle:attr_sort go_library deps
+# gazelle:attr_sort go_test
+# gazelle:map_kind go_library my_library //tools:def.bzl`)
+	f, err := rule.LoadData(filepath.Join("test", "BUILD.bazel"), "", buildData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Configure(c, "", f)
+
+	wantLibrary := rule.AttrFormat{
+		Order:  []string{"name", "srcs", "*", "deps"},
+		Sorted: []string{"deps"},
+	}
+	for _, kind := range []string{"go_library", "my_library"} {
+		if got, ok := c.AttrFormat(kind); !ok || !reflect.DeepEqual(got, wantLibrary) {
+			t.Errorf("for %s, got %#v, %v; want %#v", kind, got, ok, wantLibrary)
+		}
+	}
+	wantTest := rule.AttrFormat{Sorted: []string{}}
+	if got, ok := c.AttrFormat("go_test"); !ok || !reflect.DeepEqual(got, wantTest) {
+		t.Errorf("for go_test, got %#v, %v; want %#v", got, ok, wantTest)
+	}
+	if _, ok := c.AttrFormat("go_binary"); ok {
+		t.Errorf("for go_binary, got a format; want none")
+	}
+}
+
+func TestMacroDirective(t *testing.T) {
+	c := New()
+	cc := &CommonConfigurer{}
+	buildData := []byte(`# gazelle:macro go_package go_library(srcs,deps=lib_deps) go_test(name={name}_test,srcs=test_srcs)
+# gazelle:macro other go_binary(srcs)
+# gazelle:macro other`)
+	f, err := rule.LoadData(filepath.Join("test", "BUILD.bazel"), "", buildData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Configure(c, "", f)
+
+	want := map[string]rule.MacroInfo{
+		"go_package": {Rules: []rule.MacroRuleInfo{
+			{
+				Kind:  "go_library",
+				Attrs: map[string]string{"srcs": "srcs", "deps": "lib_deps"},
+			}, {
+				Kind:  "go_test",
+				Name:  "{name}_test",
+				Attrs: map[string]string{"srcs": "test_srcs"},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(c.Macros, want) {
+		t.Errorf("got %#v; want %#v", c.Macros, want)
+	}
+}
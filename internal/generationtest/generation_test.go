@@ -31,7 +31,8 @@ var (
 		" By default, will use files named BUILD.in as the BUILD files before running gazelle.")
 	buildOutSuffix = flag.String("build_out_suffix", ".out", "The suffix on the expected BUILD.bazel files after running gazelle. Defaults to .out. "+
 		" By default, will use files named BUILD.out as the expected results of the gazelle run.")
-	timeout = flag.Duration("timeout", 2*time.Second, "Time to allow the gazelle process to run before killing.")
+	timeout          = flag.Duration("timeout", 2*time.Second, "Time to allow the gazelle process to run before killing.")
+	checkIdempotency = flag.Bool("check_idempotency", false, "Whether to check that running gazelle again over the generated files doesn't change them.")
 )
 
 // TestFullGeneration runs the gazelle binary on a few example
@@ -69,6 +70,7 @@ func TestFullGeneration(t *testing.T) {
 				BuildInSuffix:        *buildInSuffix,
 				BuildOutSuffix:       *buildOutSuffix,
 				Timeout:              *timeout,
+				CheckIdempotency:     *checkIdempotency,
 			})
 		}
 	}
@@ -18,7 +18,7 @@ Test for generating rules from gazelle.
 
 load("@io_bazel_rules_go//go:def.bzl", "go_test")
 
-def gazelle_generation_test(name, gazelle_binary, test_data, build_in_suffix = ".in", build_out_suffix = ".out", gazelle_timeout_seconds = 2, size = None, **kwargs):
+def gazelle_generation_test(name, gazelle_binary, test_data, build_in_suffix = ".in", build_out_suffix = ".out", gazelle_timeout_seconds = 2, check_idempotency = False, size = None, **kwargs):
     """
     gazelle_generation_test is a macro for testing gazelle against workspaces.
 
@@ -51,6 +51,8 @@ def gazelle_generation_test(name, gazelle_binary, test_data, build_in_suffix = "
         build_out_suffix: The suffix for the expected BUILD.bazel files after running gazelle. Defaults to .out.
             By default, will use files named check the results of the gazelle run against files named BUILD.out.
         timeout_seconds: Number of seconds to allow the gazelle process to run before killing.
+        check_idempotency: If True, also check that running gazelle again over the generated
+            BUILD files doesn't change them, using -mode=idempotency.
         size: Specifies a test target's "heaviness": how much time/resources it needs to run.
         **kwargs: Attributes that are passed directly to the test declaration.
     """
@@ -66,6 +68,7 @@ def gazelle_generation_test(name, gazelle_binary, test_data, build_in_suffix = "
             "-build_in_suffix=%s" % build_in_suffix,
             "-build_out_suffix=%s" % build_out_suffix,
             "-timeout=%ds" % gazelle_timeout_seconds,
+            "-check_idempotency=%s" % ("true" if check_idempotency else "false"),
         ],
         size = size,
         data = test_data + [
@@ -21,6 +21,8 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bazelbuild/buildtools/build"
 )
 
@@ -47,6 +49,96 @@ func ExtractModuleToApparentNameMapping(repoRoot string) (func(string) string, e
 	}, nil
 }
 
+// ExtractRepoMapping returns a mapping between canonical and apparent names of
+// the repositories of modules the repo depends on, according to its
+// MODULE.bazel file. If there is no MODULE.bazel file, the mapping only covers
+// the main repository.
+func ExtractRepoMapping(repoRoot string) (*label.RepoMapping, error) {
+	moduleFile, err := parseModuleFile(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	if moduleFile == nil {
+		return label.NewRepoMapping("", nil), nil
+	}
+	var rootModule string
+	for _, r := range moduleFile.Rules("module") {
+		rootModule = r.AttrString("name")
+	}
+	return label.NewRepoMapping(rootModule, collectApparentNames(moduleFile)), nil
+}
+
+// legacyModuleNames maps names of well-known modules to the names their
+// repositories were conventionally given in WORKSPACE files. Modules whose
+// repositories had the same name in WORKSPACE are not listed.
+var legacyModuleNames = map[string][]string{
+	"abseil-cpp": {"com_google_absl"},
+	"buildtools": {"com_github_bazelbuild_buildtools"},
+	"gazelle":    {"bazel_gazelle"},
+	"googleapis": {"com_google_googleapis", "go_googleapis"},
+	"googletest": {"com_google_googletest"},
+	"grpc":       {"com_github_grpc_grpc"},
+	"protobuf":   {"com_google_protobuf"},
+	"re2":        {"com_googlesource_code_re2"},
+	"rules_go":   {"io_bazel_rules_go"},
+	"stardoc":    {"io_bazel_stardoc"},
+	"zlib":       {"net_zlib"},
+}
+
+// ExtractLegacyToApparentNameMapping returns a mapping from names of
+// repositories declared in WORKSPACE (e.g. "io_bazel_rules_go") to the
+// apparent names of the same repositories in the repo's MODULE.bazel
+// (e.g. "rules_go"). Names that are the same in both are not included.
+//
+// Repositories of well-known modules declared with bazel_dep are mapped
+// from their conventional WORKSPACE names. Go repositories are mapped
+// using the go_deps module extension: each go_repository rule in repos is
+// mapped to the apparent name of the go_deps repository for its import path,
+// if use_repo imports it. Aliases in use_repo are also mapped from the names
+// of the repositories they refer to.
+//
+// If there is no MODULE.bazel file, ExtractLegacyToApparentNameMapping
+// returns nil.
+func ExtractLegacyToApparentNameMapping(repoRoot string, repos []*rule.Rule) (map[string]string, error) {
+	moduleFile, err := parseModuleFile(repoRoot)
+	if err != nil || moduleFile == nil {
+		return nil, err
+	}
+
+	legacyToApparent := make(map[string]string)
+	for moduleName, apparentName := range collectApparentNames(moduleFile) {
+		for _, legacyName := range legacyModuleNames[moduleName] {
+			legacyToApparent[legacyName] = apparentName
+		}
+	}
+
+	goDeps := collectGoDepsNames(moduleFile)
+	for repoName, apparentName := range goDeps {
+		if repoName != apparentName {
+			legacyToApparent[repoName] = apparentName
+		}
+	}
+	for _, r := range repos {
+		if r.Kind() != "go_repository" {
+			continue
+		}
+		importPath := r.AttrString("importpath")
+		if importPath == "" {
+			continue
+		}
+		if apparentName, ok := goDeps[label.ImportPathToBazelRepoName(importPath)]; ok && apparentName != r.Name() {
+			legacyToApparent[r.Name()] = apparentName
+		}
+	}
+
+	for legacyName, apparentName := range legacyToApparent {
+		if legacyName == apparentName {
+			delete(legacyToApparent, legacyName)
+		}
+	}
+	return legacyToApparent, nil
+}
+
 func parseModuleFile(repoRoot string) (*build.File, error) {
 	path := filepath.Join(repoRoot, "MODULE.bazel")
 	bytes, err := os.ReadFile(path)
@@ -84,3 +176,56 @@ func collectApparentNames(m *build.File) map[string]string {
 
 	return apparentNames
 }
+
+// collectGoDepsNames returns the repositories imported from the go_deps
+// module extension with use_repo, mapping the name of each repository
+// (derived from its module path) to the apparent name it's imported with.
+func collectGoDepsNames(m *build.File) map[string]string {
+	goDepsProxies := make(map[string]bool)
+	names := make(map[string]string)
+	for _, stmt := range m.Stmt {
+		if assign, ok := stmt.(*build.AssignExpr); ok {
+			lhs, ok := assign.LHS.(*build.Ident)
+			if !ok {
+				continue
+			}
+			call, ok := assign.RHS.(*build.CallExpr)
+			if !ok || !isCallTo(call, "use_extension") || len(call.List) < 2 {
+				continue
+			}
+			if extName, ok := call.List[1].(*build.StringExpr); ok && extName.Value == "go_deps" {
+				goDepsProxies[lhs.Name] = true
+			}
+			continue
+		}
+
+		call, ok := stmt.(*build.CallExpr)
+		if !ok || !isCallTo(call, "use_repo") || len(call.List) == 0 {
+			continue
+		}
+		proxy, ok := call.List[0].(*build.Ident)
+		if !ok || !goDepsProxies[proxy.Name] {
+			continue
+		}
+		for _, arg := range call.List[1:] {
+			switch arg := arg.(type) {
+			case *build.StringExpr:
+				names[arg.Value] = arg.Value
+			case *build.AssignExpr:
+				alias, ok := arg.LHS.(*build.Ident)
+				if !ok {
+					continue
+				}
+				if repoName, ok := arg.RHS.(*build.StringExpr); ok {
+					names[repoName.Value] = alias.Name
+				}
+			}
+		}
+	}
+	return names
+}
+
+func isCallTo(call *build.CallExpr, name string) bool {
+	ident, ok := call.X.(*build.Ident)
+	return ok && ident.Name == name
+}
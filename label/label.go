@@ -51,6 +51,12 @@ type Label struct {
 	// Relative indicates whether the label refers to a target in the current
 	// package. Relative is true if and only if Repo and Pkg are both omitted.
 	Relative bool
+
+	// Canonical indicates whether Repo is a canonical repository name, written
+	// with two @ characters ("@@repo//pkg:name"), rather than an apparent
+	// name. The canonical name of the main repository is empty ("@@//pkg").
+	// See https://bazel.build/external/overview#canonical-repo-name.
+	Canonical bool
 }
 
 // New constructs a new label from components.
@@ -65,6 +71,9 @@ var NoLabel = Label{}
 var (
 	// This was taken from https://github.com/bazelbuild/bazel/blob/71fb1e4188b01e582a308cfe4bcbf1c730eded1b/src/main/java/com/google/devtools/build/lib/cmdline/RepositoryName.java#L159C1-L164
 	labelRepoRegexp = regexp.MustCompile(`^@$|^[A-Za-z0-9_.-][A-Za-z0-9_.~-]*$`)
+	// Canonical repository names may also contain '+', which Bazel uses as a
+	// separator in names of repositories created by modules.
+	labelCanonicalRepoRegexp = regexp.MustCompile(`^[A-Za-z0-9_.~+-]+$`)
 	// This was taken from https://github.com/bazelbuild/bazel/blob/master/src/main/java/com/google/devtools/build/lib/cmdline/LabelValidator.java
 	// Package names may contain all 7-bit ASCII characters except:
 	// 0-31 (control characters)
@@ -83,11 +92,26 @@ func Parse(s string) (Label, error) {
 
 	relative := true
 	var repo string
-	// if target name begins @@ drop the first @
+	canonical := false
 	if strings.HasPrefix(s, "@@") {
-		s = s[len("@"):]
-	}
-	if strings.HasPrefix(s, "@") {
+		relative = false
+		canonical = true
+		s = s[len("@@"):]
+		endRepo := strings.Index(s, "//")
+		if endRepo < 0 {
+			repo = s
+			s = "//:" + repo
+		} else {
+			repo = s[:endRepo]
+			s = s[endRepo:]
+		}
+		if repo != "" && !labelCanonicalRepoRegexp.MatchString(repo) {
+			return NoLabel, fmt.Errorf("label parse error: repository has invalid characters: %q", origStr)
+		}
+		if repo == "" && s == "//:" {
+			return NoLabel, fmt.Errorf("label parse error: empty repository: %q", origStr)
+		}
+	} else if strings.HasPrefix(s, "@") {
 		relative = false
 		endRepo := strings.Index(s, "//")
 		if endRepo > len("@") {
@@ -139,10 +163,11 @@ func Parse(s string) (Label, error) {
 	}
 
 	return Label{
-		Repo:     repo,
-		Pkg:      pkg,
-		Name:     name,
-		Relative: relative,
+		Repo:      repo,
+		Pkg:       pkg,
+		Name:      name,
+		Relative:  relative,
+		Canonical: canonical,
 	}, nil
 }
 
@@ -152,7 +177,9 @@ func (l Label) String() string {
 	}
 
 	var repo string
-	if l.Repo != "" && l.Repo != "@" {
+	if l.Canonical {
+		repo = fmt.Sprintf("@@%s", l.Repo)
+	} else if l.Repo != "" && l.Repo != "@" {
 		repo = fmt.Sprintf("@%s", l.Repo)
 	} else {
 		// if l.Repo == "", the label string will begin with "//"
@@ -180,7 +207,7 @@ func (l Label) Abs(repo, pkg string) Label {
 // is already relative or is in a different package, this label may be
 // returned unchanged.
 func (l Label) Rel(repo, pkg string) Label {
-	if l.Relative || l.Repo != repo {
+	if l.Relative || l.Repo != repo || l.Canonical && l.Repo != "" {
 		return l
 	}
 	if l.Pkg == pkg {
@@ -189,8 +216,14 @@ func (l Label) Rel(repo, pkg string) Label {
 	return Label{Pkg: l.Pkg, Name: l.Name}
 }
 
-// Equal returns whether two labels are exactly the same. It does not return
-// true for different labels that refer to the same target.
+// Equal returns whether two labels have the same repository, package, name,
+// and relativity. It does not return true for different labels that refer
+// to the same target. Canonical and apparent repository names are
+// deliberately not distinguished: "@@foo//:bar" and "@foo//:bar" are Equal,
+// even though they may name different repositories, so that callers written
+// before canonical names were preserved keep working. Use EqualCanonical to
+// tell them apart, or RepoMapping.Equivalent to compare labels that may refer
+// to the same repository by different names.
 func (l Label) Equal(other Label) bool {
 	return l.Repo == other.Repo &&
 		l.Pkg == other.Pkg &&
@@ -198,6 +231,13 @@ func (l Label) Equal(other Label) bool {
 		l.Relative == other.Relative
 }
 
+// EqualCanonical is like Equal, but it also requires both repository names to
+// be canonical or both to be apparent. "@@foo//:bar" and "@foo//:bar" are
+// Equal but not EqualCanonical.
+func (l Label) EqualCanonical(other Label) bool {
+	return l.Equal(other) && l.Canonical == other.Canonical
+}
+
 // Contains returns whether other is contained by the package of l or a
 // sub-package. Neither label may be relative.
 func (l Label) Contains(other Label) bool {
@@ -46,6 +46,12 @@ func TestLabelString(t *testing.T) {
 		}, {
 			l:    Label{Repo: "@", Pkg: "foo/bar", Name: "baz"},
 			want: "@//foo/bar:baz",
+		}, {
+			l:    Label{Repo: "rules_go+", Pkg: "go", Name: "def", Canonical: true},
+			want: "@@rules_go+//go:def",
+		}, {
+			l:    Label{Pkg: "foo", Name: "foo", Canonical: true},
+			want: "@@//foo",
 		},
 	} {
 		if got, want := spec.l.String(), spec.want; got != want {
@@ -87,7 +93,11 @@ func TestParse(t *testing.T) {
 		{str: "@a//some/pkg/[someId]:[someId]", want: Label{Repo: "a", Pkg: "some/pkg/[someId]", Name: "[someId]"}},
 		{str: "@rules_python~0.0.0~pip~name_dep//:_pkg", want: Label{Repo: "rules_python~0.0.0~pip~name_dep", Name: "_pkg"}},
 		{str: "@rules_python~0.0.0~pip~name//:dep_pkg", want: Label{Repo: "rules_python~0.0.0~pip~name", Name: "dep_pkg"}},
-		{str: "@@rules_python~0.26.0~python~python_3_10_x86_64-unknown-linux-gnu//:python_runtimes", want: Label{Repo: "rules_python~0.26.0~python~python_3_10_x86_64-unknown-linux-gnu", Name: "python_runtimes"}},
+		{str: "@@rules_python~0.26.0~python~python_3_10_x86_64-unknown-linux-gnu//:python_runtimes", want: Label{Repo: "rules_python~0.26.0~python~python_3_10_x86_64-unknown-linux-gnu", Name: "python_runtimes", Canonical: true}},
+		{str: "@@", wantErr: true},
+		{str: "@@//a:b", want: Label{Pkg: "a", Name: "b", Canonical: true}},
+		{str: "@@rules_go+//go", want: Label{Repo: "rules_go+", Pkg: "go", Name: "go", Canonical: true}},
+		{str: "@@rules_go+", want: Label{Repo: "rules_go+", Name: "rules_go+", Canonical: true}},
 	} {
 		got, err := Parse(tc.str)
 		if err != nil && !tc.wantErr {
@@ -104,6 +114,68 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestRepoMapping(t *testing.T) {
+	m := NewRepoMapping("my_module", map[string]string{
+		"my_module":   "my_module",
+		"rules_go":    "io_bazel_rules_go",
+		"rules_proto": "rules_proto",
+	})
+	for _, tc := range []struct {
+		str, apparent, canonical string
+	}{
+		{str: "@@rules_go+//go:def", apparent: "@io_bazel_rules_go//go:def", canonical: "@@rules_go+//go:def"},
+		{str: "@@rules_go~//go:def", apparent: "@io_bazel_rules_go//go:def", canonical: "@@rules_go~//go:def"},
+		{str: "@@rules_proto~5.3.0//proto", apparent: "@rules_proto//proto", canonical: "@@rules_proto~5.3.0//proto"},
+		{str: "@io_bazel_rules_go//go:def", apparent: "@io_bazel_rules_go//go:def", canonical: "@@rules_go+//go:def"},
+		{str: "@@gazelle++go_deps+org_golang_x_mod//module", apparent: "@@gazelle++go_deps+org_golang_x_mod//module", canonical: "@@gazelle++go_deps+org_golang_x_mod//module"},
+		{str: "@@//a:b", apparent: "//a:b", canonical: "@@//a:b"},
+		{str: "@//a:b", apparent: "//a:b", canonical: "@@//a:b"},
+		{str: "@my_module//a:b", apparent: "//a:b", canonical: "@@//a:b"},
+		{str: "@unknown//a:b", apparent: "@unknown//a:b", canonical: "@unknown//a:b"},
+		{str: ":b", apparent: ":b", canonical: ":b"},
+	} {
+		l, err := Parse(tc.str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Apparent(l).String(); got != tc.apparent {
+			t.Errorf("Apparent(%q) = %q; want %q", tc.str, got, tc.apparent)
+		}
+		if got := m.Canonical(l).String(); got != tc.canonical {
+			t.Errorf("Canonical(%q) = %q; want %q", tc.str, got, tc.canonical)
+		}
+	}
+
+	a, _ := Parse("@@rules_go+//go:def")
+	b, _ := Parse("@io_bazel_rules_go//go:def")
+	if !m.Equivalent(a, b) {
+		t.Errorf("%s and %s are not equivalent", a, b)
+	}
+	var nilMapping *RepoMapping
+	if nilMapping.Equivalent(a, b) {
+		t.Errorf("%s and %s are equivalent without a mapping", a, b)
+	}
+	c, _ := Parse("@@//go:def")
+	d, _ := Parse("//go:def")
+	if !nilMapping.Equivalent(c, d) {
+		t.Errorf("%s and %s are not equivalent without a mapping", c, d)
+	}
+}
+
+func TestEqualCanonical(t *testing.T) {
+	a, _ := Parse("@@foo//:bar")
+	b, _ := Parse("@foo//:bar")
+	if !a.Equal(b) {
+		t.Errorf("%s and %s are not Equal", a, b)
+	}
+	if a.EqualCanonical(b) {
+		t.Errorf("%s and %s are EqualCanonical", a, b)
+	}
+	if !a.EqualCanonical(a) {
+		t.Errorf("%s is not EqualCanonical to itself", a)
+	}
+}
+
 func TestImportPathToBazelRepoName(t *testing.T) {
 	for path, want := range map[string]string{
 		"git.sr.ht/~urandom/errors": "ht_sr_git_urandom_errors",
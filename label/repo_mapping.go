@@ -0,0 +1,143 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package label
+
+import "strings"
+
+// RepoMapping converts labels between canonical repository names
+// ("@@rules_go+//go:def.bzl") and the apparent names the main repository
+// uses for them ("@io_bazel_rules_go//go:def.bzl"). It's built from the
+// bazel_dep and module directives in MODULE.bazel.
+//
+// Only repositories of modules are mapped. Canonical names of repositories
+// created by module extensions can't be mapped, since they're only known
+// to Bazel.
+//
+// A nil *RepoMapping is valid; it maps the canonical name of the main
+// repository, but no other repositories.
+type RepoMapping struct {
+	// rootApparentName is the apparent name of the main repository, from the
+	// module directive. It may be empty.
+	rootApparentName string
+
+	moduleToApparent map[string]string
+	apparentToModule map[string]string
+}
+
+// NewRepoMapping returns a RepoMapping for a main repository that's the
+// module rootModule (which may be empty), with dependencies on modules given
+// by the keys of moduleToApparent. Values are apparent repository names.
+func NewRepoMapping(rootModule string, moduleToApparent map[string]string) *RepoMapping {
+	m := &RepoMapping{
+		moduleToApparent: make(map[string]string, len(moduleToApparent)),
+		apparentToModule: make(map[string]string, len(moduleToApparent)),
+	}
+	for module, apparent := range moduleToApparent {
+		if module == rootModule {
+			m.rootApparentName = apparent
+			continue
+		}
+		m.moduleToApparent[module] = apparent
+		m.apparentToModule[apparent] = module
+	}
+	return m
+}
+
+// Apparent returns a label equivalent to l that uses an apparent repository
+// name. Labels in the main repository, written as "@@//pkg", "@//pkg", or
+// with the main module's apparent name, are returned with an empty Repo.
+// l is returned unchanged if it's relative or if its repository is not known.
+func (m *RepoMapping) Apparent(l Label) Label {
+	if l.Relative {
+		return l
+	}
+	if !l.Canonical {
+		if l.Repo == "@" || m != nil && l.Repo != "" && l.Repo == m.rootApparentName {
+			l.Repo = ""
+		}
+		return l
+	}
+	if l.Repo == "" {
+		l.Canonical = false
+		return l
+	}
+	if m == nil {
+		return l
+	}
+	module, ok := moduleFromCanonicalName(l.Repo)
+	if !ok {
+		return l
+	}
+	apparent, ok := m.moduleToApparent[module]
+	if !ok {
+		return l
+	}
+	l.Repo = apparent
+	l.Canonical = false
+	return l
+}
+
+// Canonical returns a label equivalent to l that uses a canonical repository
+// name. Labels in the main repository are returned with an empty canonical
+// Repo ("@@//pkg"). Repositories of modules are given names in the form Bazel
+// 8 uses ("module+"); since the format of canonical names is not stable,
+// these should only be used where Gazelle needs to write canonical labels.
+// l is returned unchanged if it's relative, already canonical, or if its
+// repository is not known.
+func (m *RepoMapping) Canonical(l Label) Label {
+	if l.Relative || l.Canonical {
+		return l
+	}
+	if l.Repo == "" || l.Repo == "@" || m != nil && l.Repo == m.rootApparentName {
+		l.Repo = ""
+		l.Canonical = true
+		return l
+	}
+	if m == nil {
+		return l
+	}
+	module, ok := m.apparentToModule[l.Repo]
+	if !ok {
+		return l
+	}
+	l.Repo = module + "+"
+	l.Canonical = true
+	return l
+}
+
+// Equivalent returns whether a and b refer to the same target, after
+// converting both to apparent names. Neither label may be relative, unless
+// both are.
+func (m *RepoMapping) Equivalent(a, b Label) bool {
+	return m.Apparent(a).EqualCanonical(m.Apparent(b))
+}
+
+// moduleFromCanonicalName returns the name of the module whose repository has
+// the given canonical name. Canonical names of module repositories have the
+// form "module+" (Bazel 8), "module~" (Bazel 7), or "module~version"
+// (Bazel 6). Repositories created by module extensions have longer names,
+// which are not matched.
+func moduleFromCanonicalName(name string) (string, bool) {
+	i := strings.IndexAny(name, "+~")
+	if i <= 0 {
+		return "", false
+	}
+	rest := name[i+1:]
+	if rest != "" && (name[i] != '~' || strings.ContainsAny(rest, "+~")) {
+		return "", false
+	}
+	return name[:i], true
+}
@@ -32,6 +32,7 @@ import (
 	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
 	"github.com/bazelbuild/bazel-gazelle/internal/module"
 	"github.com/bazelbuild/bazel-gazelle/internal/version"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
@@ -128,6 +129,44 @@ type goConfig struct {
 	// testMode determines how go_test targets are generated.
 	testMode testMode
 
+	// embedSubpackages determines whether go:embed patterns may match files
+	// in subdirectories with build files. Set with
+	// # gazelle:go_embed_subpackages.
+	embedSubpackages bool
+
+	// embedFilegroupName is the name of the filegroups generated in
+	// subpackages when embedSubpackages is true. Set with
+	// # gazelle:go_embed_filegroup_name.
+	embedFilegroupName string
+
+	// testDataInference determines whether go_test data dependencies are
+	// inferred from paths read by test code. Set with
+	// # gazelle:go_test_data_inference.
+	testDataInference bool
+
+	// testVariants is a list of build tags for which separate go_test targets
+	// are generated. Set with # gazelle:go_test_variant.
+	testVariants []testVariant
+
+	// goToolsPkg is the package (relative to the repository root) where
+	// alias rules are generated for tool directives in go.mod. Set with
+	// # gazelle:go_tools_package. Tool targets are only generated if
+	// goToolsPkgSet is true.
+	goToolsPkg    string
+	goToolsPkgSet bool
+
+	// cgoLibs maps names of libraries linked with -l in cgo LDFLAGS to labels
+	// of cc_library rules that provide them. Set with
+	// # gazelle:go_cgo_library. An empty label means the library is provided
+	// by the system, and the flag is kept.
+	cgoLibs map[string]string
+
+	// cgoPkgConfigs maps names of packages in cgo pkg-config directives to
+	// labels of cc_library rules that provide them. Set with
+	// # gazelle:go_cgo_pkg_config. An empty label means the package is
+	// ignored.
+	cgoPkgConfigs map[string]string
+
 	// buildDirectives, buildExternalAttr, buildExtraArgsAttr,
 	// buildFileGenerationAttr, buildFileNamesAttr, buildFileProtoModeAttr and
 	// buildTagsAttr are attributes for go_repository rules, set on the command
@@ -146,6 +185,50 @@ const (
 	fileTestMode
 )
 
+// testVariant describes an additional go_test target compiled with a build
+// tag, for example, an integration test suite. The variant's sources are the
+// test files that "go test -tags=<tag>" would compile.
+type testVariant struct {
+	// tag is the build tag that selects the variant's sources. It is also
+	// used in the variant's name and its gotags attribute.
+	tag string
+
+	// tags, size, and timeout are set on the generated go_test, if they
+	// are not already present.
+	tags          []string
+	size, timeout string
+}
+
+// parseTestVariant parses the value of a go_test_variant directive: a build
+// tag followed by optional tags=, size=, and timeout= settings.
+func parseTestVariant(value string) (testVariant, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return testVariant{}, errors.New("expected a build tag")
+	}
+	v := testVariant{tag: fields[0]}
+	if strings.HasPrefix(v.tag, "!") {
+		return testVariant{}, fmt.Errorf("build tags can't be negated: %s", v.tag)
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return testVariant{}, fmt.Errorf("expected key=value; got %q", field)
+		}
+		switch key {
+		case "tags":
+			v.tags = splitValue(value)
+		case "size":
+			v.size = value
+		case "timeout":
+			v.timeout = value
+		default:
+			return testVariant{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return v, nil
+}
+
 var (
 	defaultGoProtoCompilers = []string{"@io_bazel_rules_go//proto:go_proto"}
 	defaultGoGrpcCompilers  = []string{"@io_bazel_rules_go//proto:go_grpc"}
@@ -175,9 +258,12 @@ func testModeFromString(s string) (testMode, error) {
 
 func newGoConfig() *goConfig {
 	gc := &goConfig{
-		goProtoCompilers: defaultGoProtoCompilers,
-		goGrpcCompilers:  defaultGoGrpcCompilers,
-		goGenerateProto:  true,
+		goProtoCompilers:   defaultGoProtoCompilers,
+		goGrpcCompilers:    defaultGoGrpcCompilers,
+		goGenerateProto:    true,
+		embedFilegroupName: defaultEmbedFilegroupName,
+		cgoLibs:            make(map[string]string),
+		cgoPkgConfigs:      make(map[string]string),
 	}
 	gc.preprocessTags()
 	return gc
@@ -196,6 +282,15 @@ func (gc *goConfig) clone() *goConfig {
 	gcCopy.goProtoCompilers = gc.goProtoCompilers[:len(gc.goProtoCompilers):len(gc.goProtoCompilers)]
 	gcCopy.goGrpcCompilers = gc.goGrpcCompilers[:len(gc.goGrpcCompilers):len(gc.goGrpcCompilers)]
 	gcCopy.submodules = gc.submodules[:len(gc.submodules):len(gc.submodules)]
+	gcCopy.testVariants = gc.testVariants[:len(gc.testVariants):len(gc.testVariants)]
+	gcCopy.cgoLibs = make(map[string]string, len(gc.cgoLibs))
+	for k, v := range gc.cgoLibs {
+		gcCopy.cgoLibs[k] = v
+	}
+	gcCopy.cgoPkgConfigs = make(map[string]string, len(gc.cgoPkgConfigs))
+	for k, v := range gc.cgoPkgConfigs {
+		gcCopy.cgoPkgConfigs[k] = v
+	}
 	return &gcCopy
 }
 
@@ -381,12 +476,22 @@ var (
 func (*goLang) KnownDirectives() []string {
 	return []string{
 		"build_tags",
+		"go_cgo_library",
+		"go_cgo_pkg_config",
+		"go_embed_filegroup_name",
+		"go_embed_subpackages",
 		"go_generate_proto",
 		"go_grpc_compilers",
 		"go_naming_convention",
 		"go_naming_convention_external",
 		"go_proto_compilers",
 		"go_test",
+		"go_test_data_inference",
+		"go_test_variant",
+		// "go_tool" marks aliases generated for go.mod tool directives. It's
+		// interpreted by generateTools, not Configure.
+		"go_tool",
+		"go_tools_package",
 		"go_visibility",
 		"importmap_prefix",
 		"prefix",
@@ -587,6 +692,34 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 					log.Print(err)
 				}
 
+			case "go_cgo_library":
+				if name, l, err := parseCgoMapping(d.Value, rel); err != nil {
+					log.Printf("parsing go_cgo_library: %v", err)
+				} else {
+					gc.cgoLibs[name] = l
+				}
+
+			case "go_cgo_pkg_config":
+				if name, l, err := parseCgoMapping(d.Value, rel); err != nil {
+					log.Printf("parsing go_cgo_pkg_config: %v", err)
+				} else {
+					gc.cgoPkgConfigs[name] = l
+				}
+
+			case "go_embed_filegroup_name":
+				if err := checkEmbedFilegroupName(d.Value); err != nil {
+					log.Printf("parsing go_embed_filegroup_name: %v", err)
+				} else {
+					gc.embedFilegroupName = d.Value
+				}
+
+			case "go_embed_subpackages":
+				if embedSubpackages, err := strconv.ParseBool(d.Value); err == nil {
+					gc.embedSubpackages = embedSubpackages
+				} else {
+					log.Printf("parsing go_embed_subpackages: %v", err)
+				}
+
 			case "go_generate_proto":
 				if goGenerateProto, err := strconv.ParseBool(d.Value); err == nil {
 					gc.goGenerateProto = goGenerateProto
@@ -636,6 +769,36 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 				}
 				gc.testMode = mode
 
+			case "go_test_data_inference":
+				if testDataInference, err := strconv.ParseBool(d.Value); err == nil {
+					gc.testDataInference = testDataInference
+				} else {
+					log.Printf("parsing go_test_data_inference: %v", err)
+				}
+
+			case "go_test_variant":
+				// Special syntax (empty value) to reset directive.
+				if d.Value == "" {
+					gc.testVariants = nil
+					continue
+				}
+				v, err := parseTestVariant(d.Value)
+				if err != nil {
+					log.Printf("parsing go_test_variant: %v", err)
+					continue
+				}
+				variants := make([]testVariant, 0, len(gc.testVariants)+1)
+				for _, old := range gc.testVariants {
+					if old.tag != v.tag {
+						variants = append(variants, old)
+					}
+				}
+				gc.testVariants = append(variants, v)
+
+			case "go_tools_package":
+				gc.goToolsPkg = strings.Trim(strings.TrimSpace(d.Value), "/")
+				gc.goToolsPkgSet = true
+
 			case "go_visibility":
 				gc.goVisibility = append(gc.goVisibility, strings.TrimSpace(d.Value))
 
@@ -702,6 +865,26 @@ func checkPrefix(prefix string) error {
 	return nil
 }
 
+// parseCgoMapping parses the value of a go_cgo_library or go_cgo_pkg_config
+// directive: a library or package name, optionally followed by the label
+// of a cc_library. Relative labels are resolved against rel, the directory
+// containing the directive. The returned label is absolute or empty.
+func parseCgoMapping(value, rel string) (name, lbl string, err error) {
+	fields := strings.Fields(value)
+	switch len(fields) {
+	case 1:
+		return fields[0], "", nil
+	case 2:
+		l, err := label.Parse(fields[1])
+		if err != nil {
+			return "", "", err
+		}
+		return fields[0], l.Abs("", rel).String(), nil
+	default:
+		return "", "", fmt.Errorf("expected a name and an optional label; got %q", value)
+	}
+}
+
 // splitDirective splits a comma-separated directive value into its component
 // parts, trimming each of any whitespace characters.
 func splitValue(value string) []string {
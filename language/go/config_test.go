@@ -349,3 +349,49 @@ func TestSplitValue(t *testing.T) {
 
 	}
 }
+
+func TestParseTestVariant(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    testVariant
+		wantErr bool
+	}{
+		{
+			value: "integration",
+			want:  testVariant{tag: "integration"},
+		},
+		{
+			value: "e2e tags=manual,exclusive size=large timeout=long",
+			want: testVariant{
+				tag:     "e2e",
+				tags:    []string{"manual", "exclusive"},
+				size:    "large",
+				timeout: "long",
+			},
+		},
+		{
+			value:   "!integration",
+			wantErr: true,
+		},
+		{
+			value:   "integration shard_count=2",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseTestVariant(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %#v; want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(testVariant{})); diff != "" {
+				t.Errorf("(-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// fileDataRef is a path to a file or directory read by test code, found in
+// a call to a common file API like os.ReadFile.
+type fileDataRef struct {
+	// path is the referenced path. It's relative to the directory containing
+	// the test source unless runfiles is true.
+	path string
+
+	// runfiles is true if path was passed to Rlocation, so its first
+	// component is a repository name.
+	runfiles bool
+
+	pos token.Position
+}
+
+// dataFileFuncs lists functions that take a file or directory path as their
+// first argument, indexed by import path. Path helpers like filepath.Join
+// aren't listed, since they don't read files; they're evaluated when they're
+// passed to one of these functions.
+var dataFileFuncs = map[string]map[string]bool{
+	"os": {
+		"DirFS":    true,
+		"Lstat":    true,
+		"Open":     true,
+		"ReadDir":  true,
+		"ReadFile": true,
+		"Stat":     true,
+	},
+	"io/ioutil": {
+		"ReadDir":  true,
+		"ReadFile": true,
+	},
+}
+
+const runfilesImportPath = "github.com/bazelbuild/rules_go/go/runfiles"
+
+// testDataRefs returns the paths passed as constants to functions in
+// dataFileFuncs and to runfiles.Rlocation in a parsed test source file. When
+// a filepath.Join call is passed and only its leading arguments are constant,
+// the joined prefix is returned, since it's usually a directory of fixtures.
+func testDataRefs(fset *token.FileSet, pf *ast.File) []fileDataRef {
+	// Map local package names to import paths.
+	imports := make(map[string]string)
+	for _, spec := range pf.Imports {
+		imp, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if spec.Name != nil {
+			imports[spec.Name.Name] = imp
+		} else {
+			imports[path.Base(imp)] = imp
+		}
+	}
+	callee := func(call *ast.CallExpr) (imp, name string) {
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return "", ""
+		}
+		if x, ok := sel.X.(*ast.Ident); ok {
+			return imports[x.Name], sel.Sel.Name
+		}
+		return "", sel.Sel.Name
+	}
+	isJoin := func(call *ast.CallExpr) bool {
+		imp, name := callee(call)
+		return name == "Join" && (imp == "path" || imp == "path/filepath")
+	}
+
+	// constPath returns the constant value of a path expression. complete is
+	// false if only a leading part of a Join could be evaluated.
+	var constPath func(e ast.Expr) (p string, complete bool)
+	constPath = func(e ast.Expr) (string, bool) {
+		switch e := e.(type) {
+		case *ast.ParenExpr:
+			return constPath(e.X)
+		case *ast.BasicLit:
+			if e.Kind != token.STRING {
+				return "", false
+			}
+			s, err := strconv.Unquote(e.Value)
+			return s, err == nil
+		case *ast.BinaryExpr:
+			if e.Op != token.ADD {
+				return "", false
+			}
+			x, xok := constPath(e.X)
+			y, yok := constPath(e.Y)
+			if !xok || !yok {
+				return "", false
+			}
+			return x + y, true
+		case *ast.CallExpr:
+			if !isJoin(e) {
+				return "", false
+			}
+			var elems []string
+			for _, arg := range e.Args {
+				s, ok := constPath(arg)
+				if !ok {
+					if s != "" {
+						elems = append(elems, s)
+					}
+					return path.Join(elems...), false
+				}
+				elems = append(elems, s)
+			}
+			return path.Join(elems...), true
+		default:
+			return "", false
+		}
+	}
+
+	var refs []fileDataRef
+	ast.Inspect(pf, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		imp, name := callee(call)
+		var runfiles bool
+		switch {
+		case name == "Rlocation" && (imp == "" || imp == runfilesImportPath):
+			runfiles = true
+		case dataFileFuncs[imp][name]:
+		default:
+			return true
+		}
+		p, complete := constPath(call.Args[0])
+		if p == "" || (!complete && runfiles) || filepath.IsAbs(p) {
+			return true
+		}
+		refs = append(refs, fileDataRef{
+			path:     p,
+			runfiles: runfiles,
+			pos:      fset.Position(call.Pos()),
+		})
+		return true
+	})
+	return refs
+}
+
+// testDataKey is the private attribute of a go_test holding a *testDataInfo
+// when go_test_data_inference is enabled. The data attribute of those rules
+// is set in Resolve, once files in other packages can be found in the index.
+const testDataKey = "_gazelle_go_test_data"
+
+// dataLang is the language of import specs indexed for filegroups, which
+// provide the files and directories that test code may read.
+const dataLang = "go_data"
+
+// testDataInfo holds the data of a go_test found during generation.
+type testDataInfo struct {
+	// patterns are glob patterns for the testdata directory.
+	patterns []string
+
+	// refs are paths that may be provided by filegroups. They're looked up in
+	// the index before patterns and labels are used.
+	refs []testDataRef
+}
+
+// testDataRef is a path read by test code, relative to the repository root.
+type testDataRef struct {
+	rel string
+
+	// lbl or pattern refer to rel in the test's own package. Both are empty
+	// if rel is not in the package or doesn't exist.
+	lbl, pattern string
+
+	pos token.Position
+}
+
+// setTestData sets the data attribute of a go_test. The testdata directory
+// is included with a glob if present. When go_test_data_inference is
+// enabled, the paths read by the test code are recorded instead, and the
+// attribute is set by resolveTestData.
+func (g *generator) setTestData(r *rule.Rule, pkg *goPackage, test goTarget) {
+	var patterns []string
+	if pkg.hasTestdata {
+		patterns = append(patterns, "testdata/**")
+	}
+	if !getGoConfig(g.c).testDataInference {
+		if len(patterns) > 0 {
+			r.SetAttr("data", rule.GlobValue{Patterns: patterns})
+		}
+		return
+	}
+
+	info := &testDataInfo{patterns: patterns}
+	for _, ref := range test.dataRefs {
+		var rel string
+		if ref.runfiles {
+			repo, rest, _ := strings.Cut(path.Clean(ref.path), "/")
+			if repo != "_main" && repo != g.c.RepoName {
+				log.Printf("%s: could not resolve test data %q: files in repository %q are not supported", ref.pos, ref.path, repo)
+				continue
+			}
+			rel = rest
+		} else {
+			rel = path.Join(pkg.rel, filepath.ToSlash(ref.path))
+			if rel == ".." || strings.HasPrefix(rel, "../") {
+				log.Printf("%s: could not resolve test data %q: path is outside the repository", ref.pos, ref.path)
+				continue
+			}
+		}
+		if rel == "." {
+			rel = ""
+		}
+		if rel == pkg.rel {
+			log.Printf("%s: could not resolve test data %q: path is the package directory", ref.pos, ref.path)
+			continue
+		}
+		lbl, pattern := g.localDataRef(pkg, rel)
+		if pkg.hasTestdata && (strings.HasPrefix(lbl, ":testdata/") || strings.HasPrefix(pattern, "testdata/")) {
+			// Already covered by the testdata glob.
+			continue
+		}
+		info.refs = append(info.refs, testDataRef{rel: rel, lbl: lbl, pattern: pattern, pos: ref.pos})
+	}
+	r.SetPrivateAttr(testDataKey, info)
+}
+
+// localDataRef returns a label or a glob pattern for rel if it's a file or
+// directory in pkg. Directories of Go packages found during the walk are
+// not part of pkg.
+func (g *generator) localDataRef(pkg *goPackage, rel string) (lbl, pattern string) {
+	if !pathtools.HasPrefix(rel, pkg.rel) {
+		return "", ""
+	}
+	name := pathtools.TrimPrefix(rel, pkg.rel)
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := g.goPkgRels[path.Join(pkg.rel, dir)]; ok {
+			return "", ""
+		}
+	}
+	fi, err := os.Stat(filepath.Join(g.c.RepoRoot, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", ""
+	}
+	if fi.IsDir() {
+		return "", name + "/**"
+	}
+	return ":" + name, ""
+}
+
+// dataImports returns import specs for the files and directories provided
+// by a filegroup: the files listed in srcs, and the path the filegroup is
+// named after, which may be a directory it covers with a glob. Filegroups are
+// only indexed where go_test_data_inference is enabled.
+func dataImports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if !getGoConfig(c).testDataInference {
+		return nil
+	}
+	imps := []resolve.ImportSpec{{Lang: dataLang, Imp: path.Join(f.Pkg, r.Name())}}
+	for _, src := range r.AttrStrings("srcs") {
+		src = strings.TrimPrefix(src, ":")
+		if src == "" || strings.HasPrefix(src, "@") || strings.Contains(src, "//") {
+			continue
+		}
+		imps = append(imps, resolve.ImportSpec{Lang: dataLang, Imp: path.Join(f.Pkg, src)})
+	}
+	return imps
+}
+
+// resolveTestData sets the data attribute of a go_test from the
+// information recorded by setTestData. Each path read by the test is looked
+// up in the index, together with its parent directories below the test's
+// package. Paths not provided by a filegroup are referenced directly if they
+// are in the test's package, and reported otherwise.
+func resolveTestData(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, info *testDataInfo, from label.Label) {
+	patterns := append([]string(nil), info.patterns...)
+	labelSet := make(map[string]bool)
+	for _, ref := range info.refs {
+		if l, err := findDataRule(c, ix, ref.rel, from); err == nil {
+			labelSet[l.Rel(from.Repo, from.Pkg).String()] = true
+		} else if err != errNotFound {
+			log.Printf("%s: %v", ref.pos, err)
+		} else if ref.lbl != "" {
+			labelSet[ref.lbl] = true
+		} else if ref.pattern != "" {
+			patterns = append(patterns, ref.pattern)
+		} else {
+			log.Printf("%s: could not resolve test data %q: it's not in package %q and no filegroup provides it", ref.pos, ref.rel, from.Pkg)
+		}
+	}
+
+	var labels []string
+	for l := range labelSet {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	sort.Strings(patterns)
+	patterns = uniqStrings(patterns)
+
+	switch {
+	case len(patterns) > 0 && len(labels) > 0:
+		r.SetAttr("data", &bzl.BinaryExpr{
+			X:  rule.GlobValue{Patterns: patterns}.BzlExpr(),
+			Op: "+",
+			Y:  rule.ExprFromValue(labels),
+		})
+	case len(patterns) > 0:
+		r.SetAttr("data", rule.GlobValue{Patterns: patterns})
+	case len(labels) > 0:
+		r.SetAttr("data", labels)
+	}
+}
+
+// findDataRule returns the label of a filegroup providing rel or one of its
+// parent directories. Directories containing the package of from are not
+// considered. errNotFound is returned if no filegroup provides rel. If more
+// than one filegroup provides the closest path, an error is returned, and
+// no dependency should be added.
+func findDataRule(c *config.Config, ix *resolve.RuleIndex, rel string, from label.Label) (label.Label, error) {
+	for p := rel; p != "." && !pathtools.HasPrefix(from.Pkg, p); p = path.Dir(p) {
+		results := ix.FindRulesByImportWithConfig(c, resolve.ImportSpec{Lang: dataLang, Imp: p}, goName)
+		switch len(results) {
+		case 0:
+			continue
+		case 1:
+			return results[0].Label, nil
+		default:
+			return label.NoLabel, fmt.Errorf("rule %s reads test data %q which is provided by multiple rules: %s and %s", from, rel, results[0].Label, results[1].Label)
+		}
+	}
+	return label.NoLabel, errNotFound
+}
+
+func uniqStrings(ss []string) []string {
+	if len(ss) == 0 {
+		return ss
+	}
+	w := 1
+	for r := 1; r < len(ss); r++ {
+		if ss[r] != ss[w-1] {
+			ss[w] = ss[r]
+			w++
+		}
+	}
+	return ss[:w]
+}
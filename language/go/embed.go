@@ -21,23 +21,55 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	"golang.org/x/mod/module"
 )
 
 // embedResolver maps go:embed patterns in source files to lists of files that
 // should appear in embedsrcs attributes.
 type embedResolver struct {
+	// rel is the slash-separated path from the repository root to the package
+	// directory.
+	rel string
+
 	// files is a list of embeddable files and directory trees, rooted in the
 	// package directory.
 	files []*embeddableNode
+
+	// filegroupName is the name of the filegroup in subpackages that provides
+	// their embeddable files.
+	filegroupName string
 }
 
 type embeddableNode struct {
 	path    string
 	entries []*embeddableNode // non-nil for directories
+
+	// pkg is the path to the Bazel subpackage containing this node, relative
+	// to the package directory. It's empty for nodes in the package itself.
+	pkg string
+}
+
+// defaultEmbedFilegroupName is the default name of the filegroup generated in
+// subpackages whose files are embedded by Go packages in parent directories,
+// when go_embed_subpackages is enabled.
+const defaultEmbedFilegroupName = "embedsrcs"
+
+// embedDir records a directory visited with go_embed_subpackages enabled:
+// the go:embed patterns in its .go files, and its contents. Filegroups that
+// export files embedded by parent directories are generated from these after
+// the walk.
+type embedDir struct {
+	patterns                    []fileEmbed
+	subdirs, regFiles, genFiles []string
 }
 
 func (f *embeddableNode) isDir() bool {
@@ -73,11 +105,16 @@ func (f *embeddableNode) isHidden() bool {
 // entries for the entire workspace, but it should contain entries for
 // subdirectories processed earlier (this avoids redundant O(n^2) I/O).
 //
+// If subpackageFilegroup is not empty, files in subdirectories with build
+// files are listed too. Patterns that match them resolve to the subpackage's
+// filegroup with that name instead of the files themselves.
+//
 // subdirs, regFiles, and genFiles are lists of subdirectories, regular files,
 // and declared generated files in dir, respectively.
-func newEmbedResolver(dir, rel string, validBuildFileNames []string, pkgRels map[string]bool, subdirs, regFiles, genFiles []string) *embedResolver {
+func newEmbedResolver(dir, rel string, validBuildFileNames []string, pkgRels map[string]bool, subpackageFilegroup string, subdirs, regFiles, genFiles []string) *embedResolver {
 	root := &embeddableNode{entries: []*embeddableNode{}}
 	index := make(map[string]*embeddableNode)
+	dirPkgs := make(map[string]string)
 
 	var add func(string, bool) *embeddableNode
 	add = func(rel string, isDir bool) *embeddableNode {
@@ -114,9 +151,10 @@ func newEmbedResolver(dir, rel string, validBuildFileNames []string, pkgRels map
 			fileRel, _ := filepath.Rel(dir, p)
 			fileRel = filepath.ToSlash(fileRel)
 			base := filepath.Base(p)
+			pkg := dirPkgs[path.Dir(fileRel)]
 			if !info.IsDir() {
 				if !isBadEmbedName(base) {
-					add(fileRel, false)
+					add(fileRel, false).pkg = pkg
 					return nil
 				}
 				return nil
@@ -124,18 +162,27 @@ func newEmbedResolver(dir, rel string, validBuildFileNames []string, pkgRels map
 			if isBadEmbedName(base) {
 				return filepath.SkipDir
 			}
+			isPkg := false
 			if pkgRels[path.Join(rel, fileRel)] {
 				// Directory contains a Go package and will contain a build file,
 				// if it doesn't already.
-				return filepath.SkipDir
+				isPkg = true
 			}
 			for _, name := range validBuildFileNames {
 				if bFileInfo, err := os.Stat(filepath.Join(p, name)); err == nil && !bFileInfo.IsDir() {
 					// Directory already contains a build file.
+					isPkg = true
+					break
+				}
+			}
+			if isPkg {
+				if subpackageFilegroup == "" {
 					return filepath.SkipDir
 				}
+				pkg = fileRel
 			}
-			add(fileRel, true)
+			dirPkgs[fileRel] = pkg
+			add(fileRel, true).pkg = pkg
 			return nil
 		})
 		if err != nil {
@@ -143,7 +190,7 @@ func newEmbedResolver(dir, rel string, validBuildFileNames []string, pkgRels map
 		}
 	}
 
-	return &embedResolver{files: root.entries}
+	return &embedResolver{rel: rel, files: root.entries, filegroupName: subpackageFilegroup}
 }
 
 // resolve expands a single go:embed pattern into a list of files that should
@@ -175,13 +222,22 @@ func (er *embedResolver) resolve(embed fileEmbed) (list []string, err error) {
 	// For example, the pattern "*" matches "a", ".b", and "_c". If "a" is a
 	// directory, we would include "a/d", even though it doesn't match "*". We
 	// would not include "a/.e".
+	//
+	// Files in subpackages are replaced by the subpackage's filegroup.
+	pkgLabels := make(map[string]bool)
 	var visit func(*embeddableNode, bool)
 	visit = func(f *embeddableNode, add bool) {
 		convertedPath := filepath.ToSlash(f.path)
 		match, _ := path.Match(glob, convertedPath)
 		add = match || (add && (!f.isHidden() || all))
 		if !f.isDir() {
-			if add {
+			if add && f.pkg != "" {
+				l := label.New("", path.Join(er.rel, f.pkg), er.filegroupName).Rel("", er.rel).String()
+				if !pkgLabels[l] {
+					pkgLabels[l] = true
+					list = append(list, l)
+				}
+			} else if add {
 				list = append(list, convertedPath)
 			}
 			return
@@ -199,6 +255,170 @@ func (er *embedResolver) resolve(embed fileEmbed) (list []string, err error) {
 	return list, nil
 }
 
+// withPrefix returns a resolver with the same files, nested in directories
+// named by prefix. This lets patterns from a parent directory be matched
+// against files in a subpackage. er must not list files in subpackages.
+func (er *embedResolver) withPrefix(prefix string) *embedResolver {
+	var copyNode func(*embeddableNode) *embeddableNode
+	copyNode = func(n *embeddableNode) *embeddableNode {
+		c := &embeddableNode{path: path.Join(prefix, n.path)}
+		if n.isDir() {
+			c.entries = make([]*embeddableNode, 0, len(n.entries))
+			for _, e := range n.entries {
+				c.entries = append(c.entries, copyNode(e))
+			}
+		}
+		return c
+	}
+	files := make([]*embeddableNode, 0, len(er.files))
+	for _, f := range er.files {
+		files = append(files, copyNode(f))
+	}
+	for p := prefix; p != "."; p = path.Dir(p) {
+		files = []*embeddableNode{{path: p, entries: files}}
+	}
+	return &embedResolver{files: files}
+}
+
+// generateEmbedFilegroups generates a filegroup in each updated subpackage
+// listing files embedded by Go packages in parent directories, which can't
+// reference them directly. If no files are embedded, an empty rule is added
+// so an existing filegroup is deleted. Subpackages are skipped unless all
+// their parent directories were updated, since patterns in other directories
+// are unknown.
+func (gl *goLang) generateEmbedFilegroups(args language.PostWalkArgs) {
+	updated := make(map[string]bool)
+	for _, pkg := range args.Packages {
+		updated[pkg.Rel] = true
+	}
+	for _, pkg := range args.Packages {
+		d := gl.embedDirs[pkg.Rel]
+		if d == nil || (pkg.File == nil && len(pkg.Gen) == 0) {
+			// Only export files from directories that are Bazel packages.
+			continue
+		}
+		allUpdated := true
+		for rel := pkg.Rel; rel != "" && allUpdated; {
+			rel = path.Dir(rel)
+			if rel == "." {
+				rel = ""
+			}
+			allUpdated = updated[rel]
+		}
+		if !allUpdated {
+			continue
+		}
+
+		name := getGoConfig(pkg.Config).embedFilegroupName
+		if r := findRuleByName(pkg, name); r != nil && r.Kind() != "filegroup" {
+			log.Printf("%s: not generating filegroup %q for embedded files: a %s with the same name exists", pkg.Rel, name, r.Kind())
+			continue
+		}
+		fg := gl.generateEmbedFilegroup(pkg, name, d)
+		if fg.IsEmpty(goKinds[fg.Kind()]) {
+			pkg.Empty = append(pkg.Empty, fg)
+		} else {
+			pkg.Gen = append(pkg.Gen, fg)
+			pkg.Imports = append(pkg.Imports, nil)
+		}
+	}
+}
+
+// generateEmbedFilegroup generates a filegroup named name in pkg listing the
+// files matched by go:embed patterns in parent directories.
+func (gl *goLang) generateEmbedFilegroup(pkg *language.GeneratedPackage, name string, d *embedDir) *rule.Rule {
+	fg := rule.NewRule("filegroup", name)
+	var er *embedResolver
+	srcSet := make(map[string]bool)
+	var visibility []string
+	for parentRel := pkg.Rel; parentRel != ""; {
+		parentRel = path.Dir(parentRel)
+		if parentRel == "." {
+			parentRel = ""
+		}
+		parent := gl.embedDirs[parentRel]
+		if parent == nil {
+			continue
+		}
+		for _, embed := range parent.patterns {
+			if er == nil {
+				// Build files can't be embedded by other packages.
+				var regFiles []string
+				for _, f := range d.regFiles {
+					if !isBuildFileName(pkg.Config, f) {
+						regFiles = append(regFiles, f)
+					}
+				}
+				er = newEmbedResolver(pkg.Dir, pkg.Rel, pkg.Config.ValidBuildFileNames, gl.goPkgRels, "", d.subdirs, regFiles, d.genFiles)
+			}
+			prefix := pathtools.TrimPrefix(pkg.Rel, parentRel)
+			list, err := er.withPrefix(prefix).resolve(embed)
+			if err != nil {
+				// The pattern may match files in other directories.
+				continue
+			}
+			matched := false
+			for _, f := range list {
+				if src := strings.TrimPrefix(f, prefix+"/"); src != f {
+					srcSet[src] = true
+					matched = true
+				}
+			}
+			if matched {
+				visibility = append(visibility, label.New("", parentRel, "__pkg__").String())
+			}
+		}
+	}
+	if len(srcSet) == 0 {
+		return fg
+	}
+	srcs := make([]string, 0, len(srcSet))
+	for src := range srcSet {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	sort.Strings(visibility)
+	fg.SetAttr("srcs", srcs)
+	fg.SetAttr("visibility", uniqStrings(visibility))
+	return fg
+}
+
+// findRuleByName returns a rule named name in the existing build file of pkg
+// or among the rules generated for it, or nil if there is none.
+func findRuleByName(pkg *language.GeneratedPackage, name string) *rule.Rule {
+	if pkg.File != nil {
+		for _, r := range pkg.File.Rules {
+			if r.Name() == name {
+				return r
+			}
+		}
+	}
+	for _, r := range pkg.Gen {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func isBuildFileName(c *config.Config, name string) bool {
+	for _, base := range c.ValidBuildFileNames {
+		if name == base {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEmbedFilegroupName checks that name may be used as the name of
+// filegroups generated for embedded files.
+func checkEmbedFilegroupName(name string) error {
+	if l, err := label.Parse(":" + name); err != nil || l.Name != name {
+		return fmt.Errorf("invalid target name: %q", name)
+	}
+	return nil
+}
+
 // Copied from cmd/go/internal/load.validEmbedPattern.
 func validEmbedPattern(pattern string) bool {
 	return pattern != "." && fsValidPath(pattern)
@@ -68,6 +68,10 @@ type fileInfo struct {
 	// embeds is a list of //go:embed patterns and their positions.
 	embeds []fileEmbed
 
+	// dataRefs is a list of paths read by test code. It's only set for test
+	// files when readDataRefs is passed to goFileInfo.
+	dataRefs []fileDataRef
+
 	// isCgo is true for .go files that import "C".
 	isCgo bool
 
@@ -83,6 +87,10 @@ type fileInfo struct {
 	// of CPPFLAGS, CFLAGS, CXXFLAGS, and LDFLAGS directives in cgo comments.
 	cppopts, copts, cxxopts, clinkopts []*cgoTagsAndOpts
 
+	// pkgConfigs contains package names that are part of pkg-config
+	// directives in cgo comments. Flags passed to pkg-config are not included.
+	pkgConfigs []*cgoTagsAndOpts
+
 	// hasServices indicates whether a .proto file has service definitions.
 	hasServices bool
 }
@@ -208,10 +216,11 @@ func otherFileInfo(path string) fileInfo {
 // goFileInfo returns information about a .go file. It will parse part of the
 // file to determine the package name, imports, and build constraints.
 // If the file can't be read, an error will be logged, and partial information
-// will be returned.
+// will be returned. If readDataRefs is true and the file is a test, paths
+// read by the test code are also collected.
 // This function is intended to match go/build.Context.Import.
 // TODD(#53): extract canonical import path
-func goFileInfo(path, rel string) fileInfo {
+func goFileInfo(path, rel string, readDataRefs bool) fileInfo {
 	info := fileNameInfo(path)
 	fset := token.NewFileSet()
 	pf, err := parser.ParseFile(fset, info.path, nil, parser.ImportsOnly|parser.ParseComments)
@@ -274,7 +283,8 @@ func goFileInfo(path, rel string) fileInfo {
 	}
 	info.tags = tags
 
-	if importsEmbed || info.packageName == "main" {
+	readDataRefs = readDataRefs && info.isTest
+	if importsEmbed || info.packageName == "main" || readDataRefs {
 		pf, err = parser.ParseFile(fset, info.path, nil, parser.ParseComments)
 		if err != nil {
 			log.Printf("%s: error reading go file: %v", info.path, err)
@@ -309,14 +319,17 @@ func goFileInfo(path, rel string) fileInfo {
 				}
 			}
 		}
+		if readDataRefs {
+			info.dataRefs = testDataRefs(fset, pf)
+		}
 	}
 
 	return info
 }
 
-// saveCgo extracts CFLAGS, CPPFLAGS, CXXFLAGS, and LDFLAGS directives
-// from a comment above a "C" import. This is intended to match logic in
-// go/build.Context.saveCgo.
+// saveCgo extracts CFLAGS, CPPFLAGS, CXXFLAGS, LDFLAGS, and pkg-config
+// directives from a comment above a "C" import. This is intended to match
+// logic in go/build.Context.saveCgo.
 func saveCgo(info *fileInfo, rel string, cg *ast.CommentGroup) error {
 	text := cg.Text()
 	for _, line := range strings.Split(text, "\n") {
@@ -376,7 +389,17 @@ func saveCgo(info *fileInfo, rel string, cg *ast.CommentGroup) error {
 		case "LDFLAGS":
 			info.clinkopts = append(info.clinkopts, &cgoTagsAndOpts{tags, joinedStr})
 		case "pkg-config":
-			return fmt.Errorf("%s: pkg-config not supported: %s", info.path, orig)
+			// pkg-config can't be run hermetically. Packages are mapped to
+			// cc_library labels when rules are generated.
+			var pkgs []string
+			for _, opt := range opts {
+				if !strings.HasPrefix(opt, "-") {
+					pkgs = append(pkgs, opt)
+				}
+			}
+			if len(pkgs) > 0 {
+				info.pkgConfigs = append(info.pkgConfigs, &cgoTagsAndOpts{tags, strings.Join(pkgs, optSeparator)})
+			}
 		default:
 			return fmt.Errorf("%s: invalid #cgo verb: %s", info.path, orig)
 		}
@@ -16,6 +16,7 @@ limitations under the License.
 package golang
 
 import (
+	"fmt"
 	"go/build/constraint"
 	"os"
 	"path/filepath"
@@ -180,7 +181,7 @@ var src string
 				t.Fatal(err)
 			}
 
-			got := goFileInfo(path, "")
+			got := goFileInfo(path, "", false)
 			// Clear fields we don't care about for testing.
 			got = fileInfo{
 				packageName: got.packageName,
@@ -214,7 +215,7 @@ func TestGoFileInfoFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got := goFileInfo(path, "")
+	got := goFileInfo(path, "", false)
 	want := fileInfo{
 		path:   path,
 		name:   name,
@@ -228,6 +229,48 @@ func TestGoFileInfoFailure(t *testing.T) {
 
 }
 
+func TestGoFileInfoDataRefs(t *testing.T) {
+	dir, err := os.MkdirTemp(os.Getenv("TEST_TEMPDIR"), "TestGoFileInfoDataRefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "foo_test.go")
+	content := []byte(`package foo
+
+import (
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+)
+
+func TestFoo(t *testing.T) {
+	os.ReadFile("golden.txt")
+	os.Open(fp.Join("fixtures", t.Name()))
+	os.Stat("/abs")
+	runfiles.Rlocation("_main/shared/data.json")
+	runfiles.Rlocation(fp.Join("_main", t.Name()))
+}
+`)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := goFileInfo(path, "", false).dataRefs; got != nil {
+		t.Errorf("got %v without readDataRefs; want nil", got)
+	}
+	var got []string
+	for _, ref := range goFileInfo(path, "", true).dataRefs {
+		got = append(got, fmt.Sprintf("%s %v", ref.path, ref.runfiles))
+	}
+	want := []string{"golden.txt false", "fixtures false", "_main/shared/data.json true"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got): %s", diff)
+	}
+}
+
 func TestCgo(t *testing.T) {
 	for _, tc := range []struct {
 		desc, source string
@@ -296,6 +339,28 @@ import "C"
 				},
 			},
 		},
+		{
+			"pkg-config",
+			`package foo
+
+/*
+#cgo linux pkg-config: --static libudev libusb-1.0
+*/
+import "C"
+`,
+			fileInfo{
+				isCgo: true,
+				pkgConfigs: []*cgoTagsAndOpts{
+					{
+						buildTags: &buildTags{
+							expr:    mustParseBuildTag(t, "linux"),
+							rawTags: []string{"linux"},
+						},
+						opts: strings.Join([]string{"libudev", "libusb-1.0"}, optSeparator),
+					},
+				},
+			},
+		},
 		{
 			"slashslash comments",
 			`package foo
@@ -341,15 +406,16 @@ import ("C")
 				t.Fatal(err)
 			}
 
-			got := goFileInfo(path, "")
+			got := goFileInfo(path, "", false)
 
 			// Clear fields we don't care about for testing.
 			got = fileInfo{
-				isCgo:     got.isCgo,
-				copts:     got.copts,
-				cppopts:   got.cppopts,
-				cxxopts:   got.cxxopts,
-				clinkopts: got.clinkopts,
+				isCgo:      got.isCgo,
+				copts:      got.copts,
+				cppopts:    got.cppopts,
+				cxxopts:    got.cxxopts,
+				clinkopts:  got.clinkopts,
+				pkgConfigs: got.pkgConfigs,
 			}
 
 			if diff := cmp.Diff(tc.want, got, fileInfoCmpOption); diff != "" {
@@ -427,7 +493,7 @@ import "C"
 		t,
 		"-repo_root="+repo,
 		"-go_prefix=example.com/repo")
-	fi := goFileInfo(filepath.Join(sub, "sub.go"), "sub")
+	fi := goFileInfo(filepath.Join(sub, "sub.go"), "sub", false)
 	pkgs, _ := buildPackages(c, sub, "sub", false, nil, []fileInfo{fi})
 	got, ok := pkgs["sub"]
 	if !ok {
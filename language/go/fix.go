@@ -69,11 +69,11 @@ func migrateNamingConvention(c *config.Config, f *rule.File) {
 		switch {
 		case r.Name() == libName:
 			haveLib = true
-		case r.Kind() == "go_library" && r.Name() == migrateLibName && r.AttrString("importpath") == importPath:
+		case r.Kind() == "go_library" && r.Name() == migrateLibName && r.AttrString("importpath") == importPath && r.ShouldManageAttr("name"):
 			haveMigrateLib = true
 		case r.Name() == testName:
 			haveTest = true
-		case r.Kind() == "go_test" && r.Name() == migrateTestName && strListAttrContains(r, "embed", ":"+migrateLibName):
+		case r.Kind() == "go_test" && r.Name() == migrateTestName && strListAttrContains(r, "embed", ":"+migrateLibName) && r.ShouldManageAttr("name"):
 			haveMigrateTest = true
 		}
 	}
@@ -131,6 +131,9 @@ func fileContainsGoBinary(c *config.Config, f *rule.File) bool {
 }
 
 func replaceInStrListAttr(r *rule.Rule, attr, old, new string) {
+	if !r.ShouldManageAttr(attr) {
+		return
+	}
 	items := r.AttrStrings(attr)
 	changed := false
 	for i := range items {
@@ -156,14 +159,16 @@ func strListAttrContains(r *rule.Rule, attr, s string) bool {
 
 // migrateLibraryEmbed converts "library" attributes to "embed" attributes,
 // preserving comments. This only applies to Go rules, and only if there is
-// no keep comment on "library" and no existing "embed" attribute.
+// no keep comment on "library", no existing "embed" attribute, and Gazelle
+// manages both attributes.
 func migrateLibraryEmbed(c *config.Config, f *rule.File) {
 	for _, r := range f.Rules {
 		if !isGoRule(r.Kind()) {
 			continue
 		}
 		libExpr := r.Attr("library")
-		if libExpr == nil || rule.ShouldKeep(libExpr) || r.Attr("embed") != nil {
+		if libExpr == nil || rule.ShouldKeep(libExpr) || r.Attr("embed") != nil ||
+			!r.ShouldManageAttr("library") || !r.ShouldManageAttr("embed") {
 			continue
 		}
 		r.DelAttr("library")
@@ -175,7 +180,7 @@ func migrateLibraryEmbed(c *config.Config, f *rule.File) {
 // rules with a "compilers" attribute.
 func migrateGrpcCompilers(c *config.Config, f *rule.File) {
 	for _, r := range f.Rules {
-		if r.Kind() != "go_grpc_library" || r.ShouldKeep() || r.Attr("compilers") != nil {
+		if r.Kind() != "go_grpc_library" || r.ShouldKeep() || r.Attr("compilers") != nil || !r.ShouldManageAttr("compilers") {
 			continue
 		}
 		r.SetKind("go_proto_library")
@@ -190,11 +195,14 @@ func migrateGrpcCompilers(c *config.Config, f *rule.File) {
 // Note that the library attribute is disregarded, so cgo_library and
 // go_library attributes will be squashed even if the cgo_library was unlinked.
 // MergeFile will remove unused values and attributes later.
+//
+// Rules with attributes Gazelle doesn't manage (see rule.ShouldManageAttr)
+// are not squashed.
 func squashCgoLibrary(c *config.Config, f *rule.File) {
 	// Find the default cgo_library and go_library rules.
 	var cgoLibrary, goLibrary *rule.Rule
 	for _, r := range f.Rules {
-		if r.Kind() == "cgo_library" && r.Name() == "cgo_default_library" && !r.ShouldKeep() {
+		if r.Kind() == "cgo_library" && r.Name() == "cgo_default_library" && !r.ShouldKeep() && !r.HasUnmanagedAttrs() {
 			if cgoLibrary != nil {
 				log.Printf("%s: when fixing existing file, multiple cgo_library rules with default name found", f.Path)
 				continue
@@ -220,12 +228,18 @@ func squashCgoLibrary(c *config.Config, f *rule.File) {
 	}
 
 	if goLibrary == nil {
+		if !cgoLibrary.ShouldManageAttr("name") || !cgoLibrary.ShouldManageAttr("cgo") {
+			return
+		}
 		cgoLibrary.SetKind("go_library")
 		cgoLibrary.SetName(defaultLibName)
 		cgoLibrary.SetAttr("cgo", true)
 		return
 	}
 
+	if goLibrary.HasUnmanagedAttrs() {
+		return
+	}
 	if err := rule.SquashRules(cgoLibrary, goLibrary, f.Path); err != nil {
 		log.Print(err)
 		return
@@ -253,7 +267,8 @@ func squashXtest(c *config.Config, f *rule.File) {
 		}
 	}
 
-	if xtest == nil || xtest.ShouldKeep() || (itest != nil && itest.ShouldKeep()) {
+	if xtest == nil || xtest.ShouldKeep() || xtest.HasUnmanagedAttrs() ||
+		(itest != nil && (itest.ShouldKeep() || itest.HasUnmanagedAttrs())) {
 		return
 	}
 	if !c.ShouldFix {
@@ -267,6 +282,9 @@ func squashXtest(c *config.Config, f *rule.File) {
 
 	// If there was no internal test, we can just rename the external test.
 	if itest == nil {
+		if !xtest.ShouldManageAttr("name") {
+			return
+		}
 		xtest.SetName(defaultTestName)
 		return
 	}
@@ -286,7 +304,7 @@ func squashXtest(c *config.Config, f *rule.File) {
 // duplicate expressions.
 func flattenSrcs(c *config.Config, f *rule.File) {
 	for _, r := range f.Rules {
-		if !isGoRule(r.Kind()) {
+		if !isGoRule(r.Kind()) || !r.ShouldManageAttr("srcs") {
 			continue
 		}
 		oldSrcs := r.Attr("srcs")
@@ -304,7 +322,8 @@ func flattenSrcs(c *config.Config, f *rule.File) {
 // from go_proto_library.bzl. It deletes proto filegroups. It removes
 // go_proto_library attributes which are no longer recognized. New rules
 // are generated in place of the deleted rules, but attributes and comments
-// are not migrated.
+// are not migrated. Rules with attributes Gazelle doesn't manage are not
+// deleted.
 func removeLegacyProto(c *config.Config, f *rule.File) {
 	// Don't fix if the proto mode was set to something other than the default.
 	if pcMode := getProtoMode(c); pcMode != proto.DefaultMode {
@@ -320,6 +339,9 @@ func removeLegacyProto(c *config.Config, f *rule.File) {
 	}
 	var protoFilegroups, protoRules []*rule.Rule
 	for _, r := range f.Rules {
+		if r.HasUnmanagedAttrs() {
+			continue
+		}
 		if r.Kind() == "filegroup" && r.Name() == legacyProtoFilegroupName {
 			protoFilegroups = append(protoFilegroups, r)
 		}
@@ -521,6 +521,27 @@ go_test(
     srcs = ["foo_test.go"],
     embed = [":go_default_library"],
 )
+`,
+		},
+		{
+			desc: "unmanaged library not migrated",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+# gazelle:manage srcs,deps
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    library = ":go_default_library",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+# gazelle:manage srcs,deps
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    library = ":go_default_library",
+)
 `,
 		},
 		{
@@ -828,6 +849,37 @@ go_test(
         ":x_dep",
     ],
 )
+`,
+		},
+		{
+			desc: "xtest with unmanaged attributes not squashed",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["i_test.go"],
+)
+
+# gazelle:manage -data
+go_test(
+    name = "go_default_xtest",
+    srcs = ["x_test.go"],
+    data = ["x.txt"],
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["i_test.go"],
+)
+
+# gazelle:manage -data
+go_test(
+    name = "go_default_xtest",
+    srcs = ["x_test.go"],
+    data = ["x.txt"],
+)
 `,
 		},
 		// removeLegacyProto tests
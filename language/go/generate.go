@@ -26,6 +26,7 @@ import (
 	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/pathtools"
@@ -121,9 +122,13 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	var er *embedResolver
 	for i, name := range goFiles {
 		path := filepath.Join(args.Dir, name)
-		goFileInfos[i] = goFileInfo(path, args.Rel)
+		goFileInfos[i] = goFileInfo(path, args.Rel, getGoConfig(c).testDataInference)
 		if len(goFileInfos[i].embeds) > 0 && er == nil {
-			er = newEmbedResolver(args.Dir, args.Rel, c.ValidBuildFileNames, gl.goPkgRels, args.Subdirs, args.RegularFiles, args.GenFiles)
+			var subpackageFilegroup string
+			if gc := getGoConfig(c); gc.embedSubpackages {
+				subpackageFilegroup = gc.embedFilegroupName
+			}
+			er = newEmbedResolver(args.Dir, args.Rel, c.ValidBuildFileNames, gl.goPkgRels, subpackageFilegroup, args.Subdirs, args.RegularFiles, args.GenFiles)
 		}
 	}
 	goPackageMap, goFilesWithUnknownPackage := buildPackages(c, args.Dir, args.Rel, hasTestdata, er, goFileInfos)
@@ -182,6 +187,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 		c:                   c,
 		rel:                 args.Rel,
 		shouldSetVisibility: shouldSetVisibility(args),
+		goPkgRels:           gl.goPkgRels,
 	}
 	var res language.GenerateResult
 	var rules []*rule.Rule
@@ -283,7 +289,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 			rules = append(rules, r)
 		}
 		rules = append(rules, g.generateBin(pkg, libName))
-		rules = append(rules, g.generateTests(pkg, libName)...)
+		rules = append(rules, g.generateTests(pkg, libName, args.File)...)
 	}
 
 	for _, r := range rules {
@@ -295,6 +301,27 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 		}
 	}
 
+	// Generate aliases for tools declared in go.mod. These don't have an actual
+	// attribute until they're resolved, so they can't be checked for emptiness.
+	if gc := getGoConfig(c); gc.goToolsPkgSet && gc.goToolsPkg == args.Rel {
+		gen, empty := g.generateTools(args.File)
+		for _, r := range gen {
+			res.Gen = append(res.Gen, r)
+			res.Imports = append(res.Imports, r.PrivateAttr(config.GazelleImportsKey))
+		}
+		res.Empty = append(res.Empty, empty...)
+	}
+
+	// Record embed patterns and directory contents, so files embedded by
+	// packages in parent directories can be exported after the walk.
+	if getGoConfig(c).embedSubpackages {
+		d := &embedDir{subdirs: args.Subdirs, regFiles: args.RegularFiles, genFiles: args.GenFiles}
+		for _, info := range goFileInfos {
+			d.patterns = append(d.patterns, info.embeds...)
+		}
+		gl.embedDirs[args.Rel] = d
+	}
+
 	if args.File != nil || len(res.Gen) > 0 {
 		gl.goPkgRels[args.Rel] = true
 	} else {
@@ -309,6 +336,19 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	return res
 }
 
+// GenerateRulesAfterWalk generates filegroups in subpackages for files
+// embedded by Go packages in parent directories. This is done after the walk,
+// since parent directories are visited after their subdirectories.
+func (gl *goLang) GenerateRulesAfterWalk(args language.PostWalkArgs) {
+	gl.generateEmbedFilegroups(args)
+}
+
+// NeedsPostWalk returns true once a directory is visited with
+// go_embed_subpackages enabled.
+func (gl *goLang) NeedsPostWalk() bool {
+	return len(gl.embedDirs) > 0
+}
+
 func filterFiles(files *[]string, pred func(string) bool) {
 	w := 0
 	for r := 0; r < len(*files); r++ {
@@ -416,6 +456,10 @@ type generator struct {
 	c                   *config.Config
 	rel                 string
 	shouldSetVisibility bool
+
+	// goPkgRels is the set of directories containing Go packages found so
+	// far in the walk. See goLang.goPkgRels.
+	goPkgRels map[string]bool
 }
 
 func (g *generator) generateProto(mode proto.Mode, target protoTarget, importPath string) (string, []*rule.Rule) {
@@ -516,7 +560,7 @@ func (g *generator) generateBin(pkg *goPackage, library string) *rule.Rule {
 	return goBinary
 }
 
-func (g *generator) generateTests(pkg *goPackage, library string) []*rule.Rule {
+func (g *generator) generateTests(pkg *goPackage, library string, f *rule.File) []*rule.Rule {
 	gc := getGoConfig(g.c)
 	tests := pkg.tests
 	if len(tests) == 0 && gc.testMode == defaultTestMode {
@@ -553,13 +597,95 @@ func (g *generator) generateTests(pkg *goPackage, library string) []*rule.Rule {
 			embed = library
 		}
 		g.setCommonAttrs(goTest, pkg.rel, nil, test, embed)
-		if pkg.hasTestdata {
-			goTest.SetAttr("data", rule.GlobValue{Patterns: []string{"testdata/**"}})
+		g.setTestData(goTest, pkg, test)
+	}
+	var defaultTest goTarget
+	if gc.testMode == defaultTestMode {
+		defaultTest = tests[0]
+	}
+	res = append(res, g.generateTestVariants(pkg, library, defaultTest, f)...)
+	return res
+}
+
+// generateTestVariants generates a go_test for each go_test_variant directive.
+// A variant is named after the default test with the variant's tag inserted
+// before the "_test" suffix. Variants that would have the same sources as the
+// default test are returned empty, so existing rules are deleted.
+//
+// Existing variants whose directive was removed are also returned empty. A
+// go_test in f is treated as a variant if its gotags attribute has a single
+// tag and its name is the one a variant with that tag would have. Variants
+// are only generated in the default test mode, so in other modes, all
+// existing variants are deleted.
+func (g *generator) generateTestVariants(pkg *goPackage, library string, defaultTest goTarget, f *rule.File) []*rule.Rule {
+	gc := getGoConfig(g.c)
+	baseName := strings.TrimSuffix(testNameByConvention(gc.goNamingConvention, pkg.importPath), "_test")
+	variantName := func(tag string) string {
+		return baseName + "_" + strings.ReplaceAll(tag, ".", "_") + "_test"
+	}
+	var variants []testVariant
+	if gc.testMode == defaultTestMode {
+		variants = gc.testVariants
+	}
+	var res []*rule.Rule
+	names := make(map[string]bool)
+	for _, v := range variants {
+		names[variantName(v.tag)] = true
+	}
+	if f != nil {
+		for _, r := range f.Rules {
+			if r.Kind() != "go_test" || names[r.Name()] {
+				continue
+			}
+			if tags := r.AttrStrings("gotags"); len(tags) == 1 && r.Name() == variantName(tags[0]) {
+				res = append(res, rule.NewRule("go_test", r.Name()))
+			}
+		}
+	}
+
+	defaultSrcs := defaultTest.sources.buildFlat()
+	for i, v := range variants {
+		goTest := rule.NewRule("go_test", variantName(v.tag))
+		res = append(res, goTest)
+		var test goTarget
+		if i < len(pkg.testVariants) {
+			test = pkg.testVariants[i]
+		}
+		if !test.sources.hasGo() || stringSlicesEqual(test.sources.buildFlat(), defaultSrcs) {
+			continue
+		}
+		var embed string
+		if test.hasInternalTest {
+			embed = library
+		}
+		g.setCommonAttrs(goTest, pkg.rel, nil, test, embed)
+		g.setTestData(goTest, pkg, test)
+		goTest.SetAttr("gotags", []string{v.tag})
+		if len(v.tags) > 0 {
+			goTest.SetAttr("tags", v.tags)
+		}
+		if v.size != "" {
+			goTest.SetAttr("size", v.size)
+		}
+		if v.timeout != "" {
+			goTest.SetAttr("timeout", v.timeout)
 		}
 	}
 	return res
 }
 
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // maybePublishToolLib makes the given go_library rule public if needed for nogo.
 // Updating it here automatically makes it easier to upgrade org_golang_x_tools.
 func (g *generator) maybePublishToolLib(lib *rule.Rule, pkg *goPackage) {
@@ -651,6 +777,9 @@ func (g *generator) setCommonAttrs(r *rule.Rule, pkgRel string, visibility []str
 	if !target.cxxopts.isEmpty() {
 		r.SetAttr("cxxopts", g.options(target.cxxopts.build(), pkgRel))
 	}
+	if !target.cdeps.isEmpty() {
+		r.SetAttr("cdeps", g.cdeps(target.cdeps.build()))
+	}
 	if g.shouldSetVisibility && len(visibility) > 0 {
 		r.SetAttr("visibility", visibility)
 	}
@@ -788,6 +917,20 @@ func (g *generator) options(opts rule.PlatformStrings, pkgRel string) rule.Platf
 	return opts
 }
 
+// cdeps transforms absolute labels of cc_library rules mapped with
+// go_cgo_library and go_cgo_pkg_config directives into labels relative to
+// the current package.
+func (g *generator) cdeps(labels rule.PlatformStrings) rule.PlatformStrings {
+	labels, _ = labels.Map(func(s string) (string, error) {
+		l, err := label.Parse(s)
+		if err != nil {
+			return s, nil
+		}
+		return l.Rel("", g.rel).String(), nil
+	})
+	return labels
+}
+
 func escapeOption(opt string) string {
 	return strings.NewReplacer(
 		`\`, `\\`,
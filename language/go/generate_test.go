@@ -240,6 +240,43 @@ go_test(name = "foo_test")
 	}
 }
 
+func TestGenerateRulesEmptyTestVariants(t *testing.T) {
+	c, langs, _ := testConfig(t, "-go_prefix=example.com/repo")
+	goLang := langs[1].(*goLang)
+	old, err := rule.LoadData("BUILD.bazel", "foo", []byte(`
+go_test(
+    name = "foo_integration_test",
+    srcs = ["integration_test.go"],
+    gotags = ["integration"],
+)
+
+go_test(
+    name = "foo_handwritten_test",
+    srcs = ["handwritten_test.go"],
+    gotags = ["other"],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := goLang.GenerateRules(language.GenerateArgs{
+		Config: c,
+		Dir:    "./foo",
+		Rel:    "foo",
+		File:   old,
+	})
+	var names []string
+	for _, r := range res.Empty {
+		if r.Kind() == "go_test" {
+			names = append(names, r.Name())
+		}
+	}
+	want := []string{"foo_test", "foo_integration_test"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("empty go_test rules (-want, +got):\n%s", diff)
+	}
+}
+
 func TestGenerateRulesPrebuiltGoProtoRules(t *testing.T) {
 	for _, protoFlag := range []string{
 		"-proto=default",
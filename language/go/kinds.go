@@ -24,6 +24,9 @@ var goKinds = map[string]rule.KindInfo{
 	"alias": {
 		NonEmptyAttrs:  map[string]bool{"actual": true},
 		MergeableAttrs: map[string]bool{"actual": true},
+		// actual is set during resolution for aliases generated for go.mod
+		// tool directives.
+		ResolveAttrs: map[string]bool{"actual": true},
 	},
 	"filegroup": {
 		NonEmptyAttrs:  map[string]bool{"srcs": true},
@@ -38,6 +41,7 @@ var goKinds = map[string]rule.KindInfo{
 		},
 		SubstituteAttrs: map[string]bool{"embed": true},
 		MergeableAttrs: map[string]bool{
+			"cdeps":     true,
 			"cgo":       true,
 			"clinkopts": true,
 			"cppopts":   true,
@@ -60,6 +64,7 @@ var goKinds = map[string]rule.KindInfo{
 			"embed": true,
 		},
 		MergeableAttrs: map[string]bool{
+			"cdeps":      true,
 			"cgo":        true,
 			"clinkopts":  true,
 			"cppopts":    true,
@@ -125,6 +130,7 @@ var goKinds = map[string]rule.KindInfo{
 			"srcs":  true,
 		},
 		MergeableAttrs: map[string]bool{
+			"cdeps":     true,
 			"cgo":       true,
 			"clinkopts": true,
 			"cppopts":   true,
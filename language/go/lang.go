@@ -53,7 +53,11 @@ limitations under the License.
 // Known Types and Google APIs. rules_go declares canonical rules for these.
 package golang
 
-import "github.com/bazelbuild/bazel-gazelle/language"
+import (
+	"context"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
 
 const goName = "go"
 
@@ -62,10 +66,28 @@ type goLang struct {
 	// Go code. If the value is false, it means the directory does not contain
 	// buildable Go code, but it has a subdir which does.
 	goPkgRels map[string]bool
+
+	// embedDirs maps relative paths of directories visited with
+	// go_embed_subpackages enabled to their go:embed patterns and contents.
+	embedDirs map[string]*embedDir
 }
 
 func (*goLang) Name() string { return goName }
 
 func NewLanguage() language.Language {
-	return &goLang{goPkgRels: make(map[string]bool)}
+	return &goLang{
+		goPkgRels: make(map[string]bool),
+		embedDirs: make(map[string]*embedDir),
+	}
+}
+
+// Before clears directories recorded in a previous run. Gazelle may run more
+// than once in the same process, for example in -mode=idempotency.
+func (gl *goLang) Before(ctx context.Context) {
+	gl.goPkgRels = make(map[string]bool)
+	gl.embedDirs = make(map[string]*embedDir)
 }
+
+func (*goLang) DoneGeneratingRules() {}
+
+func (*goLang) AfterResolvingDeps(ctx context.Context) {}
@@ -18,16 +18,19 @@ package golang
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"golang.org/x/mod/modfile"
 )
 
 func importReposFromModules(args language.ImportReposArgs) language.ImportReposResult {
 	// run go list in the dir where go.mod is located
-	data, err := goListModules(filepath.Dir(args.Path))
+	data, err := goListModules(args.Context, filepath.Dir(args.Path))
 	if err != nil {
 		return language.ImportReposResult{Error: processGoListError(err, data)}
 	}
@@ -56,10 +59,42 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 		}
 	}
 
-	pathToModule, err = fillMissingSums(pathToModule)
+	pathToModule, err = fillMissingSums(args.Context, pathToModule)
 	if err != nil {
 		return language.ImportReposResult{Error: fmt.Errorf("finding module sums: %v", err)}
 	}
 
+	checkGoModTools(args.Path, pathToModule)
+
 	return language.ImportReposResult{Gen: toRepositoryRules(pathToModule)}
 }
+
+// checkGoModTools reports tool directives in the go.mod file at goModPath
+// that name packages outside the main module and every required module.
+// Aliases generated for such tools can't be resolved to a go_repository.
+func checkGoModTools(goModPath string, pathToModule map[string]*moduleFromList) {
+	tools, err := readGoModTools(goModPath)
+	if err != nil {
+		log.Printf("reading tool directives: %v", err)
+		return
+	}
+	if len(tools) == 0 {
+		return
+	}
+	var mainPath string
+	if data, err := os.ReadFile(goModPath); err == nil {
+		mainPath = modfile.ModulePath(data)
+	}
+ToolLoop:
+	for _, tool := range tools {
+		if mainPath != "" && pathtools.HasPrefix(tool, mainPath) {
+			continue
+		}
+		for _, mod := range pathToModule {
+			if pathtools.HasPrefix(tool, mod.Path) {
+				continue ToolLoop
+			}
+		}
+		log.Printf("%s: tool %s is not provided by any required module", goModPath, tool)
+	}
+}
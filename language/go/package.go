@@ -35,6 +35,8 @@ type goPackage struct {
 	name, dir, rel        string
 	library, binary, test goTarget
 	tests                 []goTarget
+	testVariants          []goTarget
+	testVariantConfigs    []*config.Config
 	proto                 protoTarget
 	hasTestdata           bool
 	hasMainFunction       bool
@@ -44,8 +46,12 @@ type goPackage struct {
 // goTarget contains information used to generate an individual Go rule
 // (library, binary, or test).
 type goTarget struct {
-	sources, embedSrcs, imports, cppopts, copts, cxxopts, clinkopts platformStringsBuilder
-	cgo, hasInternalTest                                            bool
+	sources, embedSrcs, imports, cppopts, copts, cxxopts, clinkopts, cdeps platformStringsBuilder
+	cgo, hasInternalTest                                                   bool
+
+	// dataRefs are paths read by test sources. They're only collected when
+	// go_test_data_inference is enabled.
+	dataRefs []fileDataRef
 }
 
 // protoTarget contains information used to generate a go_proto_library rule.
@@ -120,6 +126,7 @@ func (pkg *goPackage) addFile(c *config.Config, er *embedResolver, info fileInfo
 		if !info.isExternalTest {
 			test.hasInternalTest = true
 		}
+		pkg.addTestVariantsFile(c, er, info)
 	default:
 		pkg.hasMainFunction = pkg.hasMainFunction || info.hasMainFunction
 		pkg.library.addFile(c, er, info)
@@ -128,6 +135,36 @@ func (pkg *goPackage) addFile(c *config.Config, er *embedResolver, info fileInfo
 	return nil
 }
 
+// addTestVariantsFile adds a test file to each go_test_variant target whose
+// build tag makes the file buildable. Variants are only generated in
+// defaultTestMode.
+func (pkg *goPackage) addTestVariantsFile(c *config.Config, er *embedResolver, info fileInfo) {
+	gc := getGoConfig(c)
+	if gc.testMode != defaultTestMode || len(gc.testVariants) == 0 {
+		return
+	}
+	if pkg.testVariants == nil {
+		// Each variant's configuration is cloned once per package, with the
+		// variant's tag set, and reused for each file.
+		pkg.testVariants = make([]goTarget, len(gc.testVariants))
+		pkg.testVariantConfigs = make([]*config.Config, len(gc.testVariants))
+		for i, v := range gc.testVariants {
+			vc := c.Clone()
+			vgc := gc.clone()
+			vgc.genericTags[v.tag] = true
+			vc.Exts[goName] = vgc
+			pkg.testVariantConfigs[i] = vc
+		}
+	}
+	for i := range gc.testVariants {
+		test := &pkg.testVariants[i]
+		test.addFile(pkg.testVariantConfigs[i], er, info)
+		if !info.isExternalTest {
+			test.hasInternalTest = true
+		}
+	}
+}
+
 // isCommand returns true if the package name is "main".
 func (pkg *goPackage) isCommand() bool {
 	return pkg.name == "main" && pkg.hasMainFunction
@@ -150,6 +187,9 @@ func (pkg *goPackage) firstGoFile() string {
 	for _, test := range pkg.tests {
 		goSrcs = append(goSrcs, test.sources)
 	}
+	for _, test := range pkg.testVariants {
+		goSrcs = append(goSrcs, test.sources)
+	}
 
 	for _, sb := range goSrcs {
 		if sb.strs != nil {
@@ -302,6 +342,7 @@ func (t *goTarget) addFile(c *config.Config, er *embedResolver, info fileInfo) {
 	add := getPlatformStringsAddFunction(c, info, nil)
 	add(&t.sources, info.name)
 	add(&t.imports, info.imports...)
+	t.dataRefs = append(t.dataRefs, info.dataRefs...)
 	if er != nil {
 		for _, embed := range info.embeds {
 			embedSrcs, err := er.resolve(embed)
@@ -338,7 +379,79 @@ func (t *goTarget) addFile(c *config.Config, er *embedResolver, info fileInfo) {
 		if !clinkopts.empty() {
 			optAdd = getPlatformStringsAddFunction(c, info, clinkopts)
 		}
-		optAdd(&t.clinkopts, clinkopts.opts)
+		opts, cdeps := mapCgoLinkOpts(c, info, clinkopts.opts)
+		if opts != "" {
+			optAdd(&t.clinkopts, opts)
+		}
+		optAdd(&t.cdeps, cdeps...)
+	}
+	for _, pkgConfig := range info.pkgConfigs {
+		optAdd := add
+		if !pkgConfig.empty() {
+			optAdd = getPlatformStringsAddFunction(c, info, pkgConfig)
+		}
+		optAdd(&t.cdeps, mapCgoPkgConfigs(c, info, pkgConfig.opts)...)
+	}
+}
+
+// mapCgoLinkOpts removes -l flags for libraries mapped with
+// # gazelle:go_cgo_library from a group of linker options. It returns the
+// remaining options and the labels of the libraries that were removed.
+// Unmapped libraries are reported if any cgo mapping directive is in effect,
+// since linking against them is probably not hermetic.
+func mapCgoLinkOpts(c *config.Config, info fileInfo, opts string) (string, []string) {
+	gc := getGoConfig(c)
+	var keptOpts, cdeps []string
+	for _, opt := range strings.Split(opts, optSeparator) {
+		lib := strings.TrimPrefix(opt, "-l")
+		if lib == opt || lib == "" || strings.ContainsAny(lib, ",:") {
+			keptOpts = append(keptOpts, opt)
+			continue
+		}
+		if l, ok := gc.cgoLibs[lib]; !ok {
+			reportUnmappedCgo(c, info, "library", lib, "go_cgo_library")
+			keptOpts = append(keptOpts, opt)
+		} else if l == "" {
+			// Provided by the system.
+			keptOpts = append(keptOpts, opt)
+		} else {
+			cdeps = append(cdeps, l)
+		}
+	}
+	return strings.Join(keptOpts, optSeparator), cdeps
+}
+
+// mapCgoPkgConfigs returns labels for pkg-config packages mapped with
+// # gazelle:go_cgo_pkg_config. Unmapped packages are reported if any cgo
+// mapping directive is in effect, since pkg-config is not supported by
+// rules_go.
+func mapCgoPkgConfigs(c *config.Config, info fileInfo, pkgs string) []string {
+	gc := getGoConfig(c)
+	var cdeps []string
+	for _, pkg := range strings.Split(pkgs, optSeparator) {
+		if l, ok := gc.cgoPkgConfigs[pkg]; !ok {
+			reportUnmappedCgo(c, info, "pkg-config package", pkg, "go_cgo_pkg_config")
+		} else if l != "" {
+			cdeps = append(cdeps, l)
+		}
+	}
+	return cdeps
+}
+
+// reportUnmappedCgo reports a library or pkg-config package named in a cgo
+// directive that isn't mapped to a Bazel label with the given directive.
+// Gazelle exits in strict mode. Nothing is reported unless a
+// go_cgo_library or go_cgo_pkg_config directive is in effect, so
+// repositories that don't map cgo dependencies aren't affected.
+func reportUnmappedCgo(c *config.Config, info fileInfo, kind, name, directive string) {
+	if gc := getGoConfig(c); len(gc.cgoLibs) == 0 && len(gc.cgoPkgConfigs) == 0 {
+		return
+	}
+	log.Printf("%s: cgo %s %q is not mapped to a Bazel label. Use # gazelle:%s to map it.", info.path, kind, name, directive)
+	if c.Strict {
+		// TODO(https://github.com/bazelbuild/bazel-gazelle/issues/1029):
+		// Refactor to accumulate and propagate errors to main.
+		log.Fatal("Exit as strict mode is on")
 	}
 }
 
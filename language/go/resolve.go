@@ -31,7 +31,10 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-func (*goLang) Imports(_ *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+func (*goLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if r.Kind() == "filegroup" {
+		return dataImports(c, r, f)
+	}
 	if !isGoLibrary(r.Kind()) || isExtraLibrary(r) {
 		return nil
 	}
@@ -67,6 +70,15 @@ func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remo
 		// may not be set in tests.
 		return
 	}
+	if tool, ok := importsRaw.(toolImport); ok {
+		if err := resolveTool(c, ix, rc, r, string(tool), from); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	if info, ok := r.PrivateAttr(testDataKey).(*testDataInfo); ok {
+		resolveTestData(c, ix, r, info, from)
+	}
 	imports := importsRaw.(rule.PlatformStrings)
 	r.DelAttr("deps")
 	var resolve func(*config.Config, *resolve.RuleIndex, *repo.RemoteCache, string, label.Label) (label.Label, error)
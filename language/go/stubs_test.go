@@ -15,6 +15,8 @@ limitations under the License.
 */
 package golang
 
+import "context"
+
 func init() {
 	// Replace some functions with test stubs. This avoids a dependency on
 	// the go command in the actual test, which is sandboxed.
@@ -22,7 +24,7 @@ func init() {
 	goModDownload = goModDownloadStub
 }
 
-func goListModulesStub(dir string) ([]byte, error) {
+func goListModulesStub(_ context.Context, dir string) ([]byte, error) {
 	return []byte(`{
 	"Path": "github.com/bazelbuild/bazel-gazelle",
 	"Main": true,
@@ -99,7 +101,7 @@ func goListModulesStub(dir string) ([]byte, error) {
 `), nil
 }
 
-func goModDownloadStub(dir string, args []string) ([]byte, error) {
+func goModDownloadStub(_ context.Context, dir string, args []string) ([]byte, error) {
 	return []byte(`{
 	"Path": "golang.org/x/tools",
 	"Version": "v0.0.0-20190122202912-9c309ee22fab",
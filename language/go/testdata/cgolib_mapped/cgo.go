@@ -0,0 +1,7 @@
+package cgolib_mapped
+
+/*
+#cgo LDFLAGS: -lfoo -lm -L/opt/lib
+#cgo linux pkg-config: libudev
+*/
+import "C"
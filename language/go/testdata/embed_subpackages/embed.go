@@ -0,0 +1,6 @@
+package embed_subpackages
+
+import "embed"
+
+//go:embed static.txt assets/*.txt
+var files embed.FS
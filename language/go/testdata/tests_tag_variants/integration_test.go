@@ -0,0 +1,12 @@
+//go:build integration
+
+package variants_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIntegration(t *testing.T) {
+	_ = http.DefaultClient
+}
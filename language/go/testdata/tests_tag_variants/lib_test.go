@@ -0,0 +1,5 @@
+package variants
+
+import "testing"
+
+func TestLib(t *testing.T) {}
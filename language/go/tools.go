@@ -0,0 +1,204 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"golang.org/x/mod/modfile"
+)
+
+// toolImport is the import information recorded for alias rules generated
+// for go.mod tool directives. It holds the package path of the tool's main
+// package, which is resolved to a go_binary label during dependency
+// resolution.
+type toolImport string
+
+// readGoModTools returns the package paths named by tool directives in the
+// go.mod file at goModPath, in the order they appear.
+//
+// The tool directive was added in Go 1.24. We parse it out of the syntax tree
+// ourselves, since modfile.ParseLax ignores directives it doesn't need to
+// build a dependency's module graph.
+func readGoModTools(goModPath string) ([]string, error) {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.ParseLax(goModPath, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var tools []string
+	addTool := func(tok string) error {
+		if strings.HasPrefix(tok, `"`) || strings.HasPrefix(tok, "`") {
+			var err error
+			if tok, err = strconv.Unquote(tok); err != nil {
+				return fmt.Errorf("%s: invalid quoted tool path %s: %v", goModPath, tok, err)
+			}
+		}
+		tools = append(tools, tok)
+		return nil
+	}
+	for _, stmt := range f.Syntax.Stmt {
+		switch stmt := stmt.(type) {
+		case *modfile.Line:
+			if len(stmt.Token) == 2 && stmt.Token[0] == "tool" {
+				if err := addTool(stmt.Token[1]); err != nil {
+					return nil, err
+				}
+			}
+		case *modfile.LineBlock:
+			if len(stmt.Token) != 1 || stmt.Token[0] != "tool" {
+				continue
+			}
+			for _, line := range stmt.Line {
+				if len(line.Token) == 1 {
+					if err := addTool(line.Token[0]); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return tools, nil
+}
+
+// readNearestGoModTools returns the package paths named by tool directives
+// in the go.mod file in the directory rel, or in its nearest parent
+// directory within the repository that has a go.mod file. It returns no
+// tools if there's no go.mod file.
+func readNearestGoModTools(repoRoot, rel string) ([]string, error) {
+	for {
+		tools, err := readGoModTools(filepath.Join(repoRoot, filepath.FromSlash(rel), "go.mod"))
+		if !os.IsNotExist(err) {
+			return tools, err
+		}
+		if rel == "" {
+			return nil, nil
+		}
+		rel = path.Dir(rel)
+		if rel == "." {
+			rel = ""
+		}
+	}
+}
+
+// toolName returns the name "go tool" uses for the tool with the given
+// package path. This is the last element of the path, skipping a major
+// version suffix.
+func toolName(toolPath string) string {
+	if p := pathWithoutSemver(toolPath); p != "" && path.Base(p) != path.Base(toolPath) {
+		toolPath = p
+	}
+	return path.Base(toolPath)
+}
+
+// generateTools generates an alias rule for each tool directive in the
+// nearest go.mod file. The actual attribute is set by Resolve, once the
+// tool's main package can be looked up.
+//
+// Generated aliases are marked with a "# gazelle:go_tool" comment naming the
+// tool, and existing aliases for tools in go.mod are marked too, since
+// Gazelle takes them over. Marked aliases for tools that are no longer in
+// go.mod are returned as empty rules, so they're deleted. Other aliases are
+// left alone, even if they look like generated ones.
+func (g *generator) generateTools(f *rule.File) (gen, empty []*rule.Rule) {
+	tools, err := readNearestGoModTools(g.c.RepoRoot, g.rel)
+	if err != nil {
+		log.Printf("reading tool directives: %v", err)
+	}
+	names := make(map[string]string)
+	for _, tool := range tools {
+		name := toolName(tool)
+		if _, ok := names[name]; ok {
+			continue
+		}
+		names[name] = tool
+		r := rule.NewRule("alias", name)
+		r.AddComment(toolComment(tool))
+		if g.shouldSetVisibility {
+			r.SetAttr("visibility", []string{"//visibility:public"})
+		}
+		r.SetPrivateAttr(config.GazelleImportsKey, toolImport(tool))
+		gen = append(gen, r)
+	}
+
+	if f != nil {
+		for _, r := range f.Rules {
+			if r.Kind() != "alias" || r.Name() == defaultLibName {
+				continue
+			}
+			if tool, ok := names[r.Name()]; ok {
+				if !r.ShouldKeep() && !isGeneratedToolAlias(r) {
+					r.AddComment(toolComment(tool))
+				}
+				continue
+			}
+			if !isGeneratedToolAlias(r) {
+				continue
+			}
+			empty = append(empty, rule.NewRule("alias", r.Name()))
+		}
+	}
+	return gen, empty
+}
+
+// toolComment returns the comment that marks an alias generated for the
+// go.mod tool with the given package path.
+func toolComment(tool string) string {
+	return "# gazelle:go_tool " + tool
+}
+
+// isGeneratedToolAlias returns whether r is marked with a "# gazelle:go_tool"
+// comment, as aliases generated by generateTools are.
+func isGeneratedToolAlias(r *rule.Rule) bool {
+	for _, c := range r.Comments() {
+		if strings.HasPrefix(c, "# gazelle:go_tool ") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTool sets the actual attribute of an alias generated for a go.mod
+// tool directive to the go_binary built from the tool's main package.
+func resolveTool(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, tool string, from label.Label) error {
+	l, err := ResolveGo(c, ix, rc, tool, from)
+	if err == errSkipImport {
+		return fmt.Errorf("%s: tool %q does not refer to a package that can be built with Bazel", from, tool)
+	} else if err != nil {
+		return err
+	}
+	// ResolveGo returns the label of a library. By convention, the binary for
+	// a main package is named after the directory in the same package.
+	l.Name = pathtools.RelBaseName(l.Pkg, tool, "")
+	r.SetAttr("actual", l.Rel(from.Repo, from.Pkg).String())
+	return nil
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package golang
 
 import (
+	"context"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -68,6 +69,9 @@ func (*goLang) CanImport(path string) bool {
 }
 
 func (*goLang) ImportRepos(args language.ImportReposArgs) language.ImportReposResult {
+	if args.Context == nil {
+		args.Context = context.Background()
+	}
 	res := repoImportFuncs[filepath.Base(args.Path)](args)
 	for _, r := range res.Gen {
 		setBuildAttrs(getGoConfig(args.Config), r)
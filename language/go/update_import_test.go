@@ -16,6 +16,7 @@ limitations under the License.
 package golang
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -32,8 +33,8 @@ func TestImports(t *testing.T) {
 	for _, tc := range []struct {
 		desc, want        string
 		wantErr           string
-		stubGoModDownload func(string, []string) ([]byte, error)
-		stubGoListModules func(string) ([]byte, error)
+		stubGoModDownload func(context.Context, string, []string) ([]byte, error)
+		stubGoListModules func(context.Context, string) ([]byte, error)
 		files             []testtools.FileSpec
 	}{
 		{
@@ -180,7 +181,7 @@ definitely.doesnotexist/ever v0.1.0/go.mod h1:HI93XBmqTisBFMUTm0b8Fm+jr3Dg1NNxqw
 			},
 			want:    "",
 			wantErr: "finding module sums: error from go mod download: failed to download\nError downloading definitely.doesnotexist/ever: Did not exist",
-			stubGoModDownload: func(dir string, args []string) ([]byte, error) {
+			stubGoModDownload: func(_ context.Context, dir string, args []string) ([]byte, error) {
 				return []byte(`{
 "Path": "definitely.doesnotexist/ever",
 "Version": "0.1.0",
@@ -209,7 +210,7 @@ definitely.doesnotexist/ever v0.1.0/go.mod h1:HI93XBmqTisBFMUTm0b8Fm+jr3Dg1NNxqw
 			},
 			want:    "",
 			wantErr: "finding module sums: error from go mod download: failed to download\nError parsing module for more error information: invalid character 'o' in literal null (expecting 'u')",
-			stubGoModDownload: func(dir string, args []string) ([]byte, error) {
+			stubGoModDownload: func(_ context.Context, dir string, args []string) ([]byte, error) {
 				return []byte(`{
 "Path": "definitely.doesnotexist/ever",
 "Version": "0.1.0",
@@ -240,7 +241,7 @@ definitely.doesnotexist/ever v0.1.0/go.mod h1:HI93XBmqTisBFMUTm0b8Fm+jr3Dg1NNxqw
 			},
 			want:    "",
 			wantErr: "error from go list: failed to download\nError listing definitely.doesnotexist/ever: Did not exist",
-			stubGoListModules: func(dir string) ([]byte, error) {
+			stubGoListModules: func(_ context.Context, dir string) ([]byte, error) {
 				return []byte(`{
 "Path": "definitely.doesnotexist/ever",
 "Version": "0.1.0",
@@ -269,7 +270,7 @@ definitely.doesnotexist/ever v0.1.0/go.mod h1:HI93XBmqTisBFMUTm0b8Fm+jr3Dg1NNxqw
 			},
 			want:    "",
 			wantErr: "error from go list: failed to download\nError parsing module for more error information: invalid character 'n' after object key",
-			stubGoListModules: func(dir string) ([]byte, error) {
+			stubGoListModules: func(_ context.Context, dir string) ([]byte, error) {
 				return []byte(`{
     "Path": "definitely.doesnotexist/ever",
     "Version": "0.1.0",
@@ -421,7 +422,7 @@ go_repository(
 )
 `,
 			wantErr: "",
-			stubGoModDownload: func(s string, i []string) ([]byte, error) {
+			stubGoModDownload: func(_ context.Context, s string, i []string) ([]byte, error) {
 				return []byte(`
 {
 	"Path": "github.com/a8m/tree",
@@ -505,7 +506,7 @@ go_repository(
 }
 `), nil
 			},
-			stubGoListModules: func(dir string) ([]byte, error) {
+			stubGoListModules: func(_ context.Context, dir string) ([]byte, error) {
 				return []byte(`
 {
         "Path": "project1",
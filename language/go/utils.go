@@ -17,6 +17,7 @@ package golang
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -34,16 +35,16 @@ import (
 )
 
 // goListModules invokes "go list" in a directory containing a go.mod file.
-var goListModules = func(dir string) ([]byte, error) {
-	return runGoCommandForOutput(dir, "list", "-mod=readonly", "-e", "-m", "-json", "all")
+var goListModules = func(ctx context.Context, dir string) ([]byte, error) {
+	return runGoCommandForOutput(ctx, dir, "list", "-mod=readonly", "-e", "-m", "-json", "all")
 }
 
 // goModDownload invokes "go mod download" in a directory containing a
 // go.mod file.
-var goModDownload = func(dir string, args []string) ([]byte, error) {
+var goModDownload = func(ctx context.Context, dir string, args []string) ([]byte, error) {
 	dlArgs := []string{"mod", "download", "-json"}
 	dlArgs = append(dlArgs, args...)
-	return runGoCommandForOutput(dir, dlArgs...)
+	return runGoCommandForOutput(ctx, dir, dlArgs...)
 }
 
 // modulesFromList is an abstraction to preserve the output of `go list`.
@@ -106,7 +107,7 @@ func extractModules(data []byte) (map[string]*moduleFromList, error) {
 // fillMissingSums runs `go mod download` to get missing sums.
 // This must be done in a temporary directory because 'go mod download'
 // may modify go.mod and go.sum. It does not support -mod=readonly.
-func fillMissingSums(pathToModule map[string]*moduleFromList) (map[string]*moduleFromList, error) {
+func fillMissingSums(ctx context.Context, pathToModule map[string]*moduleFromList) (map[string]*moduleFromList, error) {
 	var missingSumArgs []string
 	for pathVer, mod := range pathToModule {
 		if mod.Sum == "" {
@@ -120,7 +121,7 @@ func fillMissingSums(pathToModule map[string]*moduleFromList) (map[string]*modul
 			return nil, err
 		}
 		defer os.RemoveAll(tmpDir)
-		data, err := goModDownload(tmpDir, missingSumArgs)
+		data, err := goModDownload(ctx, tmpDir, missingSumArgs)
 		dec := json.NewDecoder(bytes.NewReader(data))
 		if err != nil {
 			// Best-effort try to adorn specific error details from the JSON output.
@@ -214,7 +215,9 @@ func findGoTool() string {
 	return path
 }
 
-func runGoCommandForOutput(dir string, args ...string) ([]byte, error) {
+// runGoCommandForOutput runs the go command in dir and returns its standard
+// output. The command is killed when ctx is done.
+func runGoCommandForOutput(ctx context.Context, dir string, args ...string) ([]byte, error) {
 	goTool := findGoTool()
 	env := os.Environ()
 	env = append(env, "GO111MODULE=on")
@@ -234,12 +237,15 @@ func runGoCommandForOutput(dir string, args ...string) ([]byte, error) {
 		env = append(env, "GOPATH="+gopath)
 		defer os.RemoveAll(gopath)
 	}
-	cmd := exec.Command(goTool, args...)
+	cmd := exec.CommandContext(ctx, goTool, args...)
 	stderr := &bytes.Buffer{}
 	cmd.Stderr = stderr
 	cmd.Dir = dir
 	cmd.Env = env
 	out, err := cmd.Output()
+	if err != nil && ctx.Err() != nil {
+		return out, fmt.Errorf("running '%s %s': %w", cmd.Path, strings.Join(cmd.Args, " "), ctx.Err())
+	}
 	if err != nil {
 		var errStr string
 		var xerr *exec.ExitError
@@ -24,7 +24,7 @@ import (
 
 func importReposFromWork(args language.ImportReposArgs) language.ImportReposResult {
 	// run go list in the dir where go.work is located
-	data, err := goListModules(filepath.Dir(args.Path))
+	data, err := goListModules(args.Context, filepath.Dir(args.Path))
 	if err != nil {
 		return language.ImportReposResult{Error: processGoListError(nil, data)}
 	}
@@ -34,7 +34,7 @@ func importReposFromWork(args language.ImportReposArgs) language.ImportReposResu
 		return language.ImportReposResult{Error: err}
 	}
 
-	pathToModule, err = fillMissingSums(pathToModule)
+	pathToModule, err = fillMissingSums(args.Context, pathToModule)
 	if err != nil {
 		return language.ImportReposResult{Error: fmt.Errorf("finding module sums: %v", err)}
 	}
@@ -22,6 +22,8 @@ limitations under the License.
 package language
 
 import (
+	"context"
+
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
@@ -110,6 +112,26 @@ type FinishableLanguage interface {
 	DoneGeneratingRules()
 }
 
+// ContextGenerator is an optional interface for languages that may do slow
+// work when generating rules, for example, running commands or accessing
+// the network. If a Language implements ContextGenerator,
+// GenerateRulesContext is called instead of GenerateRules. The context is
+// canceled when Gazelle is interrupted or its -timeout expires;
+// implementations should stop work and return promptly. Gazelle reports an
+// error after the context is canceled, so results returned after that point
+// are not written.
+type ContextGenerator interface {
+	GenerateRulesContext(ctx context.Context, args GenerateArgs) GenerateResult
+}
+
+// ContextFixer is an optional interface for languages whose Fix method may do
+// slow work. If a Language implements ContextFixer, FixContext is called
+// instead of Fix. The context is canceled when Gazelle is interrupted or its
+// -timeout expires; implementations should stop work and return promptly.
+type ContextFixer interface {
+	FixContext(ctx context.Context, c *config.Config, f *rule.File)
+}
+
 type ModuleAwareLanguage interface {
 	// ApparentLoads returns .bzl files and symbols they define. Every rule
 	// generated by GenerateRules, now or in the past, should be loadable from
@@ -189,4 +211,49 @@ type GenerateResult struct {
 	// correspond. These values are passed to Resolve after merge. The type
 	// is opaque since different languages may use different representations.
 	Imports []interface{}
+
+	// OutputFiles is a list of other files generated for the directory, like
+	// a .bzl file with constants, a build file in another package, or a
+	// configuration file for a tool. They're emitted after the directory's
+	// build file, according to -mode, like build files are. Languages should
+	// return output files instead of writing them.
+	OutputFiles []OutputFile
+
+	// MappedKinds lists kinds of rules in Gen and Empty that aren't returned
+	// by Kinds, like kinds configured with directives. Each kind (KindName)
+	// is merged and resolved like the kind it's mapped from (FromKind), which
+	// must be returned by Kinds, and it's loaded from KindLoad. Rules of
+	// these kinds in the existing build file are treated the same way.
+	MappedKinds []config.MappedKind
+
+	// Replaced maps names of existing rules that are replaced by rules in Gen
+	// with different names, for example because a rule was split, to the
+	// names of their replacements. The replaced rules should be listed in
+	// Empty. Gazelle rewrites references to them in build and .bzl files
+	// across the repository: a reference in a list is replaced with all the
+	// replacements, and other references are reported if there's more than
+	// one.
+	Replaced map[string][]string
+}
+
+// OutputFile is a file other than a directory's build file that a language
+// generates. Either Path and Content or File should be set. Files outside
+// the repository are rejected. In fix mode, a file that was already written
+// in the same run, like the build file of an updated directory, is not
+// written again; an error is logged instead.
+type OutputFile struct {
+	// Path is the slash-separated path to the file, relative to the
+	// repository root.
+	Path string
+
+	// Content is the content of the file. It's written as is, replacing any
+	// existing content.
+	Content []byte
+
+	// File is a build or .bzl file, which is formatted and written to
+	// File.Path, an absolute path in the repository. Gazelle doesn't merge
+	// it with the existing file or resolve dependencies of its rules; the
+	// language should load the existing file with rule.LoadFile and modify
+	// it if needed.
+	File *rule.File
 }
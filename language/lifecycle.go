@@ -21,6 +21,10 @@ import "context"
 // free up resources at different points. Extensions should
 // embed BaseLifecycleManager instead of implementing this
 // interface directly.
+//
+// Before is called at the start of each run. Gazelle may run more than once
+// in the same process (for example, -mode=idempotency runs twice), so
+// extensions should reset any state collected during a previous run there.
 type LifecycleManager interface {
 	FinishableLanguage
 	Before(ctx context.Context)
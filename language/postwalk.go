@@ -0,0 +1,119 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package language
+
+import (
+	"context"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// PostWalkGenerator is an optional interface for languages that generate
+// rules with knowledge of the whole repository, for example a test suite for
+// each top-level directory, or a package_group listing every consumer of a
+// library.
+type PostWalkGenerator interface {
+	// GenerateRulesAfterWalk is called after GenerateRules has been called in
+	// every directory being updated, and before generated rules are merged
+	// into build files, indexed, and resolved. It may add, modify, or remove
+	// rules in any of the packages in args. The results are merged, indexed,
+	// resolved, and emitted like rules returned by GenerateRules.
+	//
+	// GenerateRulesAfterWalk is called for each language implementing this
+	// interface, in the order languages were registered. Later languages see
+	// changes made by earlier ones.
+	GenerateRulesAfterWalk(args PostWalkArgs)
+}
+
+// ConditionalPostWalkGenerator is an optional interface for PostWalkGenerator
+// implementations that only generate rules after the walk for some
+// configurations. Until NeedsPostWalk returns true, packages are merged and
+// emitted as they're visited, and they aren't passed to
+// GenerateRulesAfterWalk.
+type ConditionalPostWalkGenerator interface {
+	PostWalkGenerator
+
+	// NeedsPostWalk is called after rules are generated in each directory. It
+	// returns true if GenerateRulesAfterWalk may change rules in that
+	// directory or in directories visited later. Once it returns true, it's
+	// not called again.
+	NeedsPostWalk() bool
+}
+
+// ContextPostWalkGenerator is an optional interface for PostWalkGenerator
+// implementations that may do slow work. If a PostWalkGenerator implements
+// ContextPostWalkGenerator, GenerateRulesAfterWalkContext is called instead
+// of GenerateRulesAfterWalk. The context is canceled when Gazelle is
+// interrupted or its -timeout expires; implementations should stop work and
+// return promptly.
+type ContextPostWalkGenerator interface {
+	PostWalkGenerator
+
+	GenerateRulesAfterWalkContext(ctx context.Context, args PostWalkArgs)
+}
+
+// PostWalkArgs contains arguments for GenerateRulesAfterWalk.
+type PostWalkArgs struct {
+	// Config is the configuration for the repository root directory.
+	Config *config.Config
+
+	// Packages lists the directories being updated, in the order they were
+	// visited (depth-first post-order). It includes directories where no
+	// rules were generated and that have no build file, so rules may be
+	// added to them. Directories merged before a ConditionalPostWalkGenerator
+	// needed the post-walk phase are not included.
+	Packages []*GeneratedPackage
+}
+
+// GeneratedPackage is a directory being updated, with the rules generated for
+// it. Fields may be modified by GenerateRulesAfterWalk.
+type GeneratedPackage struct {
+	// Config is the configuration for the directory.
+	Config *config.Config
+
+	// Dir is the absolute path to the directory.
+	Dir string
+
+	// Rel is the slash-separated path to the directory, relative to the
+	// repository root. It's "" for the root directory.
+	Rel string
+
+	// File is the directory's existing build file, after Fix has been called,
+	// or nil if there isn't one. Generated rules haven't been merged into it
+	// yet. Existing rules may be modified directly. If File is nil and Gen is
+	// not empty, a new build file is created.
+	File *rule.File
+
+	// Gen, Empty, and Imports are the rules generated for the directory by
+	// all languages, as in GenerateResult. Gen and Imports must have the same
+	// length.
+	Gen     []*rule.Rule
+	Empty   []*rule.Rule
+	Imports []interface{}
+
+	// OutputFiles are other files generated for the directory, as in
+	// GenerateResult.
+	OutputFiles []OutputFile
+
+	// MappedKinds are kinds of generated rules that aren't returned by
+	// Kinds, as in GenerateResult.
+	MappedKinds []config.MappedKind
+
+	// Replaced maps names of existing rules to the names of rules in Gen that
+	// replace them, as in GenerateResult.
+	Replaced map[string][]string
+}
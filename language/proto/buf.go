@@ -0,0 +1,542 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+)
+
+const (
+	bufWorkFileName = "buf.work.yaml"
+	bufFileName     = "buf.yaml"
+	bufLockFileName = "buf.lock"
+)
+
+// bufDepRepo is an external repository that provides the .proto files of a
+// buf module dependency. It's configured with the proto_buf_dep directive.
+type bufDepRepo struct {
+	// repo is the name of the repository.
+	repo string
+
+	// prefixes are import path prefixes provided by the module. At least one
+	// prefix is required, since Gazelle can't tell which imports a module
+	// provides otherwise.
+	prefixes []string
+}
+
+// bufFiles contains the information Gazelle needs from the buf configuration
+// files in one directory.
+type bufFiles struct {
+	// roots are directories containing buf modules, relative to the
+	// repository root. Imports of .proto files in a module are relative to
+	// its root.
+	roots []string
+
+	// excludes are paths excluded from modules, relative to the repository
+	// root.
+	excludes []string
+
+	// deps are names of modules the modules in this directory depend on,
+	// for example, "buf.build/googleapis/googleapis".
+	deps []string
+}
+
+// readBufFiles reads buf.work.yaml, buf.yaml, and buf.lock in the directory
+// rel. Missing files are ignored.
+func readBufFiles(repoRoot, rel string) (bufFiles, error) {
+	var bf bufFiles
+	dir := filepath.Join(repoRoot, filepath.FromSlash(rel))
+
+	if v, err := readYAMLFile(filepath.Join(dir, bufWorkFileName)); err != nil {
+		return bufFiles{}, err
+	} else if v != nil {
+		for _, d := range yamlStrings(yamlMap(v)["directories"]) {
+			bf.roots = append(bf.roots, joinRel(rel, d))
+		}
+	}
+
+	if v, err := readYAMLFile(filepath.Join(dir, bufFileName)); err != nil {
+		return bufFiles{}, err
+	} else if v != nil {
+		m := yamlMap(v)
+		if yamlString(m["version"]) == "v2" {
+			modules := yamlList(m["modules"])
+			if len(modules) == 0 {
+				bf.roots = append(bf.roots, rel)
+			}
+			for _, mod := range modules {
+				mm := yamlMap(mod)
+				bf.roots = append(bf.roots, joinRel(rel, yamlString(mm["path"])))
+				for _, ex := range yamlStrings(mm["excludes"]) {
+					bf.excludes = append(bf.excludes, joinRel(rel, ex))
+				}
+			}
+		} else {
+			bf.roots = append(bf.roots, rel)
+			for _, ex := range yamlStrings(yamlMap(m["build"])["excludes"]) {
+				bf.excludes = append(bf.excludes, joinRel(rel, ex))
+			}
+		}
+		for _, dep := range yamlStrings(m["deps"]) {
+			// Strip the reference, for example, ":v1.0.0".
+			if i := strings.LastIndexByte(dep, ':'); i > strings.LastIndexByte(dep, '/') {
+				dep = dep[:i]
+			}
+			bf.deps = append(bf.deps, dep)
+		}
+	}
+
+	if v, err := readYAMLFile(filepath.Join(dir, bufLockFileName)); err != nil {
+		return bufFiles{}, err
+	} else if v != nil {
+		for _, dep := range yamlList(yamlMap(v)["deps"]) {
+			dm := yamlMap(dep)
+			name := yamlString(dm["name"])
+			if name == "" {
+				name = path.Join(yamlString(dm["remote"]), yamlString(dm["owner"]), yamlString(dm["repository"]))
+			}
+			if name != "" && !containsString(bf.deps, name) {
+				bf.deps = append(bf.deps, name)
+			}
+		}
+	}
+
+	return bf, nil
+}
+
+// configureBuf reads buf configuration files in the directory rel. Module
+// roots and dependencies are recorded in pc, and excluded paths are added
+// to c.Excludes. If rel is in a module and the strip prefix was
+// not set with a directive, StripImportPrefix is set to the module root.
+func configureBuf(c *config.Config, pc *ProtoConfig, rel string) {
+	bf, err := readBufFiles(c.RepoRoot, rel)
+	if err != nil {
+		log.Print(err)
+	}
+	if len(bf.roots) > 0 {
+		pc.bufRoots = append(pc.bufRoots[:len(pc.bufRoots):len(pc.bufRoots)], bf.roots...)
+	}
+	if len(bf.deps) > 0 {
+		pc.bufDeps = bf.deps
+	}
+	if len(bf.excludes) > 0 {
+		c.Excludes = append(c.Excludes[:len(c.Excludes):len(c.Excludes)], bf.excludes...)
+	}
+
+	if pc.stripImportPrefixExplicit {
+		return
+	}
+	root := ""
+	found := false
+	for _, r := range pc.bufRoots {
+		if pathtools.HasPrefix(rel, r) && (!found || len(r) > len(root)) {
+			root = r
+			found = true
+		}
+	}
+	if found {
+		if root == "" {
+			pc.StripImportPrefix = ""
+		} else {
+			pc.StripImportPrefix = "/" + root
+		}
+	}
+}
+
+// parseBufDep parses the value of a proto_buf_dep directive: a buf module
+// name, a repository name, and one or more import prefixes.
+func parseBufDep(value string) (string, bufDepRepo, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return "", bufDepRepo{}, errors.New("expected a module name, a repository name, and import prefixes")
+	}
+	dr := bufDepRepo{repo: strings.TrimPrefix(fields[1], "@")}
+	if dr.repo == "" {
+		return "", bufDepRepo{}, errors.New("empty repository name")
+	}
+	for _, prefix := range fields[2:] {
+		if prefix = strings.Trim(prefix, "/"); prefix == "" {
+			return "", bufDepRepo{}, errors.New("empty import prefix")
+		}
+		dr.prefixes = append(dr.prefixes, prefix)
+	}
+	return fields[0], dr, nil
+}
+
+// resolveBufDep returns a label for an import provided by one of the buf
+// module dependencies in effect, according to the import prefixes set with
+// proto_buf_dep. Rules in external repositories are assumed to follow
+// Gazelle's naming conventions.
+func resolveBufDep(pc *ProtoConfig, imp string) (label.Label, bool) {
+	for _, dep := range pc.bufDeps {
+		dr, ok := pc.bufDepRepos[dep]
+		if !ok || !dr.provides(imp) {
+			continue
+		}
+		rel := path.Dir(imp)
+		if rel == "." {
+			rel = ""
+		}
+		return label.New(dr.repo, rel, RuleName(rel)), true
+	}
+	return label.NoLabel, false
+}
+
+// provides returns whether imp is under one of the import prefixes of the
+// module.
+func (dr bufDepRepo) provides(imp string) bool {
+	for _, prefix := range dr.prefixes {
+		if pathtools.HasPrefix(imp, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinRel joins slash-separated paths relative to the repository root. The
+// root itself is "".
+func joinRel(rel, p string) string {
+	if j := path.Join(rel, p); j != "." {
+		return j
+	}
+	return ""
+}
+
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readYAMLFile parses a YAML file. nil is returned without an error if the
+// file does not exist.
+func readYAMLFile(filename string) (interface{}, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", filename, err)
+	}
+	if v == nil {
+		// Distinguish empty files from missing files.
+		v = map[string]interface{}{}
+	}
+	return v, nil
+}
+
+// yamlLine is a non-empty line of a YAML document without its comment.
+type yamlLine struct {
+	num, indent int
+	text        string
+}
+
+// yamlParser parses the subset of YAML used in buf configuration files.
+// It accepts:
+//
+//   - block mappings with plain or quoted keys,
+//   - block sequences, including sequences of mappings like "- key: value",
+//   - flow sequences of scalars on one line, like "[a, 'b']",
+//   - plain, single-quoted, and double-quoted scalars on one line,
+//   - comments, and a "---" marker before the document.
+//
+// Indentation must use spaces. Scalars are returned as strings without
+// interpreting booleans, numbers or null, mappings as
+// map[string]interface{}, and sequences as []interface{}. Other syntax,
+// including flow mappings, block scalars, multi-line scalars, anchors,
+// aliases, tags, directives, and multiple documents, is reported as an error.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" {
+			continue
+		}
+		if trimmed == "---" || trimmed == "..." || strings.HasPrefix(trimmed, "%") {
+			if trimmed == "---" && len(p.lines) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("%d: directives and multiple documents are not supported", i+1)
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("%d: tabs may not be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("%d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isYAMLListItem(p.lines[p.pos].text) {
+		return p.parseList(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseList(indent int) ([]interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !isYAMLListItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			if p.pos == len(p.lines) || p.lines[p.pos].indent <= indent {
+				list = append(list, nil)
+				continue
+			}
+			v, err := p.parseNode(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		if _, _, ok := splitYAMLMapEntry(rest); ok || isYAMLListItem(rest) {
+			// A nested node starts on the same line as the "-". Treat the rest
+			// of the line as if it were on its own line, indented to its column.
+			itemIndent := indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{num: l.num, indent: itemIndent, text: rest}
+			v, err := p.parseNode(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		v, err := parseYAMLScalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", l.num, err)
+		}
+		list = append(list, v)
+		p.pos++
+	}
+	return list, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && isYAMLListItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("%d: unexpected indentation", l.num)
+		}
+		key, value, ok := splitYAMLMapEntry(l.text)
+		if !ok {
+			return nil, fmt.Errorf("%d: expected key: value", l.num)
+		}
+		p.pos++
+		if value != "" {
+			v, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", l.num, err)
+			}
+			m[key] = v
+			continue
+		}
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && isYAMLListItem(next.text)) {
+				v, err := p.parseNode(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+				continue
+			}
+		}
+		m[key] = nil
+	}
+	return m, nil
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLMapEntry splits a line like "key: value" into its key and value.
+// ok is false if the line is not a mapping entry.
+func splitYAMLMapEntry(text string) (key, value string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			if i == 0 {
+				quote = b
+			}
+		case b == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key, err := parseYAMLScalar(strings.TrimSpace(text[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return key.(string), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func parseYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, errors.New("unterminated flow sequence")
+		}
+		list := []interface{}{}
+		for _, elem := range splitYAMLFlow(text[1 : len(text)-1]) {
+			if elem = strings.TrimSpace(elem); elem == "" {
+				continue
+			}
+			v, err := parseYAMLScalar(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case strings.HasPrefix(text, "{"):
+		return nil, errors.New("flow mappings are not supported")
+	case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return nil, errors.New("block scalars are not supported")
+	case strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*"):
+		return nil, errors.New("anchors and aliases are not supported")
+	case strings.HasPrefix(text, "!"):
+		return nil, errors.New("tags are not supported")
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	default:
+		return text, nil
+	}
+}
+
+// splitYAMLFlow splits the contents of a flow sequence on commas that are
+// not quoted.
+func splitYAMLFlow(text string) []string {
+	var elems []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == ',':
+			elems = append(elems, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(elems, text[start:])
+}
+
+// stripYAMLComment removes a comment that starts with " #" or at the
+// beginning of a line, outside of quoted strings.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case quote != 0:
+			if b == '\\' && quote == '"' {
+				i++
+			} else if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func yamlMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func yamlList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+func yamlString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func yamlStrings(v interface{}) []string {
+	var ss []string
+	for _, e := range yamlList(v) {
+		if s := yamlString(e); s != "" {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
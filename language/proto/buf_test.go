@@ -0,0 +1,219 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseYAML(t *testing.T) {
+	for _, tc := range []struct {
+		desc, data string
+		want       interface{}
+		wantErr    bool
+	}{
+		{
+			desc: "empty",
+			data: "# comment\n",
+		}, {
+			desc: "work",
+			data: `version: v1
+directories:
+  - proto
+  - "vendor/protos" # comment
+`,
+			want: map[string]interface{}{
+				"version":     "v1",
+				"directories": []interface{}{"proto", "vendor/protos"},
+			},
+		}, {
+			desc: "nested",
+			data: `version: v2
+modules:
+- path: proto
+  excludes: [proto/a, 'proto/b']
+- path: other
+deps:
+  - buf.build/googleapis/googleapis
+lint:
+  use:
+    - DEFAULT
+`,
+			want: map[string]interface{}{
+				"version": "v2",
+				"modules": []interface{}{
+					map[string]interface{}{
+						"path":     "proto",
+						"excludes": []interface{}{"proto/a", "proto/b"},
+					},
+					map[string]interface{}{"path": "other"},
+				},
+				"deps": []interface{}{"buf.build/googleapis/googleapis"},
+				"lint": map[string]interface{}{
+					"use": []interface{}{"DEFAULT"},
+				},
+			},
+		}, {
+			desc: "document_start",
+			data: "---\nversion: v1\n",
+			want: map[string]interface{}{"version": "v1"},
+		}, {
+			desc:    "bad_indent",
+			data:    "a: b\n  c: d\n",
+			wantErr: true,
+		}, {
+			desc:    "flow_map",
+			data:    "a: {b: c}\n",
+			wantErr: true,
+		}, {
+			desc:    "block_scalar",
+			data:    "a: |\n  b\n",
+			wantErr: true,
+		}, {
+			desc:    "alias",
+			data:    "a: &x b\nc: *x\n",
+			wantErr: true,
+		}, {
+			desc:    "multiple_documents",
+			data:    "a: b\n---\nc: d\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseYAML([]byte(tc.data))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %v; want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("(-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestReadBufFiles(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "buf.work.yaml",
+			Content: "version: v1\ndirectories:\n  - proto\n",
+		}, {
+			Path: "proto/buf.yaml",
+			Content: `version: v1
+deps:
+  - buf.build/acme/paymentapis:v1.2.0
+build:
+  excludes:
+    - legacy
+`,
+		}, {
+			Path: "proto/buf.lock",
+			Content: `version: v1
+deps:
+  - remote: buf.build
+    owner: googleapis
+    repository: googleapis
+    commit: 62f35d8aed1149c291d606d958a7ce32
+`,
+		}, {
+			Path: "v2/buf.yaml",
+			Content: `version: v2
+modules:
+  - path: a
+    excludes:
+      - a/old
+  - path: b
+`,
+		}, {
+			Path: "v2/buf.lock",
+			Content: `version: v2
+deps:
+  - name: buf.build/googleapis/googleapis
+    commit: 62f35d8aed1149c291d606d958a7ce32
+`,
+		},
+	})
+	defer cleanup()
+
+	for _, tc := range []struct {
+		rel  string
+		want bufFiles
+	}{
+		{
+			rel:  "",
+			want: bufFiles{roots: []string{"proto"}},
+		}, {
+			rel: "proto",
+			want: bufFiles{
+				roots:    []string{"proto"},
+				excludes: []string{"proto/legacy"},
+				deps:     []string{"buf.build/acme/paymentapis", "buf.build/googleapis/googleapis"},
+			},
+		}, {
+			rel: "v2",
+			want: bufFiles{
+				roots:    []string{"v2/a", "v2/b"},
+				excludes: []string{"v2/a/old"},
+				deps:     []string{"buf.build/googleapis/googleapis"},
+			},
+		},
+	} {
+		t.Run(tc.rel, func(t *testing.T) {
+			got, err := readBufFiles(dir, tc.rel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(bufFiles{})); diff != "" {
+				t.Errorf("(-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestResolveBufDep(t *testing.T) {
+	pc := &ProtoConfig{
+		bufDeps: []string{"buf.build/acme/apis", "buf.build/googleapis/googleapis", "buf.build/other/other"},
+		bufDepRepos: map[string]bufDepRepo{
+			"buf.build/googleapis/googleapis": {repo: "googleapis", prefixes: []string{"google/api", "google/type"}},
+			"buf.build/other/other":           {repo: "other", prefixes: []string{"acme"}},
+		},
+	}
+	for _, tc := range []struct {
+		imp    string
+		want   label.Label
+		wantOk bool
+	}{
+		{imp: "google/api/annotations.proto", want: label.New("googleapis", "google/api", "api_proto"), wantOk: true},
+		{imp: "google/type/date.proto", want: label.New("googleapis", "google/type", "type_proto"), wantOk: true},
+		{imp: "acme/x.proto", want: label.New("other", "acme", "acme_proto"), wantOk: true},
+		{imp: "unknown/x.proto", want: label.NoLabel},
+	} {
+		got, ok := resolveBufDep(pc, tc.imp)
+		if ok != tc.wantOk || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, %v; want %v, %v", tc.imp, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
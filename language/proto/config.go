@@ -20,9 +20,13 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
@@ -58,6 +62,43 @@ type ProtoConfig struct {
 	// If set, Gazelle will apply this value to the import_prefix attribute
 	// within the proto_library_rule.
 	ImportPrefix string
+
+	// stripImportPrefixExplicit indicates whether StripImportPrefix was set
+	// with a directive. If not, it may be set from buf configuration files.
+	stripImportPrefixExplicit bool
+
+	// useBuf indicates whether buf configuration files should be read. It's
+	// set with the proto_buf directive.
+	useBuf bool
+
+	// bufRoots lists directories containing buf modules that were found in
+	// buf.work.yaml and buf.yaml files in this directory and its parents.
+	bufRoots []string
+
+	// bufDeps lists modules the buf module containing this directory
+	// depends on, from buf.yaml and buf.lock.
+	bufDeps []string
+
+	// bufDepRepos maps buf module names to external repositories, configured
+	// with the proto_buf_dep directive.
+	bufDepRepos map[string]bufDepRepo
+
+	// knownImportFiles lists CSV files of known imports set with the
+	// -proto_known_imports flag.
+	knownImportFiles []string
+
+	// knownImports contains known imports loaded from files listed with the
+	// -proto_known_imports flag and proto_known_imports directives. nil if
+	// no files were loaded.
+	knownImports *knownImportTable
+
+	// externalImports maps proto imports to proto_library rules in external
+	// repositories listed with proto_external_repo directives.
+	externalImports map[string]label.Label
+
+	// plugins lists rules to generate next to each proto_library, configured
+	// with the proto_plugin directive.
+	plugins []*protoPlugin
 }
 
 // GetProtoConfig returns the proto language configuration. If the proto
@@ -100,6 +141,12 @@ const (
 
 	// FileMode generates a proto_library for each .proto file.
 	FileMode
+
+	// SCCMode generates a proto_library for each strongly connected component
+	// of the import graph of .proto files in each directory. Files that import
+	// each other, directly or indirectly, share a rule; other files get their
+	// own rule, as in FileMode.
+	SCCMode
 )
 
 func ModeFromString(s string) (Mode, error) {
@@ -116,6 +163,8 @@ func ModeFromString(s string) (Mode, error) {
 		return PackageMode, nil
 	case "file":
 		return FileMode, nil
+	case "scc":
+		return SCCMode, nil
 	default:
 		return 0, fmt.Errorf("unrecognized proto mode: %q", s)
 	}
@@ -135,6 +184,8 @@ func (m Mode) String() string {
 		return "package"
 	case FileMode:
 		return "file"
+	case SCCMode:
+		return "scc"
 	default:
 		log.Panicf("unknown mode %d", m)
 		return ""
@@ -191,20 +242,32 @@ func (*protoLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config)
 	// Note: the -proto flag does not set the ModeExplicit flag. We want to
 	// be able to switch to DisableMode in vendor directories, even when
 	// this is set for compatibility with older versions.
-	fs.Var(&modeFlag{&pc.Mode}, "proto", "default: generates a proto_library rule for one package\n\tpackage: generates a proto_library rule for for each package\n\tdisable: does not touch proto rules\n\tdisable_global: does not touch proto rules and does not use special cases for protos in dependency resolution")
+	fs.Var(&modeFlag{&pc.Mode}, "proto", "default: generates a proto_library rule for one package\n\tpackage: generates a proto_library rule for for each package\n\tfile: generates a proto_library rule for each file\n\tscc: generates a proto_library rule for each set of files that import each other\n\tdisable: does not touch proto rules\n\tdisable_global: does not touch proto rules and does not use special cases for protos in dependency resolution")
 	fs.StringVar(&pc.groupOption, "proto_group", "", "option name used to group .proto files into proto_library rules")
 	fs.StringVar(&pc.ImportPrefix, "proto_import_prefix", "", "When set, .proto source files in the srcs attribute of the rule are accessible at their path with this prefix appended on.")
+	fs.Var(&gzflag.MultiFlag{Values: &pc.knownImportFiles}, "proto_known_imports", "CSV file in the same format as proto.csv listing known proto imports, which take precedence over built-in known imports (may be repeated)")
 }
 
 func (*protoLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	pc := GetProtoConfig(c)
+	for _, path := range pc.knownImportFiles {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.WorkDir, path)
+		}
+		t, err := readKnownImports(path)
+		if err != nil {
+			return fmt.Errorf("-proto_known_imports: %v", err)
+		}
+		pc.knownImports = pc.knownImports.merge(t)
+	}
 	return nil
 }
 
 func (*protoLang) KnownDirectives() []string {
-	return []string{"proto", "proto_group", "proto_strip_import_prefix", "proto_import_prefix"}
+	return []string{"proto", "proto_group", "proto_strip_import_prefix", "proto_import_prefix", "proto_plugin", "proto_buf", "proto_buf_dep", "proto_known_imports", "proto_external_repo"}
 }
 
-func (*protoLang) Configure(c *config.Config, rel string, f *rule.File) {
+func (pl *protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 	pc := &ProtoConfig{}
 	*pc = *GetProtoConfig(c)
 	c.Exts[protoName] = pc
@@ -223,14 +286,82 @@ func (*protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 				pc.groupOption = d.Value
 			case "proto_strip_import_prefix":
 				pc.StripImportPrefix = d.Value
+				pc.stripImportPrefixExplicit = true
 				if err := checkStripImportPrefix(pc.StripImportPrefix, rel); err != nil {
 					log.Print(err)
 				}
 			case "proto_import_prefix":
 				pc.ImportPrefix = d.Value
+			case "proto_plugin":
+				if d.Value == "" {
+					pc.plugins = nil
+					continue
+				}
+				p, err := parseProtoPlugin(d.Value)
+				if err != nil {
+					log.Printf("%s: invalid proto_plugin directive %q: %v", f.Path, d.Value, err)
+					continue
+				}
+				// Copy the slice so plugins in parent directories aren't modified.
+				plugins := make([]*protoPlugin, 0, len(pc.plugins)+1)
+				for _, old := range pc.plugins {
+					if old.kind != p.kind {
+						plugins = append(plugins, old)
+					}
+				}
+				pc.plugins = append(plugins, p)
+			case "proto_known_imports":
+				path := filepath.Join(c.RepoRoot, filepath.FromSlash(strings.TrimPrefix(d.Value, "/")))
+				t, err := readKnownImports(path)
+				if err != nil {
+					log.Printf("%s: invalid proto_known_imports directive %q: %v", f.Path, d.Value, err)
+					continue
+				}
+				pc.knownImports = pc.knownImports.merge(t)
+			case "proto_external_repo":
+				spec, err := parseExternalRepo(c.RepoRoot, d.Value)
+				if err != nil {
+					log.Printf("%s: invalid proto_external_repo directive %q: %v", f.Path, d.Value, err)
+					continue
+				}
+				imports, err := pl.externalImports(c, spec)
+				if err != nil {
+					log.Printf("%s: could not index external repository %s: %v", f.Path, spec.name, err)
+					continue
+				}
+				externalImports := make(map[string]label.Label, len(pc.externalImports)+len(imports))
+				for k, v := range pc.externalImports {
+					externalImports[k] = v
+				}
+				for k, v := range imports {
+					externalImports[k] = v
+				}
+				pc.externalImports = externalImports
+			case "proto_buf":
+				enabled, err := strconv.ParseBool(d.Value)
+				if err != nil {
+					log.Printf("%s: invalid proto_buf directive %q: %v", f.Path, d.Value, err)
+					continue
+				}
+				pc.useBuf = enabled
+			case "proto_buf_dep":
+				module, dr, err := parseBufDep(d.Value)
+				if err != nil {
+					log.Printf("%s: invalid proto_buf_dep directive %q: %v", f.Path, d.Value, err)
+					continue
+				}
+				bufDepRepos := make(map[string]bufDepRepo, len(pc.bufDepRepos)+1)
+				for k, v := range pc.bufDepRepos {
+					bufDepRepos[k] = v
+				}
+				bufDepRepos[module] = dr
+				pc.bufDepRepos = bufDepRepos
 			}
 		}
 	}
+	if pc.useBuf {
+		configureBuf(c, pc, rel)
+	}
 	inferProtoMode(c, rel, f)
 }
 
@@ -15,7 +15,11 @@ limitations under the License.
 
 package proto
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestCheckStripImportPrefix(t *testing.T) {
 	testCases := []struct {
@@ -47,3 +51,87 @@ func TestCheckStripImportPrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestParseProtoPlugin(t *testing.T) {
+	for _, tc := range []struct {
+		desc, value, wantErr string
+		want                 *protoPlugin
+	}{
+		{
+			desc:  "defaults",
+			value: "cc_proto_library load=@rules_cc//cc:defs.bzl",
+			want: &protoPlugin{
+				kind:        "cc_proto_library",
+				load:        "@rules_cc//cc:defs.bzl",
+				namePattern: "{name}_cc_proto",
+				attr:        "deps",
+			},
+		}, {
+			desc:  "settings",
+			value: "java_proto_library name={name}_java load=//:java.bzl set.tags=manual",
+			want: &protoPlugin{
+				kind:        "java_proto_library",
+				load:        "//:java.bzl",
+				namePattern: "{name}_java",
+				attr:        "deps",
+				attrs:       []pluginAttr{{name: "tags", value: "manual"}},
+			},
+		}, {
+			desc:  "grpc",
+			value: "cc_proto_library load=@rules_cc//cc:defs.bzl grpc=cc_grpc_library grpc_load=@grpc//bazel:cc_grpc_library.bzl grpc_set.grpc_only=True",
+			want: &protoPlugin{
+				kind:        "cc_proto_library",
+				load:        "@rules_cc//cc:defs.bzl",
+				namePattern: "{name}_cc_proto",
+				attr:        "deps",
+				grpc: &protoPlugin{
+					kind:        "cc_grpc_library",
+					load:        "@grpc//bazel:cc_grpc_library.bzl",
+					namePattern: "{name}_cc_grpc",
+					attr:        "deps",
+					attrs:       []pluginAttr{{name: "grpc_only", value: true}},
+				},
+			},
+		}, {
+			desc:    "empty",
+			value:   " ",
+			wantErr: "expected kind",
+		}, {
+			desc:    "missing_load",
+			value:   "cc_proto_library",
+			wantErr: "load is required",
+		}, {
+			desc:    "invalid_load",
+			value:   "cc_proto_library load=@@@",
+			wantErr: "load:",
+		}, {
+			desc:    "proto_kind",
+			value:   "proto_library load=//:defs.bzl",
+			wantErr: "can't be generated by a plugin",
+		}, {
+			desc:    "grpc_name_without_grpc",
+			value:   "cc_proto_library load=//:defs.bzl grpc_name={name}_grpc",
+			wantErr: "grpc_name requires grpc",
+		}, {
+			desc:    "import_deps_with_deps",
+			value:   "py_proto_library load=//:defs.bzl import_deps=true",
+			wantErr: "import_deps requires attr",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseProtoPlugin(tc.value)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
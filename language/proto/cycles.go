@@ -0,0 +1,274 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// importEdge is a dependency of one proto_library on another, caused by an
+// import statement in one of its sources.
+type importEdge struct {
+	to label.Label
+
+	// imp is the imported path, and file and line are the location of the
+	// import statement, relative to the repository root.
+	imp, file string
+	line      int
+}
+
+// importGraph records dependencies between proto_library rules in the main
+// repository that were resolved during this run, so cycles can be reported
+// after resolution.
+type importGraph struct {
+	edges map[label.Label][]importEdge
+
+	// strict is true if any resolved rule was configured in strict mode.
+	strict bool
+}
+
+// addEdges records the dependencies of the proto_library from on the
+// rules its imports resolved to. Dependencies on other repositories are
+// ignored, since they can't be part of a cycle.
+func (g *importGraph) addEdges(from label.Label, pkg *Package, deps map[string]label.Label, strict bool) {
+	if g.edges == nil {
+		g.edges = make(map[label.Label][]importEdge)
+	}
+	g.strict = g.strict || strict
+	fromKey := label.New("", from.Pkg, from.Name)
+	if _, ok := g.edges[fromKey]; !ok {
+		g.edges[fromKey] = nil
+	}
+
+	imps := make([]string, 0, len(deps))
+	for imp := range deps {
+		imps = append(imps, imp)
+	}
+	sort.Strings(imps)
+	for _, imp := range imps {
+		l := deps[imp]
+		if l.Repo != "" && l.Repo != from.Repo {
+			continue
+		}
+		e := importEdge{to: label.New("", l.Pkg, l.Name), imp: imp}
+		if pkg != nil {
+			e.file, e.line = pkg.importLocation(from.Pkg, imp)
+		}
+		g.edges[fromKey] = append(g.edges[fromKey], e)
+	}
+}
+
+// importLocation returns the repository-relative path of the first file in
+// the package that imports imp, and the line of its import statement.
+func (p *Package) importLocation(rel, imp string) (string, int) {
+	names := make([]string, 0, len(p.Files))
+	for name := range p.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fi := p.Files[name]
+		for _, i := range fi.Imports {
+			if i == imp {
+				return path.Join(rel, name), fi.ImportLines[imp]
+			}
+		}
+	}
+	return "", 0
+}
+
+// cycles returns import cycles in the graph. One cycle is returned for each
+// strongly connected component with more than one rule. Each cycle starts
+// with the least label in its component.
+func (g *importGraph) cycles() [][]importEdge {
+	labels := make(map[string]label.Label, len(g.edges))
+	nodes := make([]string, 0, len(g.edges))
+	for l := range g.edges {
+		labels[l.String()] = l
+		nodes = append(nodes, l.String())
+	}
+	sort.Strings(nodes)
+	succ := func(n string) []string {
+		var succ []string
+		for _, e := range g.edges[labels[n]] {
+			if _, ok := g.edges[e.to]; ok {
+				succ = append(succ, e.to.String())
+			}
+		}
+		return succ
+	}
+
+	var cycles [][]importEdge
+	for _, scc := range stronglyConnectedComponents(nodes, succ) {
+		if len(scc) < 2 {
+			continue
+		}
+		inSCC := make(map[label.Label]bool)
+		for _, n := range scc {
+			inSCC[labels[n]] = true
+		}
+		cycles = append(cycles, g.shortestCycle(labels[scc[0]], inSCC))
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][len(cycles[i])-1].to.String() < cycles[j][len(cycles[j])-1].to.String()
+	})
+	return cycles
+}
+
+// stronglyConnectedComponents partitions nodes into strongly connected
+// components using Tarjan's algorithm. succ returns the successors of a
+// node; successors not in nodes are ignored. Each component is sorted, and
+// components are returned in reverse topological order: a component comes
+// after every component it has an edge to.
+func stronglyConnectedComponents(nodes []string, succ func(string) []string) [][]string {
+	isNode := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		isNode[n] = true
+	}
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var sccs [][]string
+	var visit func(n string)
+	visit = func(n string) {
+		index[n] = len(index)
+		lowlink[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, m := range succ(n) {
+			if !isNode[m] {
+				continue
+			}
+			if _, ok := index[m]; !ok {
+				visit(m)
+				if lowlink[m] < lowlink[n] {
+					lowlink[n] = lowlink[m]
+				}
+			} else if onStack[m] && index[m] < lowlink[n] {
+				lowlink[n] = index[m]
+			}
+		}
+		if lowlink[n] != index[n] {
+			return
+		}
+		var scc []string
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[m] = false
+			scc = append(scc, m)
+			if m == n {
+				break
+			}
+		}
+		sort.Strings(scc)
+		sccs = append(sccs, scc)
+	}
+	for _, n := range nodes {
+		if _, ok := index[n]; !ok {
+			visit(n)
+		}
+	}
+	return sccs
+}
+
+// shortestCycle returns the shortest path of edges within a strongly
+// connected component from start back to itself.
+func (g *importGraph) shortestCycle(start label.Label, inSCC map[label.Label]bool) []importEdge {
+	type step struct {
+		prev label.Label
+		edge importEdge
+	}
+	steps := make(map[label.Label]step)
+	queue := []label.Label{start}
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		for _, e := range g.edges[l] {
+			if !inSCC[e.to] {
+				continue
+			}
+			if _, ok := steps[e.to]; ok {
+				continue
+			}
+			steps[e.to] = step{prev: l, edge: e}
+			if e.to == start {
+				var cycle []importEdge
+				for n := start; ; {
+					s := steps[n]
+					cycle = append([]importEdge{s.edge}, cycle...)
+					n = s.prev
+					if n == start {
+						return cycle
+					}
+				}
+			}
+			queue = append(queue, e.to)
+		}
+	}
+	return nil
+}
+
+// formatImportCycle describes a cycle with the import statements that cause
+// each dependency.
+func formatImportCycle(cycle []importEdge) string {
+	rules := []string{cycle[len(cycle)-1].to.String()}
+	for _, e := range cycle {
+		rules = append(rules, e.to.String())
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "proto import cycle: %s", strings.Join(rules, " -> "))
+	for _, e := range cycle {
+		switch {
+		case e.file != "" && e.line > 0:
+			fmt.Fprintf(&b, "\n\t%s:%d: import %q", e.file, e.line, e.imp)
+		case e.file != "":
+			fmt.Fprintf(&b, "\n\t%s: import %q", e.file, e.imp)
+		default:
+			fmt.Fprintf(&b, "\n\timport %q", e.imp)
+		}
+	}
+	return b.String()
+}
+
+// Before clears state recorded in a previous run. Gazelle may run more than
+// once in the same process, for example in -mode=idempotency.
+func (l *protoLang) Before(ctx context.Context) {
+	l.graph = importGraph{}
+	l.externalCache = nil
+}
+
+func (l *protoLang) DoneGeneratingRules() {}
+
+// AfterResolvingDeps reports import cycles among the proto_library rules
+// resolved in this run. In strict mode, Gazelle exits if there are cycles.
+func (l *protoLang) AfterResolvingDeps(ctx context.Context) {
+	cycles := l.graph.cycles()
+	for _, cycle := range cycles {
+		log.Print(formatImportCycle(cycle))
+	}
+	if len(cycles) > 0 && l.graph.strict {
+		log.Fatal("Exit as strict mode is on")
+	}
+}
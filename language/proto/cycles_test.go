@@ -0,0 +1,80 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"path"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestImportCycles(t *testing.T) {
+	c, lang, _ := testConfig(t, ".")
+	mrslv := make(mapResolver)
+	mrslv["proto_library"] = lang
+	ix := resolve.NewRuleIndex(mrslv.Resolver, []resolve.CrossResolver{lang.(resolve.CrossResolver)})
+
+	// a -> b -> c -> a is a cycle. d -> a is not part of it.
+	type protoRule struct {
+		pkg     string
+		imports []string
+	}
+	protoRules := []protoRule{
+		{pkg: "a", imports: []string{"b/b.proto", "google/protobuf/any.proto"}},
+		{pkg: "b", imports: []string{"c/c.proto"}},
+		{pkg: "c", imports: []string{"a/a.proto"}},
+		{pkg: "d", imports: []string{"a/a.proto"}},
+	}
+	var rules []*rule.Rule
+	for _, pr := range protoRules {
+		f := rule.EmptyFile(path.Join(pr.pkg, "BUILD.bazel"), pr.pkg)
+		r := rule.NewRule("proto_library", pr.pkg+"_proto")
+		r.SetAttr("srcs", []string{pr.pkg + ".proto"})
+		lines := make(map[string]int)
+		for i, imp := range pr.imports {
+			lines[imp] = i + 3
+		}
+		r.SetPrivateAttr(PackageKey, Package{Files: map[string]FileInfo{
+			pr.pkg + ".proto": {Name: pr.pkg + ".proto", Imports: pr.imports, ImportLines: lines},
+		}})
+		r.SetPrivateAttr(config.GazelleImportsKey, pr.imports)
+		r.Insert(f)
+		ix.AddRule(c, r, f)
+		rules = append(rules, r)
+	}
+	ix.Finish()
+
+	pl := lang.(*protoLang)
+	for i, r := range rules {
+		pl.Resolve(c, ix, nil, r, r.PrivateAttr(config.GazelleImportsKey), label.New("", protoRules[i].pkg, r.Name()))
+	}
+	cycles := pl.graph.cycles()
+	if len(cycles) != 1 {
+		t.Fatalf("got %d cycles; want 1", len(cycles))
+	}
+	got := formatImportCycle(cycles[0])
+	want := `proto import cycle: //a:a_proto -> //b:b_proto -> //c:c_proto -> //a:a_proto
+	a/a.proto:3: import "b/b.proto"
+	b/b.proto:3: import "c/c.proto"
+	c/c.proto:3: import "a/a.proto"`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,240 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// externalRepoSpec describes an external repository whose proto imports
+// should be indexed. It's parsed from a proto_external_repo directive.
+type externalRepoSpec struct {
+	// name is the apparent name of the repository, used in labels.
+	name string
+
+	// index is the path to a file mapping imports to labels. If empty, the
+	// repository is scanned instead.
+	index string
+
+	// dir is the directory where the repository is checked out. If empty,
+	// it's located in Bazel's output base with repo.FindExternalRepo.
+	dir string
+}
+
+// parseExternalRepo parses the value of a proto_external_repo directive: a
+// repository name followed by optional index=<file> and dir=<dir> settings.
+// Relative paths are resolved against the repository root.
+func parseExternalRepo(repoRoot, value string) (externalRepoSpec, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return externalRepoSpec{}, errors.New("expected a repository name")
+	}
+	spec := externalRepoSpec{name: strings.TrimPrefix(fields[0], "@")}
+	if spec.name == "" {
+		return externalRepoSpec{}, errors.New("empty repository name")
+	}
+	abs := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(repoRoot, filepath.FromSlash(p))
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return externalRepoSpec{}, fmt.Errorf("expected key=value; got %q", field)
+		}
+		switch key {
+		case "index":
+			spec.index = abs(value)
+		case "dir":
+			spec.dir = abs(value)
+		default:
+			return externalRepoSpec{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	if spec.index != "" && spec.dir != "" {
+		return externalRepoSpec{}, errors.New("index and dir may not both be set")
+	}
+	return spec, nil
+}
+
+// externalImports returns a map from proto imports to labels of
+// proto_library rules in an external repository. Results are cached, so
+// each index file is read and each repository is scanned once per run.
+func (pl *protoLang) externalImports(c *config.Config, spec externalRepoSpec) (map[string]label.Label, error) {
+	key := spec.name + "\x00" + spec.index + "\x00" + spec.dir
+	if imports, ok := pl.externalCache[key]; ok {
+		return imports, nil
+	}
+	var imports map[string]label.Label
+	var err error
+	if spec.index != "" {
+		imports, err = readExternalIndex(spec.name, spec.index)
+	} else {
+		dir := spec.dir
+		if dir == "" {
+			dir, err = repo.FindExternalRepo(c.RepoRoot, spec.name)
+		}
+		if err == nil {
+			imports, err = pl.scanExternalRepo(spec.name, dir)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pl.externalCache == nil {
+		pl.externalCache = make(map[string]map[string]label.Label)
+	}
+	pl.externalCache[key] = imports
+	return imports, nil
+}
+
+// readExternalIndex reads a file mapping proto imports to labels. Each
+// non-empty line that isn't a comment has an import path and a label,
+// separated by whitespace. Labels without a repository name are in the
+// repository repoName.
+func readExternalIndex(repoName, path string) (map[string]label.Label, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	imports := make(map[string]label.Label)
+	s := bufio.NewScanner(f)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected an import path and a label", path, lineNum)
+		}
+		l, err := label.Parse(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		if l.Repo == "" {
+			l.Repo = repoName
+		}
+		imports[fields[0]] = l
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// scanExternalRepo indexes proto_library rules in build files in dir, the
+// root directory of the external repository repoName. Build files that can't
+// be parsed are reported and skipped, as are version control and output
+// directories and nested repositories.
+//
+// Import paths are computed with a default proto configuration, since
+// directives in the main repository don't apply to the external one.
+//
+// The scan happens in Configure when a proto_external_repo directive is
+// first read, and it's repeated in each run. Nothing is saved between runs.
+func (pl *protoLang) scanExternalRepo(repoName, dir string) (map[string]label.Label, error) {
+	ec := config.New()
+	ec.RepoRoot = dir
+	ec.RepoName = repoName
+	ec.Exts[protoName] = &ProtoConfig{}
+	imports := make(map[string]label.Label)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && skipExternalDir(p, d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "BUILD.bazel" && d.Name() != "BUILD" {
+			return nil
+		}
+		if d.Name() == "BUILD" {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(p), "BUILD.bazel")); err == nil {
+				// BUILD.bazel takes precedence.
+				return nil
+			}
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		f, err := rule.LoadFile(p, rel)
+		if err != nil {
+			log.Printf("scanning repository %s: %v", repoName, err)
+			return nil
+		}
+		for _, r := range f.Rules {
+			if r.Kind() != "proto_library" {
+				continue
+			}
+			for _, imp := range pl.Imports(ec, r, f) {
+				if _, ok := imports[imp.Imp]; !ok {
+					imports[imp.Imp] = label.New(repoName, rel, r.Name())
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning repository %s: %w", repoName, err)
+	}
+	return imports, nil
+}
+
+// skipExternalDir returns whether the directory at p, named base, should be
+// skipped when scanning an external repository. Version control directories,
+// bazel-* output symlinks, node_modules, and the roots of nested repositories
+// don't contain packages of the repository being scanned.
+func skipExternalDir(p, base string) bool {
+	switch {
+	case base == ".git" || base == ".hg" || base == ".svn" || base == "node_modules":
+		return true
+	case strings.HasPrefix(base, "bazel-"):
+		return true
+	}
+	for _, name := range []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel", "REPO.bazel"} {
+		if _, err := os.Stat(filepath.Join(p, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
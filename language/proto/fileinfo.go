@@ -16,14 +16,10 @@ limitations under the License.
 package proto
 
 import (
-	"bytes"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
-	"strings"
 )
 
 // FileInfo contains metadata extracted from a .proto file.
@@ -32,20 +28,75 @@ type FileInfo struct {
 
 	PackageName string
 
+	// Syntax is the value of the syntax statement, for example, "proto3".
+	// Edition is the value of the edition statement, for example, "2023".
+	// Both are empty if the file doesn't have the statement.
+	Syntax, Edition string
+
 	Options []Option
 	Imports []string
 
+	// ImportLines maps each import path to the line number of its import
+	// statement.
+	ImportLines map[string]int
+
 	HasServices bool
+
+	// Messages, Enums, Services, and Extends are the top-level declarations
+	// in the file, in the order they appear.
+	Messages []Message
+	Enums    []Enum
+	Services []Service
+	Extends  []Extend
 }
 
-// Option represents a top-level option statement in a .proto file. Only
-// string options are supported for now.
+// Option represents a top-level option statement in a .proto file. String
+// values are unquoted. Other values are recorded as they appear in the
+// source, for example, "SPEED" or "true".
 type Option struct {
 	Key, Value string
 }
 
-var protoRe = buildProtoRegexp()
+// Message is a message declaration, with its nested declarations. Fields
+// are not included.
+type Message struct {
+	Name     string
+	Messages []Message
+	Enums    []Enum
+	Extends  []Extend
+}
+
+// Enum is an enum declaration with the names of its values.
+type Enum struct {
+	Name   string
+	Values []string
+}
+
+// Service is a service declaration.
+type Service struct {
+	Name    string
+	Methods []Method
+}
+
+// Method is an rpc declaration in a service. InputType and OutputType are
+// message type names as written in the source.
+type Method struct {
+	Name                             string
+	InputType, OutputType            string
+	ClientStreaming, ServerStreaming bool
+}
+
+// Extend is an extend block. Extendee is the name of the extended message
+// as written in the source, and Fields are the names of the extension
+// fields.
+type Extend struct {
+	Extendee string
+	Fields   []string
+}
 
+// protoFileInfo reads and parses a .proto file. Syntax errors are logged
+// with their positions, and information from declarations before the error
+// is returned.
 func protoFileInfo(dir, name string) FileInfo {
 	info := FileInfo{
 		Path: filepath.Join(dir, name),
@@ -57,82 +108,11 @@ func protoFileInfo(dir, name string) FileInfo {
 		return info
 	}
 
-	for _, match := range protoRe.FindAllSubmatch(content, -1) {
-		switch {
-		case match[importSubexpIndex] != nil:
-			imp := unquoteProtoString(match[importSubexpIndex])
-			info.Imports = append(info.Imports, imp)
-
-		case match[packageSubexpIndex] != nil:
-			pkg := string(match[packageSubexpIndex])
-			if info.PackageName == "" {
-				info.PackageName = pkg
-			}
-
-		case match[optkeySubexpIndex] != nil:
-			key := string(match[optkeySubexpIndex])
-			value := unquoteProtoString(match[optvalSubexpIndex])
-			info.Options = append(info.Options, Option{key, value})
-
-		case match[serviceSubexpIndex] != nil:
-			info.HasServices = true
-
-		default:
-			// Comment matched. Nothing to extract.
-		}
+	if err := parseProto(content, &info); err != nil {
+		log.Printf("%s:%v", info.Path, err)
 	}
+	info.HasServices = len(info.Services) > 0
 	sort.Strings(info.Imports)
 
 	return info
 }
-
-const (
-	importSubexpIndex  = 1
-	packageSubexpIndex = 2
-	optkeySubexpIndex  = 3
-	optvalSubexpIndex  = 4
-	serviceSubexpIndex = 5
-)
-
-// Based on https://developers.google.com/protocol-buffers/docs/reference/proto3-spec
-func buildProtoRegexp() *regexp.Regexp {
-	hexEscape := `\\[xX][0-9a-fA-f]{2}`
-	octEscape := `\\[0-7]{3}`
-	charEscape := `\\[abfnrtv'"\\]`
-	charValue := strings.Join([]string{hexEscape, octEscape, charEscape, "[^\x00\\'\\\"\\\\]"}, "|")
-	strLit := `'(?:` + charValue + `|")*'|"(?:` + charValue + `|')*"`
-	ident := `[A-Za-z][A-Za-z0-9_]*`
-	fullIdent := ident + `(?:\.` + ident + `)*`
-	importStmt := `\bimport\s*(?:public|weak)?\s*(?P<import>` + strLit + `)\s*;`
-	packageStmt := `\bpackage\s*(?P<package>` + fullIdent + `)\s*;`
-	optionStmt := `\boption\s*(?P<optkey>` + fullIdent + `)\s*=\s*(?P<optval>` + strLit + `)\s*;`
-	serviceStmt := `(?P<service>service\s*` + ident + `\s*{)`
-	comment := `//[^\n]*`
-	protoReSrc := strings.Join([]string{importStmt, packageStmt, optionStmt, serviceStmt, comment}, "|")
-	return regexp.MustCompile(protoReSrc)
-}
-
-func unquoteProtoString(q []byte) string {
-	// Adjust quotes so that Unquote is happy. We need a double quoted string
-	// without unescaped double quote characters inside.
-	noQuotes := bytes.Split(q[1:len(q)-1], []byte{'"'})
-	if len(noQuotes) != 1 {
-		for i := 0; i < len(noQuotes)-1; i++ {
-			if len(noQuotes[i]) == 0 || noQuotes[i][len(noQuotes[i])-1] != '\\' {
-				noQuotes[i] = append(noQuotes[i], '\\')
-			}
-		}
-		q = append([]byte{'"'}, bytes.Join(noQuotes, []byte{'"'})...)
-		q = append(q, '"')
-	}
-	if q[0] == '\'' {
-		q[0] = '"'
-		q[len(q)-1] = '"'
-	}
-
-	s, err := strconv.Unquote(string(q))
-	if err != nil {
-		log.Panicf("unquoting string literal %s from proto: %v", q, err)
-	}
-	return s
-}
@@ -22,25 +22,6 @@ import (
 	"testing"
 )
 
-func TestProtoRegexpGroupNames(t *testing.T) {
-	names := protoRe.SubexpNames()
-	nameMap := map[string]int{
-		"import":  importSubexpIndex,
-		"package": packageSubexpIndex,
-		"optkey":  optkeySubexpIndex,
-		"optval":  optvalSubexpIndex,
-		"service": serviceSubexpIndex,
-	}
-	for name, index := range nameMap {
-		if names[index] != name {
-			t.Errorf("proto regexp subexp %d is %s ; want %s", index, names[index], name)
-		}
-	}
-	if len(names)-1 != len(nameMap) {
-		t.Errorf("proto regexp has %d groups ; want %d", len(names), len(nameMap))
-	}
-}
-
 func TestProtoFileInfo(t *testing.T) {
 	for _, tc := range []struct {
 		desc, name, proto string
@@ -109,6 +90,7 @@ import "second.proto";`,
 			proto: `service ChatService {}`,
 			want: FileInfo{
 				HasServices: true,
+				Services:    []Service{{Name: "ChatService"}},
 			},
 		}, {
 			desc:  "service as name",
@@ -116,6 +98,133 @@ import "second.proto";`,
 			proto: `message ServiceAccount { string service = 1; }`,
 			want: FileInfo{
 				HasServices: false,
+				Messages:    []Message{{Name: "ServiceAccount"}},
+			},
+		}, {
+			desc: "comments",
+			name: "comments.proto",
+			proto: `/* import "block.proto"; */
+// import "line.proto";
+/*
+service Commented {}
+*/
+import /* inline */ "real.proto";`,
+			want: FileInfo{
+				Imports: []string{"real.proto"},
+			},
+		}, {
+			desc: "non-string options",
+			name: "options.proto",
+			proto: `option optimize_for = SPEED;
+option cc_enable_arenas = true;
+option (custom.opt).field = -1;
+option (.custom.msg) = { a: 1 b: "x" };`,
+			want: FileInfo{
+				Options: []Option{
+					{Key: "optimize_for", Value: "SPEED"},
+					{Key: "cc_enable_arenas", Value: "true"},
+					{Key: "(custom.opt).field", Value: "-1"},
+					{Key: "(.custom.msg)", Value: `{ a: 1 b: "x" }`},
+				},
+			},
+		}, {
+			desc: "syntax and edition",
+			name: "edition.proto",
+			proto: `edition = "2023";
+package foo;`,
+			want: FileInfo{
+				Edition:     "2023",
+				PackageName: "foo",
+			},
+		}, {
+			desc: "declarations",
+			name: "decls.proto",
+			proto: `syntax = "proto2";
+
+message Outer {
+  option deprecated = true;
+  message Inner {}
+  enum Kind { option allow_alias = true; A = 0; B = 1 [deprecated = true]; }
+  optional group Result = 1 { optional string url = 2; }
+  map<string, Inner> inners = 3 [(opt) = { x: [1, 2] }];
+  oneof choice { string s = 4; int32 i = 5; }
+  reserved 6 to 8, "old";
+  extensions 100 to max;
+  extend Other { optional int32 nested_ext = 101; }
+}
+
+enum Top { TOP_UNSPECIFIED = 0; }
+
+service Svc {
+  option deprecated = true;
+  rpc Get(.pkg.Req) returns (Resp);
+  rpc Watch(stream Req) returns (stream Resp) { option idempotency_level = NO_SIDE_EFFECTS; }
+}
+
+extend google.protobuf.FieldOptions {
+  optional string tag = 50000;
+  repeated int32 ids = 50001;
+}`,
+			want: FileInfo{
+				Syntax:      "proto2",
+				HasServices: true,
+				Messages: []Message{{
+					Name:     "Outer",
+					Messages: []Message{{Name: "Inner"}},
+					Enums:    []Enum{{Name: "Kind", Values: []string{"A", "B"}}},
+					Extends:  []Extend{{Extendee: "Other", Fields: []string{"nested_ext"}}},
+				}},
+				Enums: []Enum{{Name: "Top", Values: []string{"TOP_UNSPECIFIED"}}},
+				Services: []Service{{
+					Name: "Svc",
+					Methods: []Method{
+						{Name: "Get", InputType: ".pkg.Req", OutputType: "Resp"},
+						{Name: "Watch", InputType: "Req", OutputType: "Resp", ClientStreaming: true, ServerStreaming: true},
+					},
+				}},
+				Extends: []Extend{{Extendee: "google.protobuf.FieldOptions", Fields: []string{"tag", "ids"}}},
+			},
+		}, {
+			desc: "syntax error",
+			name: "error.proto",
+			proto: `package foo;
+import "a.proto";
+message {}
+import "b.proto";`,
+			want: FileInfo{
+				PackageName: "foo",
+				Imports:     []string{"a.proto", "b.proto"},
+			},
+		}, {
+			desc: "string escapes",
+			name: "escapes.proto",
+			proto: `import "it\'s.proto";
+import 'q\?\"\x41\101\u00e9.proto';
+option (a) = "\0\n";`,
+			want: FileInfo{
+				Imports: []string{"it's.proto", "q?\"AA\u00e9.proto"},
+				Options: []Option{{Key: "(a)", Value: "\x00\n"}},
+			},
+		}, {
+			desc: "missing semicolons",
+			name: "semicolons.proto",
+			proto: `syntax = "proto3"
+package foo
+import "a.proto"`,
+			want: FileInfo{
+				Syntax:      "proto3",
+				PackageName: "foo",
+				Imports:     []string{"a.proto"},
+			},
+		}, {
+			desc: "unterminated string",
+			name: "unterminated.proto",
+			proto: `package foo;
+import "a.proto";
+import "b.proto;`,
+			want: FileInfo{
+				PackageName: "foo",
+				Imports:     []string{"a.proto"},
 			},
 		},
 	} {
@@ -134,9 +243,15 @@ import "second.proto";`,
 			// Clear fields we don't care about for testing.
 			got = FileInfo{
 				PackageName: got.PackageName,
+				Syntax:      got.Syntax,
+				Edition:     got.Edition,
 				Imports:     got.Imports,
 				Options:     got.Options,
 				HasServices: got.HasServices,
+				Messages:    got.Messages,
+				Enums:       got.Enums,
+				Services:    got.Services,
+				Extends:     got.Extends,
 			}
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("got %#v; want %#v", got, tc.want)
@@ -144,3 +259,42 @@ import "second.proto";`,
 		})
 	}
 }
+
+func TestParseProtoError(t *testing.T) {
+	for _, tc := range []struct {
+		desc, proto, want string
+	}{
+		{
+			desc:  "missing name",
+			proto: "package foo;\nmessage {}",
+			want:  `2:9: expected identifier; found "{"`,
+		}, {
+			desc:  "unterminated comment",
+			proto: "package foo;\n  /* comment",
+			want:  "2:3: unterminated block comment",
+		}, {
+			desc:  "unterminated string",
+			proto: `import "a.proto;`,
+			want:  "1:8: unterminated string literal",
+		}, {
+			desc:  "invalid escape",
+			proto: `import "a\c.proto";`,
+			want:  `1:8: invalid string literal: invalid escape \c`,
+		}, {
+			desc:  "missing semicolon",
+			proto: "message M {\n  string s = 1\n}",
+			want:  `3:1: expected ";"; found "}"`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var info FileInfo
+			err := parseProto([]byte(tc.proto), &info)
+			if err == nil {
+				t.Fatal("got success; want error")
+			}
+			if err.Error() != tc.want {
+				t.Errorf("got error %q; want %q", err, tc.want)
+			}
+		})
+	}
+}
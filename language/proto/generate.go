@@ -82,11 +82,36 @@ func (*protoLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	sort.SliceStable(res.Gen, func(i, j int) bool {
 		return res.Gen[i].Name() < res.Gen[j].Name()
 	})
+	res.Empty = append(res.Empty, generateEmpty(args.File, regularProtoFiles, genProtoFiles)...)
+	if pc.Mode == SCCMode {
+		var replaced []*rule.Rule
+		replaced, res.Replaced = generateReplaced(args.File, res.Gen)
+		res.Empty = append(res.Empty, replaced...)
+	}
+
+	// Generate rules for proto plugins next to each proto_library.
+	if len(pc.plugins) > 0 {
+		protoRules := res.Gen
+		res.Gen = nil
+		for _, r := range protoRules {
+			pkg, _ := r.PrivateAttr(PackageKey).(Package)
+			gen, empty := generatePluginRules(pc, r, pkg.HasServices, false, shouldSetVisibility)
+			res.Gen = append(append(res.Gen, r), gen...)
+			res.Empty = append(res.Empty, empty...)
+		}
+		for _, r := range res.Empty {
+			if r.Kind() == "proto_library" {
+				_, empty := generatePluginRules(pc, r, false, true, false)
+				res.Empty = append(res.Empty, empty...)
+			}
+		}
+		res.MappedKinds = mappedKinds(pc.plugins)
+	}
+
 	res.Imports = make([]interface{}, len(res.Gen))
 	for i, r := range res.Gen {
 		res.Imports[i] = r.PrivateAttr(config.GazelleImportsKey)
 	}
-	res.Empty = append(res.Empty, generateEmpty(args.File, regularProtoFiles, genProtoFiles)...)
 	return res
 }
 
@@ -122,7 +147,7 @@ func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []stri
 		info := protoFileInfo(dir, name)
 		key := info.PackageName
 
-		if pc.Mode == FileMode {
+		if pc.Mode == FileMode || pc.Mode == SCCMode {
 			key = strings.TrimSuffix(name, ".proto")
 		} else if pc.groupOption != "" { // implicitly PackageMode
 			for _, opt := range info.Options {
@@ -163,11 +188,54 @@ func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []stri
 		}
 		return pkgs
 
+	case SCCMode:
+		return mergeImportCycles(pc, rel, packageMap)
+
 	default:
 		return nil
 	}
 }
 
+// mergeImportCycles merges packages containing single files that import
+// each other, directly or indirectly, so that each package is a strongly
+// connected component of the import graph. The merged package is named
+// after the first of its files in sorted order, so its name is stable as
+// long as that file is in the cycle.
+func mergeImportCycles(pc *ProtoConfig, rel string, packageMap map[string]*Package) []*Package {
+	prefix := getPrefix(pc, rel)
+	keyForImport := make(map[string]string)
+	keys := make([]string, 0, len(packageMap))
+	for key, pkg := range packageMap {
+		for name := range pkg.Files {
+			keyForImport[path.Join(prefix, name)] = key
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	succ := func(key string) []string {
+		var succ []string
+		for imp := range packageMap[key].Imports {
+			if k, ok := keyForImport[imp]; ok && k != key {
+				succ = append(succ, k)
+			}
+		}
+		sort.Strings(succ)
+		return succ
+	}
+
+	var pkgs []*Package
+	for _, scc := range stronglyConnectedComponents(keys, succ) {
+		pkg := packageMap[scc[0]]
+		for _, key := range scc[1:] {
+			for _, info := range packageMap[key].Files {
+				pkg.addFile(info)
+			}
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
 // selectPackage chooses a package to generate rules for.
 func selectPackage(dir, rel string, packageMap map[string]*Package) (*Package, error) {
 	if len(packageMap) == 0 {
@@ -309,3 +377,56 @@ outer:
 	}
 	return empty
 }
+
+// generateReplaced generates a list of proto_library rules that may be
+// deleted because their sources are now split across generated rules with
+// different names. It also returns the names of the generated rules that
+// replace each of them, so references elsewhere in the repository can be
+// rewritten.
+func generateReplaced(f *rule.File, gen []*rule.Rule) ([]*rule.Rule, map[string][]string) {
+	if f == nil {
+		return nil, nil
+	}
+	genNames := make(map[string]bool)
+	genSrcs := make(map[string]string)
+	for _, r := range gen {
+		if r.Kind() != "proto_library" {
+			continue
+		}
+		genNames[r.Name()] = true
+		for _, src := range r.AttrStrings("srcs") {
+			genSrcs[src] = r.Name()
+		}
+	}
+	var empty []*rule.Rule
+	var replaced map[string][]string
+outer:
+	for _, r := range f.Rules {
+		if r.Kind() != "proto_library" || genNames[r.Name()] {
+			continue
+		}
+		srcs := r.AttrStrings("srcs")
+		if len(srcs) == 0 {
+			continue
+		}
+		nameSet := make(map[string]bool)
+		for _, src := range srcs {
+			name, ok := genSrcs[src]
+			if !ok {
+				continue outer
+			}
+			nameSet[name] = true
+		}
+		names := make([]string, 0, len(nameSet))
+		for name := range nameSet {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if replaced == nil {
+			replaced = make(map[string][]string)
+		}
+		replaced[r.Name()] = names
+		empty = append(empty, rule.NewRule("proto_library", r.Name()))
+	}
+	return empty, replaced
+}
@@ -169,12 +169,18 @@ func TestGeneratePackage(t *testing.T) {
 				Path:        filepath.Join(dir, "foo.proto"),
 				Name:        "foo.proto",
 				PackageName: "bar.foo",
+				Syntax:      "proto2",
 				Options:     []Option{{Key: "go_package", Value: "example.com/repo/protos"}},
 				Imports: []string{
 					"google/protobuf/any.proto",
 					"protos/sub/sub.proto",
 				},
+				ImportLines: map[string]int{
+					"google/protobuf/any.proto": 7,
+					"protos/sub/sub.proto":      8,
+				},
 				HasServices: true,
+				Services:    []Service{{Name: "Quux"}},
 			},
 		},
 		Imports: map[string]bool{
@@ -235,6 +241,8 @@ func TestFileModeImports(t *testing.T) {
 				Path:        filepath.Join(dir, "foo.proto"),
 				Name:        "foo.proto",
 				PackageName: "file_mode",
+				Syntax:      "proto3",
+				Messages:    []Message{{Name: "Foo"}},
 			},
 		},
 		Imports: map[string]bool{},
@@ -249,9 +257,12 @@ func TestFileModeImports(t *testing.T) {
 				Path:        filepath.Join(dir, "bar.proto"),
 				Name:        "bar.proto",
 				PackageName: "file_mode",
+				Syntax:      "proto3",
 				Imports: []string{
 					"file_mode/foo.proto",
 				},
+				ImportLines: map[string]int{"file_mode/foo.proto": 10},
+				Messages:    []Message{{Name: "Bar"}},
 			},
 		},
 		// Imports should contain foo.proto. This is specific to file mode.
@@ -371,6 +382,9 @@ func testConfig(t *testing.T, repoRoot string) (*config.Config, language.Languag
 func convertImportsAttrs(f *rule.File) {
 	for _, r := range f.Rules {
 		v := r.PrivateAttr(config.GazelleImportsKey)
+		if pi, ok := v.(pluginImports); ok {
+			v = pi.imports
+		}
 		if v != nil {
 			r.SetAttr(config.GazelleImportsKey, v)
 		}
@@ -32,8 +32,32 @@ var protoKinds = map[string]rule.KindInfo{
 		},
 		ResolveAttrs: map[string]bool{"deps": true},
 	},
+
+	// Placeholders for kinds generated by proto plugins, which are set with
+	// the proto_plugin directive. Generated rules are mapped from these kinds.
+	protoPluginKind: protoPluginKindInfo,
+	grpcPluginKind:  grpcPluginKindInfo,
 }
 
+const (
+	protoPluginKind = "proto_plugin_library"
+	grpcPluginKind  = "proto_plugin_grpc_library"
+)
+
+var (
+	protoPluginKindInfo = rule.KindInfo{
+		NonEmptyAttrs:   map[string]bool{"deps": true, "proto": true},
+		SubstituteAttrs: map[string]bool{"deps": true, "proto": true},
+		MergeableAttrs:  map[string]bool{"deps": true, "proto": true},
+		ResolveAttrs:    map[string]bool{"deps": true},
+	}
+	grpcPluginKindInfo = rule.KindInfo{
+		NonEmptyAttrs:   map[string]bool{"srcs": true},
+		SubstituteAttrs: map[string]bool{"deps": true, "srcs": true},
+		MergeableAttrs:  map[string]bool{"deps": true, "srcs": true},
+	}
+)
+
 func (*protoLang) Kinds() map[string]rule.KindInfo { return protoKinds }
 
 func (pl *protoLang) Loads() []rule.LoadInfo {
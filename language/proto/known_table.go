@@ -0,0 +1,142 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// knownImportTable contains known imports loaded at run time from CSV files
+// in the same format as proto.csv. Entries take precedence over the built-in
+// tables generated from proto.csv.
+type knownImportTable struct {
+	// protoLabels maps proto import paths to proto_library labels.
+	protoLabels map[string]label.Label
+
+	// protoGoLabels maps proto import paths to go_proto_library labels.
+	protoGoLabels map[string]label.Label
+
+	// goLabels maps Go import paths to go_proto_library labels.
+	goLabels map[string]label.Label
+}
+
+// readKnownImports reads a CSV file in the same format as proto.csv. Each
+// record has four fields: a proto import path, a proto_library label, a Go
+// import path, and a go_proto_library label. The Go fields may be empty.
+func readKnownImports(path string) (*knownImportTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(bufio.NewReader(f))
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &knownImportTable{
+		protoLabels:   make(map[string]label.Label),
+		protoGoLabels: make(map[string]label.Label),
+		goLabels:      make(map[string]label.Label),
+	}
+	add := func(m map[string]label.Label, key, value string) error {
+		if key == "" || value == "" {
+			return nil
+		}
+		l, err := label.Parse(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if old, ok := m[key]; ok && !old.Equal(l) {
+			return fmt.Errorf("%s: for key %s, multiple values (%s and %s)", path, key, old, l)
+		}
+		m[key] = l
+		return nil
+	}
+	for _, rec := range records {
+		if err := add(t.protoLabels, rec[0], rec[1]); err != nil {
+			return nil, err
+		}
+		if err := add(t.protoGoLabels, rec[0], rec[3]); err != nil {
+			return nil, err
+		}
+		if err := add(t.goLabels, rec[2], rec[3]); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// merge returns a new table containing the entries of t and other. Entries
+// in other take precedence. t may be nil.
+func (t *knownImportTable) merge(other *knownImportTable) *knownImportTable {
+	if t == nil {
+		return other
+	}
+	mergeMaps := func(a, b map[string]label.Label) map[string]label.Label {
+		m := make(map[string]label.Label, len(a)+len(b))
+		for k, v := range a {
+			m[k] = v
+		}
+		for k, v := range b {
+			m[k] = v
+		}
+		return m
+	}
+	return &knownImportTable{
+		protoLabels:   mergeMaps(t.protoLabels, other.protoLabels),
+		protoGoLabels: mergeMaps(t.protoGoLabels, other.protoGoLabels),
+		goLabels:      mergeMaps(t.goLabels, other.goLabels),
+	}
+}
+
+// protoLabel returns the proto_library label for a proto import path. It's
+// safe to call on a nil table.
+func (t *knownImportTable) protoLabel(imp string) (label.Label, bool) {
+	if t == nil {
+		return label.NoLabel, false
+	}
+	l, ok := t.protoLabels[imp]
+	return l, ok
+}
+
+// protoGoLabel returns the go_proto_library label for a proto import path.
+// It's safe to call on a nil table.
+func (t *knownImportTable) protoGoLabel(imp string) (label.Label, bool) {
+	if t == nil {
+		return label.NoLabel, false
+	}
+	l, ok := t.protoGoLabels[imp]
+	return l, ok
+}
+
+// goLabel returns the go_proto_library label for a Go import path. It's safe
+// to call on a nil table.
+func (t *knownImportTable) goLabel(imp string) (label.Label, bool) {
+	if t == nil {
+		return label.NoLabel, false
+	}
+	l, ok := t.goLabels[imp]
+	return l, ok
+}
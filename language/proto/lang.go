@@ -49,21 +49,38 @@ limitations under the License.
 // (e.g., //foo/bar:bar_proto). If no indexed proto_library provides the source
 // file, Gazelle will guess a label, following conventions.
 //
-// No attempt is made to resolve protos to rules in external repositories,
+// Imports are not resolved to rules in external repositories by default,
 // since there's no indication that a proto import comes from an external
-// repository. In the future, build files in external repos will be indexed,
-// so we can support this (#12).
+// repository. The proto_external_repo directive indexes proto_library rules
+// in an external repository, either by scanning its build files or by reading
+// an index file mapping imports to labels.
 //
 // Gazelle has special cases for Well Known Types (i.e., imports of the form
 // google/protobuf/*.proto). These are resolved to rules in
 // @com_google_protobuf.
+//
+// After resolution, Gazelle reports import cycles between proto_library rules
+// in the main repository, listing the import statements involved. In strict
+// mode, Gazelle exits with an error if there are cycles.
 package proto
 
-import "github.com/bazelbuild/bazel-gazelle/language"
+import (
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
 
 const protoName = "proto"
 
-type protoLang struct{}
+type protoLang struct {
+	// graph records dependencies between proto_library rules resolved in
+	// this run. It's used to report import cycles.
+	graph importGraph
+
+	// externalCache maps external repository specs to imports indexed in
+	// those repositories, so each is read or scanned once per run. It's not
+	// saved between runs.
+	externalCache map[string]map[string]label.Label
+}
 
 func (*protoLang) Name() string { return protoName }
 
@@ -0,0 +1,723 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file contains a tokenizer and parser for .proto files. It understands
+// proto2, proto3, and editions syntax well enough to extract the top-level
+// declarations Gazelle needs. Fields and most options are skipped without
+// being interpreted.
+//
+// Based on https://protobuf.dev/reference/protobuf/proto3-spec/ and
+// https://protobuf.dev/reference/protobuf/edition-2023-spec/.
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	identToken
+	numberToken
+	stringToken
+	punctToken
+)
+
+type protoToken struct {
+	kind tokenKind
+
+	// text is the token's source text. Strings are unquoted by the parser
+	// where their values are needed.
+	text string
+
+	// start and end are byte offsets of the token in the source.
+	start, end int
+
+	line, col int
+}
+
+func (t protoToken) String() string {
+	switch t.kind {
+	case eofToken:
+		return "end of file"
+	case stringToken:
+		return t.text
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// ParseError describes a syntax error in a .proto file.
+type ParseError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// tokenizeProto splits the contents of a .proto file into tokens, skipping
+// whitespace and comments. The last token is always an eofToken. If there's
+// an error, the tokens before it are returned with the error.
+func tokenizeProto(content []byte) (toks []protoToken, err error) {
+	line, col := 1, 1
+	i := 0
+	advance := func(n int) {
+		for ; n > 0; n-- {
+			if content[i] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+			i++
+		}
+	}
+	errorf := func(line, col int, format string, args ...interface{}) error {
+		return &ParseError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+	}
+	defer func() {
+		toks = append(toks, protoToken{kind: eofToken, start: i, end: i, line: line, col: col})
+	}()
+
+	for i < len(content) {
+		c := content[i]
+		startLine, startCol, start := line, col, i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			advance(1)
+
+		case bytes.HasPrefix(content[i:], []byte("//")):
+			n := bytes.IndexByte(content[i:], '\n')
+			if n < 0 {
+				n = len(content) - i
+			}
+			advance(n)
+
+		case bytes.HasPrefix(content[i:], []byte("/*")):
+			n := bytes.Index(content[i+2:], []byte("*/"))
+			if n < 0 {
+				return toks, errorf(startLine, startCol, "unterminated block comment")
+			}
+			advance(n + 4)
+
+		case isIdentStart(c):
+			n := 1
+			for i+n < len(content) && isIdentPart(content[i+n]) {
+				n++
+			}
+			advance(n)
+			toks = append(toks, protoToken{kind: identToken, text: string(content[start:i]), start: start, end: i, line: startLine, col: startCol})
+
+		case isDigit(c) || c == '.' && i+1 < len(content) && isDigit(content[i+1]):
+			n := 1
+			for i+n < len(content) {
+				d := content[i+n]
+				if isIdentPart(d) || d == '.' {
+					n++
+				} else if (d == '+' || d == '-') && (content[i+n-1] == 'e' || content[i+n-1] == 'E') && !bytes.HasPrefix(bytes.ToLower(content[i:i+n]), []byte("0x")) {
+					n++
+				} else {
+					break
+				}
+			}
+			advance(n)
+			toks = append(toks, protoToken{kind: numberToken, text: string(content[start:i]), start: start, end: i, line: startLine, col: startCol})
+
+		case c == '"' || c == '\'':
+			n := 1
+			for {
+				if i+n >= len(content) || content[i+n] == '\n' {
+					return toks, errorf(startLine, startCol, "unterminated string literal")
+				}
+				if content[i+n] == '\\' {
+					n += 2
+					continue
+				}
+				if content[i+n] == c {
+					n++
+					break
+				}
+				n++
+			}
+			advance(n)
+			toks = append(toks, protoToken{kind: stringToken, text: string(content[start:i]), start: start, end: i, line: startLine, col: startCol})
+
+		default:
+			advance(1)
+			toks = append(toks, protoToken{kind: punctToken, text: string(c), start: start, end: i, line: startLine, col: startCol})
+		}
+	}
+	return toks, nil
+}
+
+func isIdentStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// protoParser builds a FileInfo from a list of tokens. Syntax errors are
+// reported by panicking with a *ParseError, which parseProto recovers.
+type protoParser struct {
+	content []byte
+	toks    []protoToken
+	i       int
+	info    *FileInfo
+}
+
+// parseProto parses the contents of a .proto file and fills in the
+// declarations in info. If there are syntax errors, the first one is
+// returned. A top-level statement with an error is skipped, and parsing
+// continues with the next one, so info contains the declarations that could
+// be parsed. An error in the tokenizer ends the file early.
+func parseProto(content []byte, info *FileInfo) error {
+	toks, tokErr := tokenizeProto(content)
+	p := &protoParser{content: content, toks: toks, info: info}
+	err := p.parseFile()
+	if tokErr == nil {
+		return err
+	}
+	// A syntax error at the end of the tokens is caused by the tokenizer
+	// error, which is more useful.
+	eof := toks[len(toks)-1]
+	if perr, ok := err.(*ParseError); err == nil || ok && perr.Line == eof.line && perr.Col == eof.col {
+		return tokErr
+	}
+	return err
+}
+
+func (p *protoParser) peek() protoToken {
+	return p.toks[p.i]
+}
+
+func (p *protoParser) next() protoToken {
+	t := p.toks[p.i]
+	if t.kind != eofToken {
+		p.i++
+	}
+	return t
+}
+
+// is returns whether the next token is an identifier or punctuation with the
+// given text.
+func (p *protoParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == identToken || t.kind == punctToken) && t.text == text
+}
+
+func (p *protoParser) errorf(t protoToken, format string, args ...interface{}) {
+	panic(&ParseError{Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *protoParser) expect(text string) protoToken {
+	if !p.is(text) {
+		t := p.peek()
+		p.errorf(t, "expected %q; found %s", text, t)
+	}
+	return p.next()
+}
+
+func (p *protoParser) expectKind(kind tokenKind, what string) protoToken {
+	t := p.peek()
+	if t.kind != kind {
+		p.errorf(t, "expected %s; found %s", what, t)
+	}
+	return p.next()
+}
+
+func (p *protoParser) parseIdent() string {
+	return p.expectKind(identToken, "identifier").text
+}
+
+// parseString parses a string constant and returns its value. Adjacent
+// strings are concatenated.
+func (p *protoParser) parseString() string {
+	var sb strings.Builder
+	for {
+		t := p.expectKind(stringToken, "string")
+		s, err := unquoteProtoString(t.text)
+		if err != nil {
+			p.errorf(t, "invalid string literal: %v", err)
+		}
+		sb.WriteString(s)
+		if p.peek().kind != stringToken {
+			return sb.String()
+		}
+	}
+}
+
+// parseFullIdent parses a dot-separated identifier like foo.bar.Baz. If
+// leadingDot is true, a fully qualified name like .foo.Bar is also accepted.
+func (p *protoParser) parseFullIdent(leadingDot bool) string {
+	var sb strings.Builder
+	if leadingDot && p.is(".") {
+		p.next()
+		sb.WriteString(".")
+	}
+	sb.WriteString(p.parseIdent())
+	for p.is(".") {
+		p.next()
+		sb.WriteString(".")
+		sb.WriteString(p.parseIdent())
+	}
+	return sb.String()
+}
+
+func (p *protoParser) parseFile() (err error) {
+	for p.peek().kind != eofToken {
+		start := p.i
+		if perr := p.parseTopLevel(); perr != nil {
+			if err == nil {
+				err = perr
+			}
+			p.i = start
+			p.skipTopLevel()
+		}
+	}
+	return err
+}
+
+// parseTopLevel parses one top-level statement. Syntax errors are returned
+// instead of panicking.
+func (p *protoParser) parseTopLevel() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(*ParseError)
+			if !ok {
+				panic(r)
+			}
+			err = perr
+		}
+	}()
+
+	t := p.peek()
+	switch {
+	case p.is(";"):
+		p.next()
+
+	case p.is("syntax"):
+		p.next()
+		p.expect("=")
+		p.info.Syntax = p.parseString()
+		p.endTopLevel()
+
+	case p.is("edition"):
+		p.next()
+		p.expect("=")
+		p.info.Edition = p.parseString()
+		p.endTopLevel()
+
+	case p.is("package"):
+		p.next()
+		pkg := p.parseFullIdent(false)
+		p.endTopLevel()
+		if p.info.PackageName == "" {
+			p.info.PackageName = pkg
+		}
+
+	case p.is("import"):
+		line := p.next().line
+		if p.is("public") || p.is("weak") {
+			p.next()
+		}
+		imp := p.parseString()
+		p.info.Imports = append(p.info.Imports, imp)
+		if p.info.ImportLines == nil {
+			p.info.ImportLines = make(map[string]int)
+		}
+		p.info.ImportLines[imp] = line
+		p.endTopLevel()
+
+	case p.is("option"):
+		p.info.Options = append(p.info.Options, p.parseOption())
+
+	case p.is("message"):
+		p.info.Messages = append(p.info.Messages, p.parseMessage())
+
+	case p.is("enum"):
+		p.info.Enums = append(p.info.Enums, p.parseEnum())
+
+	case p.is("service"):
+		p.info.Services = append(p.info.Services, p.parseService())
+
+	case p.is("extend"):
+		p.info.Extends = append(p.info.Extends, p.parseExtend())
+
+	default:
+		p.errorf(t, "unexpected %s", t)
+	}
+	return nil
+}
+
+// endTopLevel consumes the semicolon at the end of a top-level statement.
+// Unlike protoc, a missing semicolon is tolerated, as it was by Gazelle's
+// earlier regular expression based parser.
+func (p *protoParser) endTopLevel() {
+	if p.is(";") {
+		p.next()
+	}
+}
+
+// skipTopLevel skips a top-level statement with a syntax error. It skips
+// tokens up to a semicolon outside braces, or a block enclosed in braces. At
+// least one token is skipped.
+func (p *protoParser) skipTopLevel() {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == eofToken:
+			return
+		case t.kind == punctToken && t.text == "{":
+			depth++
+		case t.kind == punctToken && t.text == "}":
+			depth--
+			if depth <= 0 {
+				return
+			}
+		case t.kind == punctToken && t.text == ";" && depth == 0:
+			return
+		}
+	}
+}
+
+// parseOption parses an option statement. The value of a string option is
+// unquoted. Other values, including message literals, are recorded as they
+// appear in the source.
+func (p *protoParser) parseOption() Option {
+	p.expect("option")
+	var key strings.Builder
+	if p.is("(") {
+		p.next()
+		key.WriteString("(")
+		key.WriteString(p.parseFullIdent(true))
+		p.expect(")")
+		key.WriteString(")")
+	} else {
+		key.WriteString(p.parseIdent())
+	}
+	for p.is(".") {
+		p.next()
+		key.WriteString(".")
+		key.WriteString(p.parseIdent())
+	}
+	p.expect("=")
+	value := p.parseConstant()
+	p.expect(";")
+	return Option{Key: key.String(), Value: value}
+}
+
+func (p *protoParser) parseConstant() string {
+	t := p.peek()
+	switch {
+	case t.kind == stringToken:
+		return p.parseString()
+	case t.kind == identToken || t.kind == numberToken:
+		p.next()
+		return t.text
+	case p.is("-") || p.is("+"):
+		p.next()
+		v := p.next()
+		if v.kind != identToken && v.kind != numberToken {
+			p.errorf(v, "expected number; found %s", v)
+		}
+		return t.text + v.text
+	case p.is("{"):
+		p.skipBlock()
+		end := p.toks[p.i-1].end
+		return string(p.content[t.start:end])
+	default:
+		p.errorf(t, "expected constant; found %s", t)
+		return ""
+	}
+}
+
+// skipBlock skips a block enclosed in braces, including nested blocks.
+func (p *protoParser) skipBlock() {
+	p.expect("{")
+	depth := 1
+	for depth > 0 {
+		t := p.next()
+		switch {
+		case t.kind == eofToken:
+			p.errorf(t, "expected \"}\"; found %s", t)
+		case t.kind == punctToken && t.text == "{":
+			depth++
+		case t.kind == punctToken && t.text == "}":
+			depth--
+		}
+	}
+}
+
+// skipStatement skips a field, reserved, extensions, or option statement
+// inside a block. A statement ends with a semicolon, or with a block for
+// groups. Brackets and braces in field options are balanced. The name of a
+// field (the identifier before "=") is returned if there is one.
+func (p *protoParser) skipStatement() (fieldName string) {
+	var brackets int
+	var prev protoToken
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == eofToken:
+			p.errorf(t, "expected \";\"; found %s", t)
+		case p.is("}") && brackets == 0:
+			p.errorf(t, "expected \";\"; found %s", t)
+		case p.is("{") && brackets == 0:
+			// A group or an aggregate option value.
+			p.skipBlock()
+			if p.is(";") {
+				p.next()
+			}
+			return fieldName
+		case p.is("{"):
+			p.skipBlock()
+			prev = p.toks[p.i-1]
+			continue
+		case p.is("["):
+			brackets++
+		case p.is("]"):
+			brackets--
+		case p.is(";") && brackets == 0:
+			p.next()
+			return fieldName
+		case p.is("=") && brackets == 0 && fieldName == "" && prev.kind == identToken:
+			fieldName = prev.text
+		}
+		prev = p.next()
+	}
+}
+
+func (p *protoParser) parseMessage() Message {
+	p.expect("message")
+	m := Message{Name: p.parseIdent()}
+	p.expect("{")
+	for !p.is("}") {
+		switch {
+		case p.is(";"):
+			p.next()
+		case p.is("message"):
+			m.Messages = append(m.Messages, p.parseMessage())
+		case p.is("enum"):
+			m.Enums = append(m.Enums, p.parseEnum())
+		case p.is("extend"):
+			m.Extends = append(m.Extends, p.parseExtend())
+		case p.is("oneof"):
+			p.next()
+			p.parseIdent()
+			p.skipBlock()
+		default:
+			p.skipStatement()
+		}
+	}
+	p.expect("}")
+	return m
+}
+
+func (p *protoParser) parseEnum() Enum {
+	p.expect("enum")
+	e := Enum{Name: p.parseIdent()}
+	p.expect("{")
+	for !p.is("}") {
+		switch {
+		case p.is(";"):
+			p.next()
+		case p.is("option") || p.is("reserved"):
+			p.skipStatement()
+		default:
+			if name := p.skipStatement(); name != "" {
+				e.Values = append(e.Values, name)
+			}
+		}
+	}
+	p.expect("}")
+	return e
+}
+
+func (p *protoParser) parseService() Service {
+	p.expect("service")
+	s := Service{Name: p.parseIdent()}
+	p.expect("{")
+	for !p.is("}") {
+		switch {
+		case p.is(";"):
+			p.next()
+		case p.is("option"):
+			p.skipStatement()
+		case p.is("rpc"):
+			s.Methods = append(s.Methods, p.parseMethod())
+		default:
+			t := p.peek()
+			p.errorf(t, "unexpected %s in service", t)
+		}
+	}
+	p.expect("}")
+	return s
+}
+
+func (p *protoParser) parseMethod() Method {
+	p.expect("rpc")
+	m := Method{Name: p.parseIdent()}
+	parseType := func() (string, bool) {
+		p.expect("(")
+		stream := false
+		if p.is("stream") && p.toks[p.i+1].text != ")" {
+			p.next()
+			stream = true
+		}
+		typ := p.parseFullIdent(true)
+		p.expect(")")
+		return typ, stream
+	}
+	m.InputType, m.ClientStreaming = parseType()
+	p.expect("returns")
+	m.OutputType, m.ServerStreaming = parseType()
+	if p.is("{") {
+		p.skipBlock()
+		if p.is(";") {
+			p.next()
+		}
+	} else {
+		p.expect(";")
+	}
+	return m
+}
+
+func (p *protoParser) parseExtend() Extend {
+	p.expect("extend")
+	e := Extend{Extendee: p.parseFullIdent(true)}
+	p.expect("{")
+	for !p.is("}") {
+		if p.is(";") {
+			p.next()
+			continue
+		}
+		if name := p.skipStatement(); name != "" {
+			e.Fields = append(e.Fields, name)
+		}
+	}
+	p.expect("}")
+	return e
+}
+
+// unquoteProtoString returns the value of a quoted string literal, following
+// the escape rules of the protobuf language: C-style character escapes,
+// octal escapes of up to three digits, hex escapes of up to two digits, and
+// \u and \U Unicode escapes.
+func unquoteProtoString(q string) (string, error) {
+	if len(q) < 2 || q[0] != q[len(q)-1] || (q[0] != '"' && q[0] != '\'') {
+		return "", errors.New("missing quotes")
+	}
+	q = q[1 : len(q)-1]
+	var sb strings.Builder
+	for i := 0; i < len(q); {
+		c := q[i]
+		i++
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		if i == len(q) {
+			return "", errors.New("escape at end of string")
+		}
+		c = q[i]
+		i++
+		switch c {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := int(c - '0')
+			for n := 1; n < 3 && i < len(q) && '0' <= q[i] && q[i] <= '7'; n++ {
+				v = v*8 + int(q[i]-'0')
+				i++
+			}
+			if v > 0xff {
+				return "", errors.New("octal escape out of range")
+			}
+			sb.WriteByte(byte(v))
+		case 'x', 'X':
+			v, n := 0, 0
+			for ; n < 2 && i < len(q) && isHexDigit(q[i]); n++ {
+				v = v*16 + hexValue(q[i])
+				i++
+			}
+			if n == 0 {
+				return "", errors.New("invalid hex escape")
+			}
+			sb.WriteByte(byte(v))
+		case 'u', 'U':
+			size := 4
+			if c == 'U' {
+				size = 8
+			}
+			if i+size > len(q) {
+				return "", errors.New("invalid unicode escape")
+			}
+			v, err := strconv.ParseUint(q[i:i+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(v)) {
+				return "", errors.New("invalid unicode escape")
+			}
+			i += size
+			sb.WriteRune(rune(v))
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
@@ -0,0 +1,292 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// protoPlugin describes a language-specific rule generated next to each
+// proto_library, like cc_proto_library. Plugins are enabled with the
+// proto_plugin directive, which sets the kind of rule to generate and the
+// file it's loaded from.
+type protoPlugin struct {
+	// kind is the kind of rule to generate.
+	kind string
+
+	// load is the label of the .bzl file the kind is loaded from.
+	load string
+
+	// namePattern is the name of the generated rule. "{name}" is replaced
+	// by the name of the proto_library without its "_proto" suffix.
+	namePattern string
+
+	// attr is the attribute that's set to the proto_library label. deps is
+	// set to a list containing the label; other attributes are set to the
+	// label itself.
+	attr string
+
+	// attrs are other attributes set on each generated rule.
+	attrs []pluginAttr
+
+	// importDeps indicates whether the deps attribute should list rules
+	// generated by the same plugin for proto_library rules imported by
+	// this one. This is needed by rules that don't use aspects.
+	importDeps bool
+
+	// grpc describes a rule generated for proto_library rules with services,
+	// like cc_grpc_library. It's nil if no such rule is needed.
+	grpc *protoPlugin
+}
+
+// pluginAttr is an attribute set on rules generated by a plugin. value is
+// a bool for "True" and "False", and a string otherwise.
+type pluginAttr struct {
+	name  string
+	value interface{}
+}
+
+// parseProtoPlugin parses the value of a proto_plugin directive: a kind
+// followed by key=value settings. The load setting is required.
+func parseProtoPlugin(value string) (*protoPlugin, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, errors.New("expected kind")
+	}
+	p := newProtoPlugin(fields[0])
+	var grpcFields []string
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("expected key=value; got %q", field)
+		}
+		if strings.HasPrefix(key, "grpc_") {
+			// Handled below, after grpc is known.
+			grpcFields = append(grpcFields, field)
+			continue
+		}
+		switch {
+		case key == "name":
+			p.namePattern = value
+		case key == "attr":
+			p.attr = value
+		case key == "load":
+			p.load = value
+		case key == "import_deps":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("import_deps: %v", err)
+			}
+			p.importDeps = b
+		case key == "grpc":
+			p.grpc = newProtoPlugin(value)
+		case strings.HasPrefix(key, "set."):
+			p.attrs = append(p.attrs, parsePluginAttr(strings.TrimPrefix(key, "set."), value))
+		default:
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	for _, field := range grpcFields {
+		key, value, _ := strings.Cut(field, "=")
+		if p.grpc == nil {
+			return nil, fmt.Errorf("%s requires grpc", key)
+		}
+		switch key = strings.TrimPrefix(key, "grpc_"); {
+		case key == "name":
+			p.grpc.namePattern = value
+		case key == "load":
+			p.grpc.load = value
+		case strings.HasPrefix(key, "set."):
+			p.grpc.attrs = append(p.grpc.attrs, parsePluginAttr(strings.TrimPrefix(key, "set."), value))
+		default:
+			return nil, fmt.Errorf("unknown setting %q", "grpc_"+key)
+		}
+	}
+
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if p.importDeps && p.attr == "deps" {
+		return nil, fmt.Errorf("import_deps requires attr to be set to an attribute other than deps")
+	}
+	if p.grpc != nil {
+		if p.grpc.load == "" {
+			p.grpc.load = p.load
+		}
+		if err := p.grpc.check(); err != nil {
+			return nil, fmt.Errorf("grpc: %v", err)
+		}
+		if p.grpc.kind == p.kind {
+			return nil, fmt.Errorf("grpc must be different from the kind")
+		}
+		if p.grpc.namePattern == p.namePattern {
+			return nil, fmt.Errorf("grpc_name must be different from name")
+		}
+	}
+	return p, nil
+}
+
+// newProtoPlugin returns a plugin for the given kind with default settings.
+func newProtoPlugin(kind string) *protoPlugin {
+	return &protoPlugin{
+		kind:        kind,
+		namePattern: "{name}_" + strings.TrimSuffix(kind, "_library"),
+		attr:        "deps",
+	}
+}
+
+func parsePluginAttr(name, value string) pluginAttr {
+	switch value {
+	case "True":
+		return pluginAttr{name: name, value: true}
+	case "False":
+		return pluginAttr{name: name, value: false}
+	default:
+		return pluginAttr{name: name, value: value}
+	}
+}
+
+// check reports an error if the kind or load of p aren't valid.
+func (p *protoPlugin) check() error {
+	if _, ok := protoKinds[p.kind]; ok {
+		return fmt.Errorf("kind %q can't be generated by a plugin", p.kind)
+	}
+	if p.load == "" {
+		return fmt.Errorf("load is required for %s", p.kind)
+	}
+	if _, err := label.Parse(p.load); err != nil {
+		return fmt.Errorf("load: %v", err)
+	}
+	return nil
+}
+
+// mappedKinds returns the kinds generated by plugins, mapped from the
+// placeholder kinds in protoKinds, so that Gazelle merges, loads and resolves
+// them like proto rules.
+func mappedKinds(plugins []*protoPlugin) []config.MappedKind {
+	var mks []config.MappedKind
+	for _, p := range plugins {
+		mks = append(mks, config.MappedKind{FromKind: protoPluginKind, KindName: p.kind, KindLoad: p.load})
+		if p.grpc != nil {
+			mks = append(mks, config.MappedKind{FromKind: grpcPluginKind, KindName: p.grpc.kind, KindLoad: p.grpc.load})
+		}
+	}
+	return mks
+}
+
+func (p *protoPlugin) ruleName(protoName string) string {
+	return strings.ReplaceAll(p.namePattern, "{name}", strings.TrimSuffix(protoName, "_proto"))
+}
+
+// pluginImports is stored in the imports attribute of rules generated by
+// plugins with importDeps set. It contains the proto imports of the
+// corresponding proto_library.
+type pluginImports struct {
+	plugin  *protoPlugin
+	imports []string
+}
+
+// generatePluginRules generates rules for each configured plugin for the
+// proto_library rule r. If r is empty, empty rules are returned so that
+// existing rules are deleted.
+func generatePluginRules(pc *ProtoConfig, r *rule.Rule, hasServices, isEmpty, shouldSetVisibility bool) (gen, empty []*rule.Rule) {
+	protoLabel := ":" + r.Name()
+	for _, p := range pc.plugins {
+		lr := rule.NewRule(p.kind, p.ruleName(r.Name()))
+		if isEmpty {
+			empty = append(empty, lr)
+		} else {
+			if p.attr == "deps" {
+				lr.SetAttr(p.attr, []string{protoLabel})
+			} else {
+				lr.SetAttr(p.attr, protoLabel)
+			}
+			setPluginAttrs(lr, p, shouldSetVisibility, r)
+			if p.importDeps {
+				imports, _ := r.PrivateAttr(config.GazelleImportsKey).([]string)
+				lr.SetPrivateAttr(config.GazelleImportsKey, pluginImports{plugin: p, imports: imports})
+			}
+			gen = append(gen, lr)
+		}
+
+		if p.grpc == nil {
+			continue
+		}
+		gr := rule.NewRule(p.grpc.kind, p.grpc.ruleName(r.Name()))
+		if isEmpty || !hasServices {
+			empty = append(empty, gr)
+			continue
+		}
+		gr.SetAttr("srcs", []string{protoLabel})
+		gr.SetAttr("deps", []string{":" + lr.Name()})
+		setPluginAttrs(gr, p.grpc, shouldSetVisibility, r)
+		gen = append(gen, gr)
+	}
+	return gen, empty
+}
+
+func setPluginAttrs(r *rule.Rule, p *protoPlugin, shouldSetVisibility bool, protoRule *rule.Rule) {
+	for _, a := range p.attrs {
+		r.SetAttr(a.name, a.value)
+	}
+	if shouldSetVisibility {
+		if vis := protoRule.AttrStrings("visibility"); len(vis) > 0 {
+			r.SetAttr("visibility", vis)
+		}
+	}
+}
+
+// resolvePluginDeps sets the deps attribute of a rule generated by a plugin
+// with importDeps set. Imports are resolved to proto_library rules in this
+// repository using the index, and the plugin's naming pattern is applied to
+// those labels. Other imports, like well-known types, are skipped, since the
+// names of the rules generated for them aren't known.
+func resolvePluginDeps(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imports pluginImports, from label.Label) {
+	r.DelAttr("deps")
+	depSet := make(map[string]bool)
+	for _, imp := range imports.imports {
+		l, err := resolveWithIndex(c, ix, imp, from)
+		if err == errSkipImport || err == errNotFound {
+			continue
+		} else if err != nil {
+			log.Print(err)
+			continue
+		}
+		if l.Repo != "" && l.Repo != c.RepoName {
+			continue
+		}
+		l.Name = imports.plugin.ruleName(l.Name)
+		depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+	}
+	if len(depSet) > 0 {
+		deps := make([]string, 0, len(depSet))
+		for dep := range depSet {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		r.SetAttr("deps", deps)
+	}
+}
@@ -32,6 +32,10 @@ import (
 )
 
 func (*protoLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if r.Kind() == protoPluginKind || r.Kind() == grpcPluginKind {
+		// Rules generated by plugins are found through their proto_library.
+		return nil
+	}
 	rel := f.Pkg
 	srcs := r.AttrStrings("srcs")
 	imports := make([]resolve.ImportSpec, len(srcs))
@@ -74,14 +78,19 @@ func (*protoLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
 	return nil
 }
 
-func (*protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, importsRaw interface{}, from label.Label) {
+func (pl *protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, importsRaw interface{}, from label.Label) {
 	if importsRaw == nil {
 		// may not be set in tests.
 		return
 	}
+	if pi, ok := importsRaw.(pluginImports); ok {
+		resolvePluginDeps(c, ix, r, pi, from)
+		return
+	}
 	imports := importsRaw.([]string)
 	r.DelAttr("deps")
 	depSet := make(map[string]bool)
+	resolved := make(map[string]label.Label)
 	for _, imp := range imports {
 		l, err := resolveProto(c, ix, r, imp, from)
 		if err == errSkipImport {
@@ -89,10 +98,16 @@ func (*protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remo
 		} else if err != nil {
 			log.Print(err)
 		} else {
+			resolved[imp] = l
 			l = l.Rel(from.Repo, from.Pkg)
 			depSet[l.String()] = true
 		}
 	}
+	var pkg *Package
+	if p, ok := r.PrivateAttr(PackageKey).(Package); ok {
+		pkg = &p
+	}
+	pl.graph.addEdges(from, pkg, resolved, c.Strict)
 	if len(depSet) > 0 {
 		deps := make([]string, 0, len(depSet))
 		for dep := range depSet {
@@ -118,7 +133,13 @@ func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp str
 		return l, nil
 	}
 
-	if l, ok := knownImports[imp]; ok && pc.Mode.ShouldUseKnownImports() {
+	// Known imports from user-provided files take precedence over built-in
+	// known imports.
+	l, ok := pc.knownImports.protoLabel(imp)
+	if !ok {
+		l, ok = knownImports[imp]
+	}
+	if ok && pc.Mode.ShouldUseKnownImports() {
 		if l.Equal(from) {
 			return label.NoLabel, errSkipImport
 		} else {
@@ -143,6 +164,14 @@ func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp str
 		return label.NoLabel, err
 	}
 
+	if l, ok := pc.externalImports[imp]; ok {
+		return l, nil
+	}
+
+	if l, ok := resolveBufDep(pc, imp); ok {
+		return l, nil
+	}
+
 	rel := path.Dir(imp)
 	if rel == "." {
 		rel = ""
@@ -172,11 +201,17 @@ func (*protoLang) CrossResolve(c *config.Config, ix *resolve.RuleIndex, imp reso
 	}
 	pc := GetProtoConfig(c)
 	if imp.Lang == "proto" && pc.Mode.ShouldUseKnownImports() {
+		if l, ok := pc.knownImports.protoGoLabel(imp.Imp); ok {
+			return []resolve.FindResult{{Label: l}}
+		}
 		if l, ok := knownProtoImports[imp.Imp]; ok {
 			return []resolve.FindResult{{Label: l}}
 		}
 	}
 	if imp.Lang == "go" && pc.Mode.ShouldUseKnownImports() {
+		if l, ok := pc.knownImports.goLabel(imp.Imp); ok {
+			return []resolve.FindResult{{Label: l}}
+		}
 		// These are commonly used libraries that depend on Well Known Types.
 		// They depend on the generated versions of these protos to avoid conflicts.
 		// However, since protoc-gen-go depends on these libraries, we generate
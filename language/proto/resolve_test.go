@@ -25,6 +25,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
@@ -507,6 +508,164 @@ func TestCrossResolve(t *testing.T) {
 	}
 }
 
+func TestResolveKnownImportsFile(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "third_party/known.csv",
+		Content: `# proto name,proto label,go import path,go proto label
+google/protobuf/any.proto,@my_protobuf//:any_proto,github.com/golang/protobuf/ptypes/any,@my_protobuf//:any_go_proto
+acme/api/api.proto,@acme_apis//api:api_proto,,
+`,
+	}})
+	defer cleanup()
+
+	c, lang, cexts := testConfig(t, dir)
+	f, err := rule.LoadData(filepath.Join(dir, "BUILD.bazel"), "", []byte("# gazelle:proto_known_imports third_party/known.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cext := range cexts {
+		cext.Configure(c, "", f)
+	}
+
+	from := label.New("", "test", "test_proto")
+	for _, tc := range []struct {
+		imp  string
+		want label.Label
+	}{
+		{imp: "google/protobuf/any.proto", want: label.New("my_protobuf", "", "any_proto")},
+		{imp: "acme/api/api.proto", want: label.New("acme_apis", "api", "api_proto")},
+		{imp: "google/protobuf/timestamp.proto", want: label.New("com_google_protobuf", "", "timestamp_proto")},
+	} {
+		got, err := resolveProto(c, nil, nil, tc.imp, from)
+		if err != nil {
+			t.Errorf("%s: %v", tc.imp, err)
+		} else if !got.Equal(tc.want) {
+			t.Errorf("%s: got %s; want %s", tc.imp, got, tc.want)
+		}
+	}
+
+	cr := lang.(resolve.CrossResolver)
+	wantGo := []resolve.FindResult{{Label: label.New("my_protobuf", "", "any_go_proto")}}
+	if got := cr.CrossResolve(c, nil, resolve.ImportSpec{Lang: "proto", Imp: "google/protobuf/any.proto"}, "go"); !reflect.DeepEqual(got, wantGo) {
+		t.Errorf("proto import: got %v; want %v", got, wantGo)
+	}
+	if got := cr.CrossResolve(c, nil, resolve.ImportSpec{Lang: "go", Imp: "github.com/golang/protobuf/ptypes/any"}, "go"); !reflect.DeepEqual(got, wantGo) {
+		t.Errorf("go import: got %v; want %v", got, wantGo)
+	}
+}
+
+func TestResolveExternalRepo(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "ext/api/BUILD.bazel",
+			Content: `
+proto_library(
+    name = "api_proto",
+    srcs = ["api.proto"],
+    strip_import_prefix = "/api",
+)
+`,
+		}, {
+			Path: "ext/BUILD",
+			Content: `
+proto_library(
+    name = "root_proto",
+    srcs = ["root.proto"],
+)
+`,
+		}, {
+			// Build files that can't be parsed are skipped.
+			Path:    "ext/broken/BUILD.bazel",
+			Content: "proto_library(\n",
+		}, {
+			// Directives in the main repository don't affect external labels.
+			Path: "ext/rel/BUILD.bazel",
+			Content: `
+proto_library(
+    name = "rel_proto",
+    srcs = ["rel.proto"],
+    strip_import_prefix = "rel",
+)
+`,
+		}, {
+			// Output, version control, and nested repository directories
+			// are not indexed.
+			Path:    "ext/bazel-out/x/BUILD.bazel",
+			Content: `proto_library(name = "out_proto", srcs = ["out.proto"])`,
+		}, {
+			Path:    "ext/.git/BUILD.bazel",
+			Content: `proto_library(name = "git_proto", srcs = ["git.proto"])`,
+		}, {
+			Path:    "ext/node_modules/BUILD.bazel",
+			Content: `proto_library(name = "node_proto", srcs = ["node.proto"])`,
+		}, {
+			Path: "ext/nested/MODULE.bazel",
+		}, {
+			Path:    "ext/nested/BUILD.bazel",
+			Content: `proto_library(name = "nested_proto", srcs = ["nested.proto"])`,
+		}, {
+			Path: "other.index",
+			Content: `# import label
+other/other.proto //other:other_proto
+shared/shared.proto @shared//:shared_proto
+`,
+		},
+	})
+	defer cleanup()
+
+	c, _, cexts := testConfig(t, dir)
+	f, err := rule.LoadData(filepath.Join(dir, "BUILD.bazel"), "", []byte(`
+# gazelle:proto_strip_import_prefix /foo
+# gazelle:proto_external_repo @ext dir=ext
+# gazelle:proto_external_repo other index=other.index
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cext := range cexts {
+		cext.Configure(c, "", f)
+	}
+
+	from := label.New("", "test", "test_proto")
+	for _, tc := range []struct {
+		imp  string
+		want label.Label
+	}{
+		{imp: "api.proto", want: label.New("ext", "api", "api_proto")},
+		{imp: "root.proto", want: label.New("ext", "", "root_proto")},
+		{imp: "rel.proto", want: label.New("ext", "rel", "rel_proto")},
+		{imp: "nested/nested.proto", want: label.New("", "nested", "nested_proto")},
+		{imp: "bazel-out/x/out.proto", want: label.New("", "bazel-out/x", "x_proto")},
+		{imp: ".git/git.proto", want: label.New("", ".git", "git_proto")},
+		{imp: "node_modules/node.proto", want: label.New("", "node_modules", "node_modules_proto")},
+		{imp: "other/other.proto", want: label.New("other", "other", "other_proto")},
+		{imp: "shared/shared.proto", want: label.New("shared", "", "shared_proto")},
+		{imp: "local/local.proto", want: label.New("", "local", "local_proto")},
+	} {
+		ix := resolve.NewRuleIndex(nil)
+		ix.Finish()
+		got, err := resolveProto(c, ix, nil, tc.imp, from)
+		if err != nil {
+			t.Errorf("%s: %v", tc.imp, err)
+		} else if !got.Equal(tc.want) {
+			t.Errorf("%s: got %s; want %s", tc.imp, got, tc.want)
+		}
+	}
+}
+
+func TestReadKnownImportsConflict(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "known.csv",
+		Content: `a/a.proto,//a:a_proto,,
+a/a.proto,//a:other_proto,,
+`,
+	}})
+	defer cleanup()
+	if _, err := readKnownImports(filepath.Join(dir, "known.csv")); err == nil || !strings.Contains(err.Error(), "multiple values") {
+		t.Errorf("got error %v; want error about multiple values", err)
+	}
+}
+
 func convertImportsAttr(r *rule.Rule) interface{} {
 	value := r.AttrStrings("_imports")
 	if value == nil {
@@ -516,6 +675,44 @@ func convertImportsAttr(r *rule.Rule) interface{} {
 	return value
 }
 
+func TestResolvePluginDeps(t *testing.T) {
+	c, lang, _ := testConfig(t, ".")
+	mrslv := make(mapResolver)
+	mrslv["proto_library"] = lang
+	ix := resolve.NewRuleIndex(mrslv.Resolver, []resolve.CrossResolver{lang.(resolve.CrossResolver)})
+	dep, err := rule.LoadData("dep/BUILD.bazel", "dep", []byte(`
+proto_library(
+    name = "dep_proto",
+    srcs = ["dep.proto"],
+)
+
+py_proto_library(
+    name = "dep_py_pb2",
+    proto = ":dep_proto",
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range dep.Rules {
+		ix.AddRule(c, r, dep)
+	}
+	ix.Finish()
+
+	p, err := parseProtoPlugin("py_proto_library load=@rules_python//python:proto.bzl name={name}_py_pb2 attr=proto import_deps=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rule.NewRule("py_proto_library", "foo_py_pb2")
+	imports := pluginImports{plugin: p, imports: []string{"dep/dep.proto", "google/protobuf/any.proto"}}
+	lang.Resolve(c, ix, nil, r, imports, label.New("", "test", r.Name()))
+	got := r.AttrStrings("deps")
+	want := []string{"//dep:dep_py_pb2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
 type mapResolver map[string]resolve.Resolver
 
 func (mr mapResolver) Resolver(r *rule.Rule, f string) resolve.Resolver {
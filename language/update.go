@@ -16,6 +16,8 @@ limitations under the License.
 package language
 
 import (
+	"context"
+
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
@@ -46,6 +48,11 @@ type UpdateReposArgs struct {
 	// Cache stores information fetched from the network and ensures that
 	// the same request isn't made multiple times.
 	Cache *repo.RemoteCache
+
+	// Context is canceled when Gazelle is interrupted or its -timeout
+	// expires. Commands should be killed when it's done. It may be nil,
+	// in which case context.Background() should be used.
+	Context context.Context
 }
 
 // UpdateReposResult contains return values for RepoUpdater.UpdateRepos.
@@ -100,6 +107,11 @@ type ImportReposArgs struct {
 	// Cache stores information fetched from the network and ensures that
 	// the same request isn't made multiple times.
 	Cache *repo.RemoteCache
+
+	// Context is canceled when Gazelle is interrupted or its -timeout
+	// expires. Commands should be killed when it's done. It may be nil,
+	// in which case context.Background() should be used.
+	Context context.Context
 }
 
 // ImportReposResult contains return values for RepoImporter.ImportRepos.
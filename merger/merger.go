@@ -41,7 +41,9 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 // Phase indicates which attributes should be merged in matching rules.
@@ -106,7 +108,23 @@ const UnstableInsertIndexKey = "_gazelle_insert_index"
 // If an attribute is marked with a "# keep" comment, it will not be merged.
 // If a rule is marked with a "# keep" comment, the whole rule will not
 // be modified.
+//
+// Similarly, attributes excluded by a "# gazelle:manage" comment above an
+// existing rule will not be modified, and a rule that becomes empty will not
+// be deleted if it still has such attributes.
 func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo) {
+	MergeFileWithRepoMapping(oldFile, emptyRules, genRules, phase, kinds, nil)
+}
+
+// MergeFileWithRepoMapping is like MergeFile, but before merging, labels in
+// generated rules that refer to the same targets as labels in the matching
+// existing rules are replaced with the existing label strings. Labels are
+// compared after converting canonical repository names to apparent names
+// with repoMapping, so build files may use either form without Gazelle
+// duplicating or rewriting dependencies. repoMapping may be nil, in which
+// case only different forms of labels in the main repository (like
+// "@@//pkg:name" and "//pkg:name") are recognized.
+func MergeFileWithRepoMapping(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo, repoMapping *label.RepoMapping) {
 	getMergeAttrs := func(r *rule.Rule) map[string]bool {
 		if phase == PreResolve {
 			return kinds[r.Kind()].MergeableAttrs
@@ -122,7 +140,7 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 				continue
 			}
 			rule.MergeRules(emptyRule, oldRule, getMergeAttrs(emptyRule), oldFile.Path)
-			if oldRule.IsEmpty(kinds[oldRule.Kind()]) {
+			if oldRule.IsEmpty(kinds[oldRule.Kind()]) && !oldRule.HasUnmanagedAttrs() {
 				oldRule.Delete()
 			}
 		}
@@ -157,6 +175,14 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 		}
 	}
 
+	// Spell labels in generated rules the way equivalent labels are spelled in
+	// the existing rules.
+	for i, genRule := range genRules {
+		if matchRules[i] != nil {
+			preserveEquivalentLabels(genRule, matchRules[i], getMergeAttrs(genRule), repoMapping)
+		}
+	}
+
 	// Merge generated rules with existing rules or append to the end of the file.
 	for i, genRule := range genRules {
 		if matchErrors[i] != nil {
@@ -194,6 +220,59 @@ func substituteRule(r *rule.Rule, substitutions map[string]string, info rule.Kin
 	}
 }
 
+// preserveEquivalentLabels replaces absolute labels in the given attributes
+// of gen with equivalent labels from the same attributes of old, so that
+// merging doesn't replace one form of a label with another.
+func preserveEquivalentLabels(gen, old *rule.Rule, attrs map[string]bool, repoMapping *label.RepoMapping) {
+	for attr := range attrs {
+		oldExpr, genExpr := old.Attr(attr), gen.Attr(attr)
+		if oldExpr == nil || genExpr == nil {
+			continue
+		}
+		existing := make(map[label.Label]string)
+		bzl.Walk(oldExpr, func(x bzl.Expr, _ []bzl.Expr) {
+			str, ok := x.(*bzl.StringExpr)
+			if !ok {
+				return
+			}
+			if key, ok := equivalentLabelKey(str.Value, repoMapping); ok {
+				if _, ok := existing[key]; !ok {
+					existing[key] = str.Value
+				}
+			}
+		})
+		if len(existing) == 0 {
+			continue
+		}
+		changed := false
+		genExpr = rule.MapExprStrings(genExpr, func(s string) string {
+			if key, ok := equivalentLabelKey(s, repoMapping); ok {
+				if e, ok := existing[key]; ok && e != s {
+					changed = true
+					return e
+				}
+			}
+			return s
+		})
+		if changed && genExpr != nil {
+			gen.SetAttr(attr, genExpr)
+		}
+	}
+}
+
+// equivalentLabelKey returns a label that's the same for all absolute label
+// strings referring to the same target. Relative labels are not matched.
+func equivalentLabelKey(s string, repoMapping *label.RepoMapping) (label.Label, bool) {
+	if !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "@") {
+		return label.NoLabel, false
+	}
+	l, err := label.Parse(s)
+	if err != nil {
+		return label.NoLabel, false
+	}
+	return repoMapping.Apparent(l), true
+}
+
 // Match searches for a rule that can be merged with x in rules.
 //
 // A rule is considered a match if its kind is equal to x's kind AND either its
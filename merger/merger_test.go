@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	golang "github.com/bazelbuild/bazel-gazelle/language/go"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
@@ -828,6 +829,103 @@ go_library(
         "lib.go",  # keep
     ],
 )
+`,
+	}, {
+		desc: "merge only managed attributes",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage srcs
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/old",
+    deps = ["//hand:written"],
+)
+`,
+		current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    cgo = True,
+    importpath = "example.com/new",
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage srcs
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    importpath = "example.com/old",
+    deps = ["//hand:written"],
+)
+`,
+	}, {
+		desc: "merge all but unmanaged attributes",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage -srcs
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/old",
+)
+`,
+		current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    importpath = "example.com/new",
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage -srcs
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/new",
+)
+`,
+	}, {
+		desc: "don't delete rule with unmanaged attributes",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage srcs,deps
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    data = ["data.txt"],
+)
+`,
+		empty: `go_library(name = "go_default_library")`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage srcs,deps
+go_library(
+    name = "go_default_library",
+    data = ["data.txt"],
+)
+`,
+	}, {
+		desc: "delete empty rule with only managed attributes",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:manage -data
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+)
+`,
+		empty: `go_library(name = "go_default_library")`,
+		expected: `
 `,
 	}, {
 		desc: "match and rename",
@@ -960,6 +1058,61 @@ func TestMergeFile(t *testing.T) {
 	}
 }
 
+func TestMergeFileWithRepoMapping(t *testing.T) {
+	previous := `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/lib",
+    deps = [
+        "@@//util",
+        "@@rules_go+//go/runfiles",
+    ],
+)
+`
+	current := `
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/lib",
+    deps = [
+        "//util",
+        "@io_bazel_rules_go//go/runfiles",
+        "@org_golang_x_mod//module",
+    ],
+)
+`
+	expected := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/lib",
+    deps = [
+        "@@//util",
+        "@@rules_go+//go/runfiles",
+        "@org_golang_x_mod//module",
+    ],
+)
+`
+	genFile, err := rule.LoadData(filepath.Join("current", "BUILD.bazel"), "", []byte(current))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := rule.LoadData(filepath.Join("previous", "BUILD.bazel"), "", []byte(previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoMapping := label.NewRepoMapping("", map[string]string{"rules_go": "io_bazel_rules_go"})
+	merger.MergeFileWithRepoMapping(f, nil, genFile.Rules, merger.PostResolve, testKinds, repoMapping)
+	merger.FixLoads(f, testLoads)
+	if got := string(f.Format()); got != expected {
+		t.Errorf("got %s; want %s", got, expected)
+	}
+}
+
 var (
 	testKinds map[string]rule.KindInfo
 	testLoads []rule.LoadInfo
@@ -17,6 +17,7 @@ package repo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -131,20 +132,29 @@ type Repo struct {
 // RemoteCache is no longer needed. RemoteCache may write files to a temporary
 // directory. This will delete them.
 func NewRemoteCache(knownRepos []Repo) (r *RemoteCache, cleanup func() error) {
+	return NewRemoteCacheContext(context.Background(), knownRepos)
+}
+
+// NewRemoteCacheContext is like NewRemoteCache, but commands run by the
+// default HeadCmd, ModInfo, and ModVersionInfo functions are killed when
+// ctx is done.
+func NewRemoteCacheContext(ctx context.Context, knownRepos []Repo) (r *RemoteCache, cleanup func() error) {
 	r = &RemoteCache{
 		RepoRootForImportPath: vcs.RepoRootForImportPath,
-		HeadCmd:               defaultHeadCmd,
 		root:                  remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 		remote:                remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 		head:                  remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 		mod:                   remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 		modVersion:            remoteCacheMap{cache: make(map[string]*remoteCacheEntry)},
 	}
+	r.HeadCmd = func(remote, vcs string) (string, error) {
+		return defaultHeadCmd(ctx, remote, vcs)
+	}
 	r.ModInfo = func(importPath string) (string, error) {
-		return defaultModInfo(r, importPath)
+		return defaultModInfo(ctx, r, importPath)
 	}
 	r.ModVersionInfo = func(modPath, query string) (string, string, error) {
-		return defaultModVersionInfo(r, modPath, query)
+		return defaultModVersionInfo(ctx, r, modPath, query)
 	}
 	for _, repo := range knownRepos {
 		r.root.cache[repo.GoPrefix] = &remoteCacheEntry{
@@ -229,8 +239,10 @@ func (r *RemoteCache) PopulateFromGoMod(goModPath string) (err error) {
 	if err != nil {
 		return err
 	}
+	// Only require directives are needed here. ParseLax ignores directives
+	// that are newer than the modfile package, like tool.
 	var versionFixer modfile.VersionFixer
-	f, err := modfile.Parse(goModPath, data, versionFixer)
+	f, err := modfile.ParseLax(goModPath, data, versionFixer)
 	if err != nil {
 		return err
 	}
@@ -386,7 +398,7 @@ func (r *RemoteCache) Head(remote, vcs string) (commit, tag string, err error) {
 	return value.commit, value.tag, nil
 }
 
-func defaultHeadCmd(remote, vcs string) (string, error) {
+func defaultHeadCmd(ctx context.Context, remote, vcs string) (string, error) {
 	switch vcs {
 	case "local":
 		return "", nil
@@ -397,10 +409,10 @@ func defaultHeadCmd(remote, vcs string) (string, error) {
 		if strings.HasPrefix(remote, "-") {
 			return "", fmt.Errorf("remote must not start with '-': %q", remote)
 		}
-		cmd := exec.Command("git", "ls-remote", remote, "HEAD")
-		out, err := cmd.Output()
+		cmd := exec.CommandContext(ctx, "git", "ls-remote", remote, "HEAD")
+		out, err := cmdOutput(ctx, cmd)
 		if err != nil {
-			return "", fmt.Errorf("git ls-remote for %s: %v", remote, cleanCmdError(err))
+			return "", fmt.Errorf("git ls-remote for %s: %w", remote, cleanCmdError(err))
 		}
 		ix := bytes.IndexByte(out, '\t')
 		if ix < 0 {
@@ -468,33 +480,33 @@ func (r *RemoteCache) Mod(importPath string) (modPath, name string, err error) {
 	return value.path, value.name, nil
 }
 
-func defaultModInfo(rc *RemoteCache, importPath string) (modPath string, err error) {
+func defaultModInfo(ctx context.Context, rc *RemoteCache, importPath string) (modPath string, err error) {
 	rc.initTmp()
 	if rc.tmpErr != nil {
 		return "", rc.tmpErr
 	}
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("finding module path for import %s: %v", importPath, cleanCmdError(err))
+			err = fmt.Errorf("finding module path for import %s: %w", importPath, cleanCmdError(err))
 		}
 	}()
 
 	goTool := findGoTool()
 	env := append(os.Environ(), "GO111MODULE=on")
 
-	cmd := exec.Command(goTool, "get", "-d", "--", importPath)
+	cmd := exec.CommandContext(ctx, goTool, "get", "-d", "--", importPath)
 	cmd.Dir = rc.tmpDir
 	cmd.Env = env
-	if _, err := cmd.Output(); err != nil {
+	if _, err := cmdOutput(ctx, cmd); err != nil {
 		return "", err
 	}
 
-	cmd = exec.Command(goTool, "list", "-find", "-f", "{{.Module.Path}}", "--", importPath)
+	cmd = exec.CommandContext(ctx, goTool, "list", "-find", "-f", "{{.Module.Path}}", "--", importPath)
 	cmd.Dir = rc.tmpDir
 	cmd.Env = env
-	out, err := cmd.Output()
+	out, err := cmdOutput(ctx, cmd)
 	if err != nil {
-		return "", fmt.Errorf("finding module path for import %s: %v", importPath, cleanCmdError(err))
+		return "", fmt.Errorf("finding module path for import %s: %w", importPath, cleanCmdError(err))
 	}
 	return strings.TrimSpace(string(out)), nil
 }
@@ -536,22 +548,22 @@ func (r *RemoteCache) ModVersion(modPath, query string) (name, version, sum stri
 	return name, value.version, value.sum, nil
 }
 
-func defaultModVersionInfo(rc *RemoteCache, modPath, query string) (version, sum string, err error) {
+func defaultModVersionInfo(ctx context.Context, rc *RemoteCache, modPath, query string) (version, sum string, err error) {
 	rc.initTmp()
 	if rc.tmpErr != nil {
 		return "", "", rc.tmpErr
 	}
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("finding module version and sum for %s@%s: %v", modPath, query, cleanCmdError(err))
+			err = fmt.Errorf("finding module version and sum for %s@%s: %w", modPath, query, cleanCmdError(err))
 		}
 	}()
 
 	goTool := findGoTool()
-	cmd := exec.Command(goTool, "mod", "download", "-json", "--", modPath+"@"+query)
+	cmd := exec.CommandContext(ctx, goTool, "mod", "download", "-json", "--", modPath+"@"+query)
 	cmd.Dir = rc.tmpDir
 	cmd.Env = append(os.Environ(), "GO111MODULE=on")
-	out, err := cmd.Output()
+	out, err := cmdOutput(ctx, cmd)
 	if err != nil {
 		return "", "", err
 	}
@@ -665,3 +677,13 @@ func cleanCmdError(err error) error {
 	}
 	return err
 }
+
+// cmdOutput runs cmd and returns its standard output. If ctx is done before
+// cmd finishes, ctx.Err() is returned instead of the error from killing cmd.
+func cmdOutput(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	out, err := cmd.Output()
+	if err != nil && ctx.Err() != nil {
+		return out, ctx.Err()
+	}
+	return out, err
+}
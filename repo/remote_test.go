@@ -16,6 +16,7 @@ limitations under the License.
 package repo
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
@@ -371,3 +372,24 @@ func TestModVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoteCacheContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rc, cleanup := NewRemoteCacheContext(ctx, nil)
+	defer func() {
+		if err := cleanup(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, _, err := rc.Mod("example.com/m"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Mod: got error %v; want %v", err, context.Canceled)
+	}
+	if _, _, _, err := rc.ModVersion("example.com/m", "latest"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ModVersion: got error %v; want %v", err, context.Canceled)
+	}
+	if _, _, err := rc.Head("https://example.com/m", "git"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Head: got error %v; want %v", err, context.Canceled)
+	}
+}
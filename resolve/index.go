@@ -16,6 +16,7 @@ limitations under the License.
 package resolve
 
 import (
+	"context"
 	"log"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -61,6 +62,30 @@ type Resolver interface {
 	Resolve(c *config.Config, ix *RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label)
 }
 
+// ContextResolver is an optional interface for Resolvers that may do slow
+// work when indexing or resolving rules, for example, running commands or
+// accessing the network. If a Resolver implements ContextResolver,
+// ImportsContext and ResolveContext are called instead of Imports and
+// Resolve. The context is canceled when Gazelle is interrupted or its
+// -timeout expires; implementations should stop work and return promptly.
+type ContextResolver interface {
+	// ImportsContext is like Resolver.Imports.
+	ImportsContext(ctx context.Context, c *config.Config, r *rule.Rule, f *rule.File) []ImportSpec
+
+	// ResolveContext is like Resolver.Resolve.
+	ResolveContext(ctx context.Context, c *config.Config, ix *RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label)
+}
+
+// ResolveRule resolves dependencies of r with rslv, calling ResolveContext
+// if rslv implements ContextResolver and Resolve otherwise.
+func ResolveRule(ctx context.Context, rslv Resolver, c *config.Config, ix *RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	if cr, ok := rslv.(ContextResolver); ok {
+		cr.ResolveContext(ctx, c, ix, rc, r, imports, from)
+	} else {
+		rslv.Resolve(c, ix, rc, r, imports, from)
+	}
+}
+
 // CrossResolver is an interface that language extensions can implement to provide
 // custom dependency resolution logic for other languages.
 type CrossResolver interface {
@@ -78,11 +103,18 @@ type RuleIndex struct {
 	importMap      map[ImportSpec][]*ruleRecord
 	mrslv          func(r *rule.Rule, pkgRel string) Resolver
 	crossResolvers []CrossResolver
+
+	// repoMapping is used to look up labels with canonical repository names.
+	// It's set from the configuration passed to AddRule.
+	repoMapping *label.RepoMapping
 }
 
 // ruleRecord contains information about a rule relevant to import indexing.
 type ruleRecord struct {
-	rule  *rule.Rule
+	rule *rule.Rule
+
+	// label is the label of the rule. The rule is indexed in labelMap by the
+	// equivalent label with an apparent repository name.
 	label label.Label
 	file  *rule.File
 
@@ -133,12 +165,22 @@ func NewRuleIndex(mrslv func(r *rule.Rule, pkgRel string) Resolver, exts ...inte
 //
 // AddRule may only be called before Finish.
 func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
+	ix.AddRuleContext(context.Background(), c, r, f)
+}
+
+// AddRuleContext is like AddRule, but ctx is passed to the resolver's
+// ImportsContext method if it implements ContextResolver.
+func (ix *RuleIndex) AddRuleContext(ctx context.Context, c *config.Config, r *rule.Rule, f *rule.File) {
 	var lang string
 	var imps []ImportSpec
 	if rslv := ix.mrslv(r, f.Pkg); rslv != nil {
 		lang = rslv.Name()
 		if passesLanguageFilter(c.Langs, lang) {
-			imps = rslv.Imports(c, r, f)
+			if cr, ok := rslv.(ContextResolver); ok {
+				imps = cr.ImportsContext(ctx, c, r, f)
+			} else {
+				imps = rslv.Imports(c, r, f)
+			}
 		}
 	}
 	// If imps == nil, the rule is not importable. If imps is the empty slice,
@@ -147,6 +189,7 @@ func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
 		return
 	}
 
+	ix.repoMapping = c.RepoMapping
 	record := &ruleRecord{
 		rule:       r,
 		label:      label.New(c.RepoName, f.Pkg, r.Name()),
@@ -154,12 +197,13 @@ func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
 		importedAs: imps,
 		lang:       lang,
 	}
-	if _, ok := ix.labelMap[record.label]; ok {
+	key := ix.repoMapping.Apparent(record.label)
+	if _, ok := ix.labelMap[key]; ok {
 		log.Printf("multiple rules found with label %s", record.label)
 		return
 	}
 	ix.rules = append(ix.rules, record)
-	ix.labelMap[record.label] = record
+	ix.labelMap[key] = record
 }
 
 // Finish constructs the import index and performs any other necessary indexing
@@ -173,6 +217,13 @@ func (ix *RuleIndex) Finish() {
 		ix.collectEmbeds(r)
 	}
 	ix.buildImportIndex()
+
+	// Rules and files aren't needed to find imports. Don't keep them alive,
+	// so build files can be freed as soon as they're emitted.
+	for _, r := range ix.rules {
+		r.rule = nil
+		r.file = nil
+	}
 }
 
 func (ix *RuleIndex) collectEmbeds(r *ruleRecord) {
@@ -216,8 +267,11 @@ func (ix *RuleIndex) buildImportIndex() {
 	}
 }
 
+// findRuleByLabel returns the rule with the given label. Labels that refer
+// to the same rule with canonical and apparent repository names are
+// considered equal.
 func (ix *RuleIndex) findRuleByLabel(label label.Label, from label.Label) (*ruleRecord, bool) {
-	label = label.Abs(from.Repo, from.Pkg)
+	label = ix.repoMapping.Apparent(label.Abs(from.Repo, from.Pkg))
 	r, ok := ix.labelMap[label]
 	return r, ok
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -124,3 +125,38 @@ func getTestLabel(t *testing.T, str string) label.Label {
 	}
 	return l
 }
+
+type testResolver struct{}
+
+func (testResolver) Name() string { return "test" }
+
+func (testResolver) Imports(c *config.Config, r *rule.Rule, f *rule.File) []ImportSpec {
+	return []ImportSpec{{Lang: "test", Imp: r.Name()}}
+}
+
+func (testResolver) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
+
+func (testResolver) Resolve(c *config.Config, ix *RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+}
+
+func TestFindRuleByLabelRepoMapping(t *testing.T) {
+	c := config.New()
+	c.RepoMapping = label.NewRepoMapping("my_module", map[string]string{"my_module": "my_module"})
+	ix := NewRuleIndex(func(r *rule.Rule, pkgRel string) Resolver { return testResolver{} })
+	f := rule.EmptyFile("a/BUILD.bazel", "a")
+	r := rule.NewRule("test_library", "lib")
+	r.Insert(f)
+	ix.AddRule(c, r, f)
+	ix.Finish()
+
+	from := label.New("", "b", "b")
+	for _, s := range []string{"//a:lib", "@//a:lib", "@@//a:lib", "@my_module//a:lib"} {
+		l, err := label.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ix.findRuleByLabel(l, from); !ok {
+			t.Errorf("%s: rule not found", s)
+		}
+	}
+}
@@ -147,6 +147,10 @@ func FlattenExpr(e bzl.Expr) bzl.Expr {
 		return true
 	}
 
+	if len(ps.selects) > 0 {
+		// Selects on other conditions don't contain platform-specific strings.
+		return e
+	}
 	if ps.generic != nil {
 		if !addList(ps.generic) {
 			return e
@@ -198,19 +202,23 @@ func stringValue(e bzl.Expr) string {
 }
 
 // platformStringsExprs is a set of sub-expressions that match the structure
-// of package.PlatformStrings. ExprFromValue produces expressions that
-// follow this structure for srcs, deps, and other attributes, so this matches
-// all non-scalar expressions generated by Gazelle.
+// of package.PlatformStrings and SelectStrings. ExprFromValue produces
+// expressions that follow this structure for srcs, deps, and other
+// attributes, so this matches all non-scalar expressions generated by Gazelle.
 //
 // The matched expression has the form:
 //
-// [] + select({}) + select({}) + select({})
+// [] + select({}) + select({}) + select({}) + ...
 //
-// The four collections may appear in any order, and some or all of them may
-// be omitted (all fields are nil for a nil expression).
+// The collections may appear in any order, and some or all of them may be
+// omitted (all fields are nil for a nil expression). Selects whose first
+// key names a known OS, architecture, or OS_arch pair are stored in os, arch,
+// and platform. Any number of selects on other conditions, like custom
+// config_setting rules, are stored in selects, in the order they appear.
 type platformStringsExprs struct {
 	generic            *bzl.ListExpr
 	os, arch, platform *bzl.DictExpr
+	selects            []*bzl.DictExpr
 }
 
 // extractPlatformStringsExprs matches an expression and attempts to extract
@@ -236,7 +244,8 @@ func extractPlatformStringsExprs(expr bzl.Expr) (platformStringsExprs, error) {
 		expr = binop.X
 	}
 
-	// Process each part. They may be in any order.
+	// Process each part. They may be in any order. parts is in reverse order,
+	// so other selects are prepended to preserve their order.
 	for _, part := range parts {
 		switch part := part.(type) {
 		case *bzl.ListExpr:
@@ -255,17 +264,21 @@ func extractPlatformStringsExprs(expr bzl.Expr) (platformStringsExprs, error) {
 				return platformStringsExprs{}, fmt.Errorf("expression could not be matched: select argument not dict")
 			}
 			var dict **bzl.DictExpr
+			isOther := false
 			for _, kv := range arg.List {
-				k, ok := kv.Key.(*bzl.StringExpr)
-				if !ok {
+				if _, ok := kv.Key.(*bzl.StringExpr); !ok {
 					return platformStringsExprs{}, fmt.Errorf("expression could not be matched: dict keys are not all strings")
 				}
+			}
+			for _, kv := range arg.List {
+				k := kv.Key.(*bzl.StringExpr)
 				if k.Value == "//conditions:default" {
 					continue
 				}
 				key, err := label.Parse(k.Value)
 				if err != nil {
-					return platformStringsExprs{}, fmt.Errorf("expression could not be matched: dict key is not label: %q", k.Value)
+					isOther = true
+					break
 				}
 				if KnownOSSet[key.Name] {
 					dict = &ps.os
@@ -277,11 +290,16 @@ func extractPlatformStringsExprs(expr bzl.Expr) (platformStringsExprs, error) {
 				}
 				osArch := strings.Split(key.Name, "_")
 				if len(osArch) != 2 || !KnownOSSet[osArch[0]] || !KnownArchSet[osArch[1]] {
-					return platformStringsExprs{}, fmt.Errorf("expression could not be matched: dict key contains unknown platform: %q", k.Value)
+					isOther = true
+					break
 				}
 				dict = &ps.platform
 				break
 			}
+			if isOther {
+				ps.selects = append([]*bzl.DictExpr{arg}, ps.selects...)
+				continue
+			}
 			if dict == nil {
 				// We could not identify the dict because it's empty or only contains
 				// //conditions:default. We'll call it the platform dict to avoid
@@ -328,6 +346,9 @@ func makePlatformStringsExpr(ps platformStringsExprs) bzl.Expr {
 	if ps.platform != nil {
 		parts = append(parts, makeSelect(ps.platform))
 	}
+	for _, dict := range ps.selects {
+		parts = append(parts, makeSelect(dict))
+	}
 
 	if len(parts) == 0 {
 		return nil
@@ -0,0 +1,140 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	bzl "github.com/bazelbuild/buildtools/build"
+	bt "github.com/bazelbuild/buildtools/tables"
+)
+
+// AttrFormat describes how the attributes of rules of a kind are written
+// when the rules are created or modified.
+type AttrFormat struct {
+	// Order lists attribute names in the order they should appear. The name
+	// "*" stands for attributes that aren't listed, which appear there in the
+	// default order. If Order doesn't contain "*", attributes that aren't
+	// listed appear after the listed attributes. If Order is empty, the
+	// default order is used.
+	Order []string
+
+	// Sorted lists the attributes whose values are sorted. Other attributes
+	// aren't sorted, even if buildifier would normally sort them. If Sorted
+	// is nil, the default attributes are sorted.
+	Sorted []string
+}
+
+// SetAttrFormat sets how attributes are ordered and sorted in rules of the
+// given kind that are created or modified in this file. Rules of that kind
+// that aren't modified are written as they were. SetAttrFormat should be
+// called before rules are edited.
+func (f *File) SetAttrFormat(kind string, format AttrFormat) {
+	if f.attrFormats == nil {
+		f.attrFormats = make(map[string]AttrFormat)
+	}
+	f.attrFormats[kind] = format
+}
+
+// format rewrites and formats the synced syntax tree. If attribute formats
+// were set, they're applied with a custom rewriter, and unmodified rules of
+// the formatted kinds are left alone.
+func (f *File) format() []byte {
+	if len(f.attrFormats) == 0 {
+		return bzl.Format(f.File)
+	}
+	var unmodified []*Rule
+	for _, r := range f.Rules {
+		if _, ok := f.attrFormats[r.Kind()]; ok && !r.modified && !r.deleted {
+			unmodified = append(unmodified, r)
+		}
+	}
+	leaveAlone := bzl.Comment{Token: "# buildifier: leave-alone"}
+	for _, r := range unmodified {
+		com := r.expr.Comment()
+		com.Before = append(com.Before, leaveAlone)
+	}
+	f.attrFormatRewriter().Rewrite(f.File)
+	for _, r := range unmodified {
+		com := r.expr.Comment()
+		com.Before = com.Before[:len(com.Before)-1]
+	}
+	return bzl.FormatWithoutRewriting(f.File)
+}
+
+// attrFormatRewriter returns a rewriter with buildifier's default tables,
+// extended with per-kind attribute priorities and sortable attributes from
+// f.attrFormats.
+func (f *File) attrFormatRewriter() *bzl.Rewriter {
+	copyMap := func(m map[string]bool) map[string]bool {
+		c := make(map[string]bool, len(m))
+		for k, v := range m {
+			c[k] = v
+		}
+		return c
+	}
+	w := &bzl.Rewriter{
+		IsLabelArg:                      bt.IsLabelArg,
+		LabelDenyList:                   bt.LabelDenylist,
+		IsSortableListArg:               bt.IsSortableListArg,
+		SortableDenylist:                copyMap(bt.SortableDenylist),
+		SortableAllowlist:               copyMap(bt.SortableAllowlist),
+		NamePriority:                    make(map[string]int, len(bt.NamePriority)),
+		StripLabelLeadingSlashes:        bt.StripLabelLeadingSlashes,
+		ShortenAbsoluteLabelsToRelative: bt.ShortenAbsoluteLabelsToRelative,
+	}
+	for k, v := range bt.NamePriority {
+		w.NamePriority[k] = v
+	}
+
+	// Default priorities are small numbers, so attributes listed before "*"
+	// are given priorities below all of them, and attributes listed after
+	// "*" are given priorities above all of them.
+	const offset = 1000
+	for kind, format := range f.attrFormats {
+		other := len(format.Order)
+		for i, key := range format.Order {
+			if key == "*" {
+				other = i
+				break
+			}
+		}
+		seen := make(map[string]bool, len(format.Order))
+		for i, key := range format.Order {
+			if key == "*" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if i < other {
+				w.NamePriority[kind+"."+key] = i - offset
+			} else {
+				w.NamePriority[kind+"."+key] = i + offset
+			}
+		}
+
+		if format.Sorted != nil {
+			sorted := make(map[string]bool, len(format.Sorted))
+			for _, key := range format.Sorted {
+				sorted[key] = true
+				w.SortableAllowlist[kind+"."+key] = true
+			}
+			for key := range bt.IsSortableListArg {
+				if !sorted[key] {
+					w.SortableDenylist[kind+"."+key] = true
+				}
+			}
+		}
+	}
+	return w
+}
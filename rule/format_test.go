@@ -0,0 +1,165 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetAttrFormat(t *testing.T) {
+	for _, tc := range []struct {
+		desc, src, want string
+		format          AttrFormat
+		edit            func(f *File)
+	}{
+		{
+			desc:   "order",
+			format: AttrFormat{Order: []string{"name", "srcs", "importpath", "*", "visibility", "deps", "tags"}},
+			src: `
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+)
+`,
+			edit: func(f *File) {
+				r := f.Rules[0]
+				r.SetAttr("tags", []string{"manual"})
+				r.SetAttr("deps", []string{"//b"})
+				r.SetAttr("visibility", []string{"//visibility:public"})
+				r.SetAttr("importpath", "example.com/a")
+				r.SetAttr("embedsrcs", []string{"a.txt"})
+			},
+			want: `
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/a",
+    embedsrcs = ["a.txt"],
+    visibility = ["//visibility:public"],
+    deps = ["//b"],
+    tags = ["manual"],
+)
+`,
+		}, {
+			desc:   "order_without_other",
+			format: AttrFormat{Order: []string{"deps", "name"}},
+			src: `
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+)
+`,
+			edit: func(f *File) {
+				f.Rules[0].SetAttr("deps", []string{"//b"})
+			},
+			want: `
+go_library(
+    deps = ["//b"],
+    name = "a",
+    srcs = ["a.go"],
+)
+`,
+		}, {
+			desc:   "unsorted",
+			format: AttrFormat{Sorted: []string{"deps"}},
+			src: `
+go_library(
+    name = "a",
+    srcs = [
+        "b.go",
+        "a.go",
+    ],
+)
+`,
+			edit: func(f *File) {
+				f.Rules[0].SetAttr("deps", []string{"//z", "//y"})
+			},
+			want: `
+go_library(
+    name = "a",
+    srcs = [
+        "b.go",
+        "a.go",
+    ],
+    deps = [
+        "//y",
+        "//z",
+    ],
+)
+`,
+		}, {
+			desc:   "unmodified",
+			format: AttrFormat{Order: []string{"srcs", "*"}},
+			src: `
+go_library(
+    visibility = ["//visibility:public"],
+    name = "a",
+    srcs = [
+        "b.go",
+        "a.go",
+    ],
+)
+
+go_library(
+    name = "b",
+    srcs = ["b.go"],
+)
+
+go_test(
+    srcs = ["a_test.go"],
+    name = "a_test",
+)
+`,
+			edit: func(f *File) {
+				f.Rules[1].SetAttr("deps", []string{"//c"})
+			},
+			want: `
+go_library(
+    visibility = ["//visibility:public"],
+    name = "a",
+    srcs = [
+        "b.go",
+        "a.go",
+    ],
+)
+
+go_library(
+    srcs = ["b.go"],
+    name = "b",
+    deps = ["//c"],
+)
+
+go_test(
+    name = "a_test",
+    srcs = ["a_test.go"],
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := LoadData(tc.desc+"/BUILD.bazel", tc.desc, []byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.SetAttrFormat("go_library", tc.format)
+			tc.edit(f)
+			if got, want := strings.TrimSpace(string(f.Format())), strings.TrimSpace(tc.want); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
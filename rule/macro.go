@@ -0,0 +1,250 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	"fmt"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// MacroInfo describes a macro called in build files that declares rules
+// Gazelle knows how to generate. Gazelle uses it to match, merge, and index
+// the declared rules as if they were called directly.
+type MacroInfo struct {
+	// Rules lists the rules each call to the macro declares.
+	Rules []MacroRuleInfo
+}
+
+// MacroRuleInfo describes a rule declared by a macro.
+type MacroRuleInfo struct {
+	// Kind is the kind of the declared rule.
+	Kind string
+
+	// Name is a pattern for the name of the declared rule. "{name}" is
+	// replaced with the name argument of the macro call. If Name is empty,
+	// the rule has the same name as the call.
+	Name string
+
+	// Attrs maps attributes of the declared rule to the arguments of the
+	// macro call that set them. Attributes that aren't listed can't be set
+	// through the macro; Gazelle drops them when writing the call. Each
+	// argument should set at most one attribute.
+	Attrs map[string]string
+}
+
+// ParseMacroInfo parses a list of macro rule descriptions in the form
+// kind(attr,attr=arg,...), for example:
+//
+//	go_library(srcs,deps=lib_deps) go_test(name={name}_test,srcs=test_srcs)
+//
+// An attribute without an argument is set by the argument of the same name.
+// The name attribute sets the pattern for the declared rule's name.
+func ParseMacroInfo(s string) (MacroInfo, error) {
+	var info MacroInfo
+	for _, field := range strings.Fields(s) {
+		open := strings.Index(field, "(")
+		if open <= 0 || !strings.HasSuffix(field, ")") {
+			return MacroInfo{}, fmt.Errorf("invalid rule %q: expected kind(attr=arg,...)", field)
+		}
+		mr := MacroRuleInfo{
+			Kind:  field[:open],
+			Attrs: make(map[string]string),
+		}
+		if args := field[open+1 : len(field)-1]; args != "" {
+			for _, arg := range strings.Split(args, ",") {
+				attr, value, _ := strings.Cut(arg, "=")
+				if value == "" {
+					value = attr
+				}
+				if attr == "" {
+					return MacroInfo{}, fmt.Errorf("invalid rule %q: empty attribute name", field)
+				}
+				if attr != "name" {
+					mr.Attrs[attr] = value
+				} else if value != "name" {
+					mr.Name = value
+				}
+			}
+		}
+		info.Rules = append(info.Rules, mr)
+	}
+	if len(info.Rules) == 0 {
+		return MacroInfo{}, fmt.Errorf("no rules")
+	}
+	return info, nil
+}
+
+// ExpandMacro returns new rules for the rules declared by call, which calls
+// a macro described by info. Attribute values are taken from the arguments
+// of the call; the expressions are shared, not copied. If call is marked
+// with a "# keep" comment, so are the returned rules. The returned rules
+// aren't part of any file.
+func ExpandMacro(call *Rule, info MacroInfo) []*Rule {
+	var keepComments []bzl.Comment
+	com := call.expr.Comment()
+	for _, c := range append(com.Before, com.Suffix...) {
+		if isKeepComment(c) {
+			keepComments = append(keepComments, c)
+		}
+	}
+
+	rules := make([]*Rule, 0, len(info.Rules))
+	for _, mr := range info.Rules {
+		name := call.Name()
+		if mr.Name != "" {
+			name = strings.ReplaceAll(mr.Name, "{name}", name)
+		}
+		r := NewRule(mr.Kind, name)
+		for attr, arg := range mr.Attrs {
+			a, ok := call.attrs[arg]
+			if !ok {
+				continue
+			}
+			r.attrs[attr] = attrValue{
+				expr: &bzl.AssignExpr{
+					Comments: a.expr.Comments,
+					LHS:      &bzl.Ident{Name: attr},
+					Op:       "=",
+					RHS:      a.expr.RHS,
+				},
+				val: a.val,
+			}
+		}
+		r.expr.Comment().Before = append([]bzl.Comment(nil), keepComments...)
+		r.comments = commentsFromExpr(r.expr)
+		r.updated = true
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// macroCall is a call to a macro that was replaced in a file's rules by the
+// rules it declares.
+type macroCall struct {
+	call  *Rule
+	info  MacroInfo
+	rules []*Rule
+}
+
+// ExpandMacros replaces calls to the given macros in f.Rules with the rules
+// they declare (see ExpandMacro), so the declared rules can be matched,
+// merged, and indexed like other rules. The declared rules are inserted
+// into the syntax tree temporarily. CollapseMacros must be called before
+// the file is formatted or saved; it removes the declared rules and writes
+// their attributes back to the macro calls.
+func (f *File) ExpandMacros(macros map[string]MacroInfo) {
+	if len(macros) == 0 {
+		return
+	}
+	f.Sync()
+	var expanded []*macroCall
+	rules := f.Rules[:0]
+	for _, r := range f.Rules {
+		info, ok := macros[r.Kind()]
+		if !ok || r.Name() == "" {
+			rules = append(rules, r)
+			continue
+		}
+		expanded = append(expanded, &macroCall{call: r, info: info, rules: ExpandMacro(r, info)})
+	}
+	f.Rules = rules
+	for _, mc := range expanded {
+		for _, r := range mc.rules {
+			r.InsertAt(f, mc.call.index)
+		}
+	}
+	f.macroCalls = append(f.macroCalls, expanded...)
+	f.Sync()
+}
+
+// CollapseMacros reverses ExpandMacros. Attributes of the declared rules are
+// written back to the arguments of the macro calls, unless the calls are
+// marked with "# keep" comments or the arguments aren't managed by Gazelle
+// (see Rule.ShouldManageAttr). Attributes of deleted rules are written back
+// too, so arguments removed while merging empty rules are removed from the
+// calls. A call is deleted if all the rules it declares were deleted and it
+// has no unmanaged arguments.
+func (f *File) CollapseMacros() {
+	if len(f.macroCalls) == 0 {
+		return
+	}
+	f.Sync()
+	stmts := f.File.Stmt
+	if f.function != nil {
+		stmts = f.function.stmt.Body
+	}
+	indexes := make(map[bzl.Expr]int, len(stmts))
+	for i, stmt := range stmts {
+		indexes[stmt] = i
+	}
+
+	for _, mc := range f.macroCalls {
+		call := mc.call
+		deleted := true
+		for _, r := range mc.rules {
+			deleted = deleted && r.deleted
+		}
+		call.index = indexes[call.expr]
+		if deleted && !call.ShouldKeep() && !call.HasUnmanagedAttrs() {
+			call.Delete()
+		} else if !call.ShouldKeep() {
+			for i, r := range mc.rules {
+				collapseAttrs(call, r, mc.info.Rules[i].Attrs)
+			}
+		}
+		for _, r := range mc.rules {
+			r.Delete()
+		}
+		f.Rules = append(f.Rules, call)
+	}
+	f.macroCalls = nil
+	f.Sync()
+}
+
+// collapseAttrs writes the attributes of r, a rule declared by call, back to
+// the arguments of call according to attrs.
+func collapseAttrs(call, r *Rule, attrs map[string]string) {
+	for attr, arg := range attrs {
+		if !call.ShouldManageAttr(arg) {
+			continue
+		}
+		ra, ok := r.attrs[attr]
+		ca, hasArg := call.attrs[arg]
+		switch {
+		case !ok && hasArg:
+			call.DelAttr(arg)
+		case !ok:
+		case !hasArg:
+			call.attrs[arg] = attrValue{
+				expr: &bzl.AssignExpr{
+					Comments: ra.expr.Comments,
+					LHS:      &bzl.Ident{Name: arg},
+					Op:       "=",
+					RHS:      ra.expr.RHS,
+				},
+				val: ra.val,
+			}
+			call.updated = true
+		case ca.expr.RHS != ra.expr.RHS:
+			ca.expr.RHS = ra.expr.RHS
+			ca.val = ra.val
+			call.attrs[arg] = ca
+			call.updated = true
+		}
+	}
+}
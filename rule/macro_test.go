@@ -0,0 +1,125 @@
+/* Copyright 2024 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandCollapseMacros(t *testing.T) {
+	info, err := ParseMacroInfo("go_library(srcs,deps=lib_deps) go_test(name={name}_test,srcs=test_srcs)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	macros := map[string]MacroInfo{"go_package": info}
+
+	for _, tc := range []struct {
+		desc, src, want string
+		edit            func(t *testing.T, f *File)
+	}{
+		{
+			desc: "update",
+			src: `
+load("//:go.bzl", "go_package")
+
+go_package(
+    name = "a",
+    srcs = ["old.go"],
+    lib_deps = ["//b"],  # keep
+    test_srcs = ["a_test.go"],
+    visibility = ["//visibility:public"],
+)
+`,
+			edit: func(t *testing.T, f *File) {
+				var names []string
+				for _, r := range f.Rules {
+					names = append(names, r.Kind()+":"+r.Name())
+				}
+				if got, want := strings.Join(names, " "), "go_library:a go_test:a_test"; got != want {
+					t.Fatalf("got rules %s; want %s", got, want)
+				}
+				if !ShouldKeep(f.Rules[0].attrs["deps"].expr) {
+					t.Errorf("deps lost its keep comment")
+				}
+				f.Rules[0].SetAttr("srcs", []string{"a.go"})
+				f.Rules[1].DelAttr("srcs")
+				f.Rules[1].SetAttr("embed", []string{":a"})
+			},
+			want: `
+load("//:go.bzl", "go_package")
+
+go_package(
+    name = "a",
+    srcs = ["a.go"],
+    lib_deps = ["//b"],  # keep
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			desc: "delete",
+			src: `
+load("//:go.bzl", "go_package")
+
+go_package(
+    name = "a",
+    srcs = ["a.go"],
+)
+
+go_package(
+    name = "b",
+    srcs = ["b.go"],
+)  # keep
+`,
+			edit: func(t *testing.T, f *File) {
+				for _, r := range f.Rules {
+					r.Delete()
+				}
+			},
+			want: `
+load("//:go.bzl", "go_package")
+
+go_package(
+    name = "b",
+    srcs = ["b.go"],
+)  # keep
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := LoadData("BUILD.bazel", "", []byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.ExpandMacros(macros)
+			tc.edit(t, f)
+			f.CollapseMacros()
+			got := strings.TrimSpace(string(f.Format()))
+			want := strings.TrimSpace(tc.want)
+			if got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseMacroInfo(t *testing.T) {
+	for _, s := range []string{"", "go_library", "(srcs)", "go_library(=deps)"} {
+		if _, err := ParseMacroInfo(s); err == nil {
+			t.Errorf("%q: got success; want error", s)
+		}
+	}
+}
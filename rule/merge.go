@@ -41,6 +41,10 @@ import (
 // marked with a "# keep" comment, values in the attribute not marked with
 // a "# keep" comment will be dropped. If the attribute is empty afterward,
 // it will be deleted.
+//
+// Attributes that dst.ShouldManageAttr reports are not managed by Gazelle
+// (because of a "# gazelle:manage" comment above dst) are not added, merged,
+// or deleted.
 func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 	if dst.ShouldKeep() {
 		return
@@ -48,7 +52,7 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 
 	// Process attributes that are in dst but not in src.
 	for key, dstAttr := range dst.attrs {
-		if _, ok := src.attrs[key]; ok || !mergeable[key] || ShouldKeep(dstAttr.expr) {
+		if _, ok := src.attrs[key]; ok || !mergeable[key] || ShouldKeep(dstAttr.expr) || !dst.ShouldManageAttr(key) {
 			continue
 		}
 		if mergedValue, err := mergeAttrValues(nil, &dstAttr); err != nil {
@@ -63,6 +67,9 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 
 	// Merge attributes from src into dst.
 	for key, srcAttr := range src.attrs {
+		if !dst.ShouldManageAttr(key) {
+			continue
+		}
 		if dstAttr, ok := dst.attrs[key]; !ok {
 			dst.SetAttr(key, srcAttr.expr.RHS)
 		} else if mergeable[key] && !ShouldKeep(dstAttr.expr) {
@@ -91,10 +98,15 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 //   * lists of strings
 //   * a call to select with a dict argument. The dict keys must be strings,
 //     and the values must be lists of strings.
-//   * a list of strings combined with a select call using +. The list must
-//     be the left operand.
+//   * a list of strings combined with any number of select calls using +.
 //   * an attr value that implements the Merger interface.
 //
+// Selects on platforms are merged with selects on the same kind of platform
+// (OS, architecture, or both). Selects on other conditions are merged with
+// the select in the other expression that has a condition in common. A
+// select in dst with no counterpart in src is merged with an empty select,
+// dropping values without "# keep" comments, like a list.
+//
 // An error is returned if the expressions can't be merged, for example
 // because they are not in one of the above formats.
 func mergeAttrValues(srcAttr, dstAttr *attrValue) (bzl.Expr, error) {
@@ -151,9 +163,74 @@ func mergePlatformStringsExprs(src, dst platformStringsExprs) (platformStringsEx
 	if ps.platform, err = MergeDict(src.platform, dst.platform); err != nil {
 		return platformStringsExprs{}, err
 	}
+	for _, pair := range matchSelects(src.selects, dst.selects) {
+		merged, err := MergeDict(pair.src, pair.dst)
+		if err != nil {
+			return platformStringsExprs{}, err
+		}
+		if merged != nil {
+			ps.selects = append(ps.selects, merged)
+		}
+	}
 	return ps, nil
 }
 
+// selectPair is a select dict in src and a select dict in dst that should be
+// merged or squashed. Either may be nil.
+type selectPair struct {
+	src, dst *bzl.DictExpr
+}
+
+// matchSelects pairs select dicts in src with select dicts in dst that have a
+// key in common, other than "//conditions:default". Dicts with no other keys
+// are paired with each other. Pairs are returned in the order of dst, followed
+// by src dicts that didn't match any dst dict.
+func matchSelects(src, dst []*bzl.DictExpr) []selectPair {
+	keys := func(d *bzl.DictExpr) map[string]bool {
+		m := make(map[string]bool)
+		for _, kv := range d.List {
+			if k, ok := kv.Key.(*bzl.StringExpr); ok && k.Value != "//conditions:default" {
+				m[k.Value] = true
+			}
+		}
+		return m
+	}
+	srcKeys := make([]map[string]bool, len(src))
+	for i, d := range src {
+		srcKeys[i] = keys(d)
+	}
+	matched := make([]bool, len(src))
+	pairs := make([]selectPair, 0, len(src)+len(dst))
+	for _, d := range dst {
+		pair := selectPair{dst: d}
+		dstKeys := keys(d)
+		for i := range src {
+			if matched[i] {
+				continue
+			}
+			overlap := len(srcKeys[i]) == 0 && len(dstKeys) == 0
+			for k := range srcKeys[i] {
+				if dstKeys[k] {
+					overlap = true
+					break
+				}
+			}
+			if overlap {
+				pair.src = src[i]
+				matched[i] = true
+				break
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+	for i, d := range src {
+		if !matched[i] {
+			pairs = append(pairs, selectPair{src: d})
+		}
+	}
+	return pairs
+}
+
 // MergeList merges two bzl.ListExpr of strings. The lists are merged in the
 // following way:
 //
@@ -379,6 +456,13 @@ func squashPlatformStringsExprs(x, y platformStringsExprs) (platformStringsExprs
 	if ps.platform, err = squashDict(x.platform, y.platform); err != nil {
 		return platformStringsExprs{}, err
 	}
+	for _, pair := range matchSelects(x.selects, y.selects) {
+		squashed, err := squashDict(pair.src, pair.dst)
+		if err != nil {
+			return platformStringsExprs{}, err
+		}
+		ps.selects = append(ps.selects, squashed)
+	}
 	return ps, nil
 }
 
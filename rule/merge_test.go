@@ -16,6 +16,7 @@ limitations under the License.
 package rule_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/bazelbuild/bazel-gazelle/rule"
@@ -147,3 +148,221 @@ func TestMergeRules_WithUnsortedStringAttr(t *testing.T) {
 		}
 	})
 }
+
+func TestMergeRules_WithSelects(t *testing.T) {
+	for _, tc := range []struct {
+		desc, src, dst, want string
+	}{
+		{
+			desc: "custom conditions",
+			src: `
+go_library(
+    name = "lib",
+    deps = select({
+        ":feature_x": ["//x"],
+        "//conditions:default": [],
+    }),
+)
+`,
+			dst: `
+go_library(
+    name = "lib",
+    deps = select({
+        ":feature_x": [
+            "//old",
+            "//kept",  # keep
+        ],
+        "//conditions:default": ["//y"],
+    }),
+)
+`,
+			want: `
+go_library(
+    name = "lib",
+    deps = select({
+        ":feature_x": [
+            "//kept",  # keep
+            "//x",
+        ],
+        "//conditions:default": [],
+    }),
+)
+`,
+		}, {
+			desc: "list and multiple selects",
+			src: `
+go_library(
+    name = "lib",
+    srcs = [
+        "a.go",
+    ] + select({
+        ":feature_x": ["x.go"],
+        "//conditions:default": [],
+    }) + select({
+        "@io_bazel_rules_go//go/platform:linux": ["linux.go"],
+        "//conditions:default": [],
+    }) + select({
+        "//flags:fast": ["fast.go"],
+        "//flags:slow": ["slow.go"],
+    }),
+)
+`,
+			dst: `
+go_library(
+    name = "lib",
+    srcs = [
+        "a.go",
+        "b.go",
+    ] + select({
+        "//flags:slow": ["slow.go"],
+        "//flags:old": ["old.go"],
+    }) + select({
+        ":feature_x": ["x.go"],
+        ":feature_y": ["y.go"],
+        "//conditions:default": [],
+    }) + select({
+        ":removed": ["removed.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+			want: `
+go_library(
+    name = "lib",
+    srcs = [
+        "a.go",
+    ] + select({
+        "@io_bazel_rules_go//go/platform:linux": ["linux.go"],
+        "//conditions:default": [],
+    }) + select({
+        "//flags:fast": ["fast.go"],
+        "//flags:slow": ["slow.go"],
+    }) + select({
+        ":feature_x": ["x.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		}, {
+			desc: "new select",
+			src: `
+go_library(
+    name = "lib",
+    srcs = ["a.go"] + select({
+        ":feature_x": ["x.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+			dst: `
+go_library(
+    name = "lib",
+    srcs = ["a.go"],
+)
+`,
+			want: `
+go_library(
+    name = "lib",
+    srcs = [
+        "a.go",
+    ] + select({
+        ":feature_x": ["x.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			src, err := rule.LoadData("src/BUILD.bazel", "", []byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst, err := rule.LoadData("dst/BUILD.bazel", "", []byte(tc.dst))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule.MergeRules(src.Rules[0], dst.Rules[0], map[string]bool{"srcs": true, "deps": true}, "")
+			if got, want := strings.TrimSpace(string(dst.Format())), strings.TrimSpace(tc.want); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSquashRules_WithSelects(t *testing.T) {
+	f, err := rule.LoadData("BUILD.bazel", "", []byte(`
+go_library(
+    name = "a",
+    srcs = ["a.go"] + select({
+        ":feature_x": ["x.go"],
+        "//conditions:default": [],
+    }),
+)
+
+go_library(
+    name = "b",
+    srcs = ["b.go"] + select({
+        ":feature_x": ["x2.go"],
+        "//conditions:default": [],
+    }) + select({
+        ":feature_y": ["y.go"],
+        "//conditions:default": [],
+    }),
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rule.SquashRules(f.Rules[1], f.Rules[0], f.Path); err != nil {
+		t.Fatal(err)
+	}
+	f.Rules[1].Delete()
+	want := `go_library(
+    name = "a",
+    srcs = [
+        "a.go",
+        "b.go",
+    ] + select({
+        ":feature_x": [
+            "x.go",
+            "x2.go",
+        ],
+        "//conditions:default": [],
+    }) + select({
+        ":feature_y": ["y.go"],
+        "//conditions:default": [],
+    }),
+)
+`
+	if got := string(f.Format()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSelectStrings(t *testing.T) {
+	r := rule.NewRule("go_library", "lib")
+	r.SetAttr("srcs", rule.SelectStrings{
+		Generic: []string{"a.go"},
+		Selects: []rule.SelectStringListValue{
+			{":feature_x": {"x.go"}, "//conditions:default": nil},
+		},
+	})
+	f := rule.EmptyFile("BUILD.bazel", "")
+	r.Insert(f)
+	want := `go_library(
+    name = "lib",
+    srcs = [
+        "a.go",
+    ] + select({
+        ":feature_x": [
+            "x.go",
+        ],
+        "//conditions:default": [],
+    }),
+)
+`
+	if got := string(f.Format()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
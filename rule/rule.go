@@ -77,6 +77,12 @@ type File struct {
 	// is modified outside of Rule methods, Content must be manually updated in
 	// order to keep it in sync.
 	Content []byte
+
+	// attrFormats maps rule kinds to formats set with SetAttrFormat.
+	attrFormats map[string]AttrFormat
+
+	// macroCalls lists macro calls replaced by ExpandMacros.
+	macroCalls []*macroCall
 }
 
 // EmptyFile creates a File wrapped around an empty syntax tree.
@@ -326,6 +332,9 @@ func (f *File) Sync() {
 	var ruleInserts, ruleDeletes, ruleStmts []*stmt
 	for r, w = 0, 0; r < len(f.Rules); r++ {
 		s := f.Rules[r]
+		if format, ok := f.attrFormats[s.Kind()]; ok && format.Sorted != nil {
+			s.sortedAttrs = format.Sorted
+		}
 		s.sync()
 		if s.deleted {
 			ruleDeletes = append(ruleDeletes, &s.stmt)
@@ -403,7 +412,7 @@ func updateStmt(oldStmt *[]bzl.Expr, inserts, deletes, stmts []*stmt) {
 // This method calls Sync internally.
 func (f *File) Format() []byte {
 	f.Sync()
-	return bzl.Format(f.File)
+	return f.format()
 }
 
 // SortMacro sorts rules and loads in the macro of this File. It doesn't sort the rules if
@@ -423,7 +432,7 @@ func (f *File) SortMacro() {
 // Save writes the build file to disk. This method calls Sync internally.
 func (f *File) Save(path string) error {
 	f.Sync()
-	f.Content = bzl.Format(f.File)
+	f.Content = f.format()
 	return os.WriteFile(path, f.Content, 0o666)
 }
 
@@ -599,11 +608,23 @@ func loadFromExpr(index int, loadStmt *bzl.LoadStmt) *Load {
 	return l
 }
 
+// ShouldKeep returns whether the load statement is marked with a "# keep"
+// comment. Kept loads should not be modified.
+func (l *Load) ShouldKeep() bool {
+	return ShouldKeep(l.expr)
+}
+
 // Name returns the name of the file this statement loads.
 func (l *Load) Name() string {
 	return l.name
 }
 
+// SetName changes the name of the file this statement loads.
+func (l *Load) SetName(name string) {
+	l.name = name
+	l.updated = true
+}
+
 // Symbols returns a sorted list of symbols this statement loads.
 // If the symbol is loaded with a name different from its definition, the
 // loaded name is returned, not the original name.
@@ -730,6 +751,9 @@ type Rule struct {
 	attrs       map[string]attrValue
 	private     map[string]interface{}
 	sortedAttrs []string
+
+	// modified is set when the rule is synced after being created or changed.
+	modified bool
 }
 
 type attrValue struct {
@@ -864,6 +888,69 @@ func (r *Rule) ShouldKeep() bool {
 	return ShouldKeep(r.expr)
 }
 
+// ShouldManageAttr returns whether Gazelle may add, modify, or remove the
+// attribute key. All attributes are managed unless the rule is preceded by
+// a "# gazelle:manage" comment. The comment lists attributes separated by
+// commas. If any listed attribute has no "-" prefix, only those attributes
+// are managed; attributes with a "-" prefix are never managed. For example,
+// "# gazelle:manage srcs,deps" means only srcs and deps are managed, and
+// "# gazelle:manage -data" means every attribute except data is managed.
+//
+// This does not check "# keep" comments on the rule or the attribute.
+func (r *Rule) ShouldManageAttr(key string) bool {
+	managed, unmanaged, ok := r.manageComment()
+	if !ok {
+		return true
+	}
+	if unmanaged[key] {
+		return false
+	}
+	return managed == nil || managed[key]
+}
+
+// HasUnmanagedAttrs returns whether the rule has an attribute other than
+// name that Gazelle may not modify, according to ShouldManageAttr. Such rules
+// must not be deleted or squashed into other rules.
+func (r *Rule) HasUnmanagedAttrs() bool {
+	if _, _, ok := r.manageComment(); !ok {
+		return false
+	}
+	for key := range r.attrs {
+		if key != "name" && !r.ShouldManageAttr(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// manageComment parses "# gazelle:manage" comments above the rule. It
+// returns the attributes listed without a "-" prefix (nil if there were none),
+// the attributes listed with a "-" prefix, and whether any comment was found.
+func (r *Rule) manageComment() (managed, unmanaged map[string]bool, ok bool) {
+	for _, c := range r.expr.Comment().Before {
+		match := directiveRe.FindStringSubmatch(c.Token)
+		if match == nil || match[1] != "manage" {
+			continue
+		}
+		ok = true
+		if unmanaged == nil {
+			unmanaged = make(map[string]bool)
+		}
+		for _, key := range strings.Split(match[2], ",") {
+			key = strings.TrimSpace(key)
+			if strings.HasPrefix(key, "-") {
+				unmanaged[strings.TrimSpace(key[1:])] = true
+			} else if key != "" {
+				if managed == nil {
+					managed = make(map[string]bool)
+				}
+				managed[key] = true
+			}
+		}
+	}
+	return managed, unmanaged, ok
+}
+
 // Kind returns the kind of rule this is (for example, "go_library").
 func (r *Rule) Kind() string {
 	return bzl.FormatString(r.kind)
@@ -1079,6 +1166,7 @@ func (r *Rule) sync() {
 		return
 	}
 	r.updated = false
+	r.modified = true
 
 	for _, k := range r.sortedAttrs {
 		attr, ok := r.attrs[k]
@@ -1121,14 +1209,18 @@ func (r *Rule) sync() {
 // expressions should not be removed or modified.
 func ShouldKeep(e bzl.Expr) bool {
 	for _, c := range append(e.Comment().Before, e.Comment().Suffix...) {
-		text := strings.TrimSpace(strings.TrimPrefix(c.Token, "#"))
-		if text == "keep" || strings.HasPrefix(text, "keep: ") {
+		if isKeepComment(c) {
 			return true
 		}
 	}
 	return false
 }
 
+func isKeepComment(c bzl.Comment) bool {
+	text := strings.TrimSpace(strings.TrimPrefix(c.Token, "#"))
+	return text == "keep" || strings.HasPrefix(text, "keep: ")
+}
+
 // CheckInternalVisibility overrides the given visibility if the package is
 // internal.
 func CheckInternalVisibility(rel, visibility string) string {
@@ -447,6 +447,92 @@ func TestShouldKeepExpr(t *testing.T) {
 	}
 }
 
+func TestShouldManageAttr(t *testing.T) {
+	for _, tc := range []struct {
+		desc, src       string
+		managed         []string
+		unmanaged       []string
+		hasUnmanagedSet bool
+	}{
+		{
+			desc: "no_comment",
+			src: `
+x_library(
+    name = "x",
+    srcs = ["x.go"],
+)
+`,
+			managed: []string{"name", "srcs", "deps"},
+		}, {
+			desc: "listed",
+			src: `
+# gazelle:manage srcs, deps
+x_library(
+    name = "x",
+    srcs = ["x.go"],
+    data = ["x.txt"],
+)
+`,
+			managed:         []string{"srcs", "deps"},
+			unmanaged:       []string{"name", "data"},
+			hasUnmanagedSet: true,
+		}, {
+			desc: "excluded",
+			src: `
+# gazelle:manage -data
+x_library(
+    name = "x",
+    srcs = ["x.go"],
+)
+`,
+			managed:   []string{"name", "srcs", "deps"},
+			unmanaged: []string{"data"},
+		}, {
+			desc: "listed_and_excluded",
+			src: `
+# Hand-written data.
+# gazelle:manage srcs,data
+# gazelle:manage -data
+x_library(
+    name = "x",
+    data = ["x.txt"],
+)
+`,
+			managed:         []string{"srcs"},
+			unmanaged:       []string{"data", "deps"},
+			hasUnmanagedSet: true,
+		}, {
+			desc: "after",
+			src: `
+x_library(name = "x")
+# gazelle:manage srcs
+`,
+			managed: []string{"srcs", "deps"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := LoadData(filepath.Join(tc.desc, "BUILD.bazel"), "", []byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := f.Rules[0]
+			for _, key := range tc.managed {
+				if !r.ShouldManageAttr(key) {
+					t.Errorf("ShouldManageAttr(%q): got false; want true", key)
+				}
+			}
+			for _, key := range tc.unmanaged {
+				if r.ShouldManageAttr(key) {
+					t.Errorf("ShouldManageAttr(%q): got true; want false", key)
+				}
+			}
+			if got := r.HasUnmanagedAttrs(); got != tc.hasUnmanagedSet {
+				t.Errorf("HasUnmanagedAttrs: got %v; want %v", got, tc.hasUnmanagedSet)
+			}
+		})
+	}
+}
+
 func TestInternalVisibility(t *testing.T) {
 	tests := []struct {
 		rel      string
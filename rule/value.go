@@ -138,6 +138,51 @@ func (s SelectStringListValue) BzlExpr() bzl.Expr {
 	return sel
 }
 
+// SelectStrings is a value that can be translated to a list of strings
+// concatenated with any number of select expressions, for example:
+//
+//	["a.go"] + select({
+//	    ":feature_x": ["x.go"],
+//	    "//conditions:default": [],
+//	})
+//
+// Unlike PlatformStrings, the select keys may be any labels, such as
+// config_setting rules for feature flags or custom platforms. Values of this
+// type are merged with existing expressions the same way as PlatformStrings:
+// each select is merged with the existing select that has a key in common.
+// Each select should include a "//conditions:default" key, unless its
+// conditions cover every configuration.
+type SelectStrings struct {
+	// Generic is a list of strings that don't depend on the configuration.
+	Generic []string
+
+	// Selects is a list of selects, each mapping conditions to strings.
+	Selects []SelectStringListValue
+}
+
+func (s SelectStrings) BzlExpr() bzl.Expr {
+	var parts []bzl.Expr
+	if len(s.Generic) > 0 {
+		parts = append(parts, ExprFromValue(s.Generic))
+	}
+	for _, sel := range s.Selects {
+		if len(sel) > 0 {
+			parts = append(parts, sel.BzlExpr())
+		}
+	}
+	if len(parts) == 0 {
+		return &bzl.ListExpr{}
+	}
+	e := parts[0]
+	if list, ok := e.(*bzl.ListExpr); ok && len(parts) > 1 {
+		list.ForceMultiLine = true
+	}
+	for _, part := range parts[1:] {
+		e = &bzl.BinaryExpr{X: e, Y: part, Op: "+"}
+	}
+	return e
+}
+
 // ExprFromValue converts a value into an expression that can be written into
 // a Bazel build file. The following types of values can be converted:
 //
@@ -148,6 +193,7 @@ func (s SelectStringListValue) BzlExpr() bzl.Expr {
 //     @io_bazel_rules_go//go/platform).
 //   * GlobValue (converted to glob expressions).
 //   * PlatformStrings (converted to a concatenation of a list and selects).
+//   * SelectStrings (converted to a concatenation of a list and selects).
 //
 // Converting unsupported types will cause a panic.
 func ExprFromValue(val interface{}) bzl.Expr {
@@ -164,6 +164,14 @@ type TestGazelleGenerationArgs struct {
 
 	// Timeout is the duration after which the generation process will be killed.
 	Timeout time.Duration
+
+	// CheckIdempotency makes the test also check that running Gazelle again
+	// over the files it generates doesn't change them. Before running the
+	// test, Gazelle is run over the input files with -mode=idempotency, and
+	// the test fails if it reports any changes. Test cases where Gazelle is
+	// expected to exit with an error aren't checked. The arguments shouldn't
+	// set -mode.
+	CheckIdempotency bool
 }
 
 var (
@@ -327,6 +335,10 @@ Run %s to update BUILD.out and expected{Stdout,Stderr,ExitCode}.txt files.
 			}
 		}()
 
+		if args.CheckIdempotency && config.ExitCode == 0 {
+			checkIdempotency(t, args, config.Args, workspaceRoot)
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
 		defer cancel()
 		cmd := exec.CommandContext(ctx, args.GazelleBinaryPath, config.Args...)
@@ -375,6 +387,34 @@ Run %s to update BUILD.out and expected{Stdout,Stderr,ExitCode}.txt files.
 	})
 }
 
+// checkIdempotency runs Gazelle in workspaceRoot with gazelleArgs and
+// -mode=idempotency, and reports an error with Gazelle's output if it
+// finds files that change when Gazelle runs over its own output.
+func checkIdempotency(t *testing.T, args *TestGazelleGenerationArgs, gazelleArgs []string, workspaceRoot string) {
+	t.Helper()
+	var idempotencyArgs []string
+	if len(gazelleArgs) > 0 && (gazelleArgs[0] == "update" || gazelleArgs[0] == "fix") {
+		idempotencyArgs = append(idempotencyArgs, gazelleArgs[0])
+		gazelleArgs = gazelleArgs[1:]
+	}
+	idempotencyArgs = append(idempotencyArgs, "-mode=idempotency")
+	idempotencyArgs = append(idempotencyArgs, gazelleArgs...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args.GazelleBinaryPath, idempotencyArgs...)
+	cmd.Dir = workspaceRoot
+	cmd.Env = append(os.Environ(), fmt.Sprintf("BUILD_WORKSPACE_DIRECTORY=%v", workspaceRoot))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		var e *exec.ExitError
+		if !errors.As(err, &e) {
+			t.Fatal(err)
+		}
+		t.Errorf("build files changed when gazelle ran again over its own output:\n%s", redactWorkspacePath(string(out), workspaceRoot))
+	}
+}
+
 func copyFile(src string, dest string) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -58,6 +58,17 @@ func (wc *walkConfig) isExcluded(rel, base string) bool {
 	return matchAnyGlob(wc.excludes, path.Join(rel, base))
 }
 
+// withExcludes returns a copy of wc that also excludes files matching
+// patterns, which extensions set in config.Config.Excludes.
+func (wc *walkConfig) withExcludes(patterns []string) *walkConfig {
+	if len(patterns) == 0 {
+		return wc
+	}
+	wcCopy := *wc
+	wcCopy.excludes = append(wc.excludes[:len(wc.excludes):len(wc.excludes)], patterns...)
+	return &wcCopy
+}
+
 func (wc *walkConfig) shouldFollow(rel, base string) bool {
 	return matchAnyGlob(wc.follow, path.Join(rel, base))
 }
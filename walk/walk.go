@@ -18,6 +18,7 @@ limitations under the License.
 package walk
 
 import (
+	"context"
 	"io/fs"
 	"log"
 	"os"
@@ -108,6 +109,13 @@ type WalkFunc func(dir, rel string, c *config.Config, update bool, f *rule.File,
 //
 // wf is a function that may be called in each directory.
 func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode, wf WalkFunc) {
+	WalkContext(context.Background(), c, cexts, dirs, mode, wf)
+}
+
+// WalkContext is like Walk, but it stops when ctx is canceled. Once ctx is
+// done, no more directories are read or configured, and wf is not called
+// again, including in directories whose subdirectories were being visited.
+func WalkContext(ctx context.Context, c *config.Config, cexts []config.Configurer, dirs []string, mode Mode, wf WalkFunc) {
 	knownDirectives := make(map[string]bool)
 	for _, cext := range cexts {
 		for _, d := range cext.KnownDirectives() {
@@ -119,6 +127,9 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 
 	var visit func(*config.Config, string, string, bool)
 	visit = func(c *config.Config, dir, rel string, updateParent bool) {
+		if ctx.Err() != nil {
+			return
+		}
 		haveError := false
 
 		// TODO: OPT: ReadDir stats all the files, which is slow. We just care about
@@ -142,7 +153,7 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 		}
 
 		c = configure(cexts, knownDirectives, c, rel, f)
-		wc := getWalkConfig(c)
+		wc := getWalkConfig(c).withExcludes(c.Excludes)
 
 		if wc.isExcluded(rel, ".") {
 			return
@@ -169,6 +180,9 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 			}
 		}
 
+		if ctx.Err() != nil {
+			return
+		}
 		update := !haveError && !wc.ignore && shouldUpdate
 		if shouldCall(rel, mode, updateParent, updateRels) {
 			genFiles := findGenFiles(wc, f)
@@ -262,9 +276,20 @@ func loadBuildFile(c *config.Config, pkg, dir string, ents []fs.DirEntry) (*rule
 		}
 	}
 	path := rule.MatchBuildFile(readDir, c.ValidBuildFileNames, readEnts)
+	if path == "" {
+		for _, name := range c.ValidBuildFileNames {
+			if _, ok := c.BuildFileOverlay[filepath.Join(readDir, name)]; ok {
+				path = filepath.Join(readDir, name)
+				break
+			}
+		}
+	}
 	if path == "" {
 		return nil, nil
 	}
+	if data, ok := c.BuildFileOverlay[path]; ok {
+		return rule.LoadData(path, pkg, data)
+	}
 	return rule.LoadFile(path, pkg)
 }
 
@@ -16,6 +16,7 @@ limitations under the License.
 package walk
 
 import (
+	"context"
 	"flag"
 	"path"
 	"path/filepath"
@@ -49,6 +50,31 @@ func TestConfigureCallbackOrder(t *testing.T) {
 	}
 }
 
+func TestWalkContextCanceled(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "a/b/"}, {Path: "c/"}})
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var configureRels, callbackRels []string
+	c, cexts := testConfig(t, dir)
+	cexts = append(cexts, &testConfigurer{func(_ *config.Config, rel string, _ *rule.File) {
+		configureRels = append(configureRels, rel)
+	}})
+	WalkContext(ctx, c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		callbackRels = append(callbackRels, rel)
+		cancel()
+	})
+	configureWant := []string{"", "a", "a/b"}
+	if diff := cmp.Diff(configureWant, configureRels); diff != "" {
+		t.Errorf("configure order (-want +got):\n%s", diff)
+	}
+	callbackWant := []string{"a/b"}
+	if diff := cmp.Diff(callbackWant, callbackRels); diff != "" {
+		t.Errorf("callback order (-want +got):\n%s", diff)
+	}
+}
+
 func TestUpdateDirs(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{Path: "update/sub/"},
@@ -272,6 +298,32 @@ func TestExcludeSelf(t *testing.T) {
 	}
 }
 
+func TestConfigExcludes(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/keep"},
+		{Path: "a/skip/file"},
+		{Path: "b/skip/file"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	cexts = append(cexts, &testConfigurer{func(c *config.Config, rel string, _ *rule.File) {
+		if rel == "a" {
+			c.Excludes = append(c.Excludes[:len(c.Excludes):len(c.Excludes)], "a/skip")
+		}
+	}})
+	var files []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+		for _, f := range regularFiles {
+			files = append(files, path.Join(rel, f))
+		}
+	})
+	want := []string{"a/keep", "b/skip/file"}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Walk files (-want +got):\n%s", diff)
+	}
+}
+
 func TestGeneratedFiles(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{